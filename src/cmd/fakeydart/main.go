@@ -0,0 +1,42 @@
+// fakeydart - фейковый сервер YandexArt. Позволяет прогнать работу рамки без сети:
+// в options.yml у ydArt указывается base_url: http://127.0.0.1:8098
+package main
+
+import (
+	"flag"
+	"imgserver/internal/pkg/fakeydart"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8098", "listen address")
+	latency := flag.Duration("latency", 5*time.Second, "time until an operation is done")
+	requestDelay := flag.Duration("request-delay", 0, "delay of every HTTP response")
+	generateErrorRate := flag.Float64("generate-error-rate", 0, "share of generation requests failed with HTTP 500 (0..1)")
+	operationErrorRate := flag.Float64("operation-error-rate", 0, "share of operations finished with error (0..1)")
+	imageDir := flag.String("images", "", "directory with JPEG images to return. Plain color images are drawn if empty")
+	flag.Parse()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	server, err := fakeydart.NewServer(fakeydart.Options{
+		OperationLatency:   *latency,
+		RequestDelay:       *requestDelay,
+		GenerateErrorRate:  *generateErrorRate,
+		OperationErrorRate: *operationErrorRate,
+		ImageDir:           *imageDir,
+	}, logger)
+	if err != nil {
+		logger.Error("Can not create fake server", "error", err)
+		os.Exit(1)
+	}
+
+	logger.Info("Fake YandexArt started", "addr", *addr)
+	if err := http.ListenAndServe(*addr, server); err != nil {
+		logger.Error("Fake YandexArt stopped", "error", err)
+		os.Exit(1)
+	}
+}
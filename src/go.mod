@@ -10,9 +10,11 @@ require (
 	github.com/go-co-op/gocron/v2 v2.17.0
 	github.com/gorilla/mux v1.8.1
 	github.com/natefinch/lumberjack v2.0.0+incompatible
+	github.com/oklog/ulid/v2 v2.1.1
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/rcrowley/go-metrics v0.0.0-20250401214520-65e299d6c5c9
 	github.com/stretchr/testify v1.11.1
+	go.etcd.io/bbolt v1.4.3
 	golang.org/x/image v0.31.0
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -24,6 +26,7 @@ require (
 	github.com/kr/pretty v0.3.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/robfig/cron/v3 v3.0.1 // indirect
+	golang.org/x/sys v0.29.0 // indirect
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
@@ -5,15 +5,20 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/go-co-op/gocron/v2"
 	"github.com/natefinch/lumberjack"
-	"gopkg.in/yaml.v3"
 	"imgserver/internal/pkg/dirmanager"
+	"imgserver/internal/pkg/filewatcher"
+	"imgserver/internal/pkg/httpprovider"
 	"imgserver/internal/pkg/imageprocessor"
 	"imgserver/internal/pkg/localimageprovider"
 	"imgserver/internal/pkg/metrics"
 	"imgserver/internal/pkg/mylogger"
+	"imgserver/internal/pkg/openaiimg"
 	"imgserver/internal/pkg/opermanager"
+	"imgserver/internal/pkg/pluginprovider"
 	"imgserver/internal/pkg/promptmanager"
 	"imgserver/internal/pkg/rest"
+	"imgserver/internal/pkg/stablediffusion"
+	"imgserver/internal/pkg/storage"
 	"imgserver/internal/pkg/utils"
 	"imgserver/internal/pkg/ydart"
 	"log/slog"
@@ -22,8 +27,33 @@ import (
 	"time"
 )
 
+// Ключи встроенных провайдеров в disabled_providers. У провайдеров http и plugins ключ - это их код
+const (
+	PROVIDER_KEY_YDART  = "ydArt"
+	PROVIDER_KEY_LIM    = "lim"
+	PROVIDER_KEY_OPENAI = "openai"
+	PROVIDER_KEY_SD     = "stableDiffusion"
+)
+
+// builtinProviderCodes коды встроенных провайдеров по ключам disabled_providers
+var builtinProviderCodes = map[string]string{
+	PROVIDER_KEY_YDART:  ydart.ProviderCode,
+	PROVIDER_KEY_LIM:    localimageprovider.ProviderCode,
+	PROVIDER_KEY_OPENAI: openaiimg.ProviderCode,
+	PROVIDER_KEY_SD:     stablediffusion.ProviderCode,
+}
+
+// providerCode код провайдера по ключу из disabled_providers
+func providerCode(key string) string {
+	if code, ok := builtinProviderCodes[key]; ok {
+		return code
+	}
+	return key
+}
+
 const (
 	FILE_PATH_OPTIONS                 = "/data/options.yml"
+	FILE_PATH_STORAGE                 = "/data/imgserver.db"
 	refreshLocalImageProviderSchedule = "30 * * * *"
 )
 
@@ -37,11 +67,20 @@ type ImgSrv struct {
 	scheduleLogLevel gocron.LogLevel
 	metrics          *metrics.AppMetrics
 	lim              *localimageprovider.Lim
+	plugins          []*pluginprovider.PluginProvider
+	storage          *storage.Storage
+	watcher          *filewatcher.FileWatcher
+	// promptManagers основной набор промптов и наборы рамок, они перечитываются при изменении файлов
+	promptManagers []*promptmanager.PromptManager
 }
 
 type ProvidersOptions struct {
-	YdArtOptions *ydart.YdArtOptions            `yaml:"ydArt"`
-	LimOptions   *localimageprovider.LimOptions `yaml:"lim"`
+	YdArtOptions  *ydart.YdArtOptions                 `yaml:"ydArt"`
+	LimOptions    *localimageprovider.LimOptions      `yaml:"lim"`
+	OpenAiOptions *openaiimg.OpenAiOptions            `yaml:"openai"`
+	SdOptions     *stablediffusion.SdOptions          `yaml:"stableDiffusion"`
+	HttpOptions   []*httpprovider.HttpProviderOptions `yaml:"http"`
+	PluginOptions []*pluginprovider.PluginOptions     `yaml:"plugins"`
 }
 type IframeImageParameters struct {
 	ImageWeight  int     `yaml:"image_weight"`
@@ -49,19 +88,34 @@ type IframeImageParameters struct {
 	FitThreshold float64 `yaml:"fit_threshold"`
 }
 
+// DeviceOptions настройки отдельной рамки. Незаданные параметры берутся из общих настроек
+type DeviceOptions struct {
+	IframeImageParameters  *IframeImageParameters   `yaml:"iframe_image_parameters"`
+	ImageGenerateThreshold int                      `yaml:"image_generate_threshold"`
+	SleepTimes             []*opermanager.SleepTime `yaml:"sleep_time"`
+	Providers              []string                 `yaml:"providers"`
+	PromptsFile            string                   `yaml:"prompts_file"`
+}
+
 type ApplOptions struct {
-	LogLevel                      string                   `yaml:"log_level"`
-	ImagePath                     string                   `yaml:"image_path"`
-	ImageLimitMin                 int                      `yaml:"image_amount_min"`
-	ImageLimitMax                 int                      `yaml:"image_amount_max"`
-	ImageGenerateThreshold        int                      `yaml:"image_generate_threshold"`
-	CheckPendingOperationSchedule string                   `yaml:"check_pending_cron"`
-	ScanImageFolderSchedule       string                   `yaml:"scan_image_cron"`
-	IframeImageParameters         IframeImageParameters    `yaml:"iframe_image_parameters"`
-	SleepTimes                    []*opermanager.SleepTime `yaml:"sleep_time"`
-	ProvidersOptions              *ProvidersOptions        `yaml:"providers"`
-	DisabledProviders             []string                 `yaml:"disabled_providers"`
-	PromptsAmount                 int                      `yaml:"prompts_amount"`
+	LogLevel                      string                               `yaml:"log_level"`
+	ImagePath                     string                               `yaml:"image_path"`
+	ImageLimitMin                 int                                  `yaml:"image_amount_min"`
+	ImageLimitMax                 int                                  `yaml:"image_amount_max"`
+	ImageGenerateThreshold        int                                  `yaml:"image_generate_threshold"`
+	CheckPendingOperationSchedule string                               `yaml:"check_pending_cron"`
+	ScanImageFolderSchedule       string                               `yaml:"scan_image_cron"`
+	IframeImageParameters         IframeImageParameters                `yaml:"iframe_image_parameters"`
+	SleepTimes                    []*opermanager.SleepTime             `yaml:"sleep_time"`
+	ProvidersOptions              *ProvidersOptions                    `yaml:"providers"`
+	DisabledProviders             []string                             `yaml:"disabled_providers"`
+	PromptsAmount                 int                                  `yaml:"prompts_amount"`
+	ProviderSelection             opermanager.SelectionOptions         `yaml:"provider_selection"`
+	ProviderHealth                opermanager.HealthOptions            `yaml:"provider_health"`
+	Budgets                       map[string]opermanager.BudgetOptions `yaml:"budgets"`
+	Prefetch                      opermanager.PrefetchOptions          `yaml:"prefetch"`
+	Devices                       map[string]DeviceOptions             `yaml:"devices"`
+	History                       opermanager.HistoryOptions           `yaml:"history"`
 }
 
 func defaultConfig() ApplOptions {
@@ -74,6 +128,7 @@ func defaultConfig() ApplOptions {
 		ImageLimitMax:                 2000,
 		PromptsAmount:                 10,
 		IframeImageParameters:         ifp,
+		Prefetch:                      opermanager.PrefetchOptions{RefillCron: "*/5 * * * *"},
 	}
 }
 
@@ -135,9 +190,15 @@ func NewImgSrv(port string) *ImgSrv {
 
 	appMetrics := metrics.NewAppMetrics()
 
+	appStorage, err := storage.NewStorage(FILE_PATH_STORAGE, logger)
+	if err != nil {
+		logger.Error("Error create Storage", "error", err)
+		panic(fmt.Sprintf("error create Storage %v", err))
+	}
+
 	promptManager, err := promptmanager.NewPromptManager(options.PromptsAmount, logger)
 	if err != nil {
-		logger.Error("Error create PromptManager %v", err)
+		logger.Error("Error create PromptManager", "error", err)
 		panic(fmt.Sprintf("error create PromptManager %v", err))
 	}
 
@@ -145,7 +206,7 @@ func NewImgSrv(port string) *ImgSrv {
 
 	dirManager, err := dirmanager.NewDirManager(originalImagePath, options.ImageLimitMin, options.ImageLimitMax, logger)
 	if err != nil {
-		logger.Error("Error create DirManager %v", err)
+		logger.Error("Error create DirManager", "error", err)
 		panic(fmt.Sprintf("error create DirManager %v", err))
 	}
 
@@ -157,13 +218,56 @@ func NewImgSrv(port string) *ImgSrv {
 
 	operMng, err := opermanager.NewOperMngr(options.ImageGenerateThreshold,
 		imgPrmt,
-		options.SleepTimes, dirManager, appMetrics, logger)
+		options.SleepTimes, dirManager, appMetrics, appStorage, logger)
 
 	if err != nil {
-		logger.Error("Error create OperManager %v", err)
+		logger.Error("Error create OperManager", "error", err)
 		panic(fmt.Sprintf("error create OperManager %v", err))
 	}
 
+	err = operMng.SetSelectionOptions(options.ProviderSelection)
+	if err != nil {
+		logger.Error("Error set provider selection", "error", err)
+		panic(fmt.Sprintf("error set provider selection %v", err))
+	}
+
+	err = operMng.SetHealthOptions(options.ProviderHealth)
+	if err != nil {
+		logger.Error("Error set provider health", "error", err)
+		panic(fmt.Sprintf("error set provider health %v", err))
+	}
+
+	err = operMng.SetBudgetOptions(options.Budgets)
+	if err != nil {
+		logger.Error("Error set provider budgets", "error", err)
+		panic(fmt.Sprintf("error set provider budgets %v", err))
+	}
+
+	err = operMng.SetPrefetchOptions(options.Prefetch)
+	if err != nil {
+		logger.Error("Error set prefetch options", "error", err)
+		panic(fmt.Sprintf("error set prefetch options %v", err))
+	}
+
+	err = operMng.SetHistoryOptions(options.History)
+	if err != nil {
+		logger.Error("Error set history options", "error", err)
+		panic(fmt.Sprintf("error set history options %v", err))
+	}
+
+	promptManagers := []*promptmanager.PromptManager{promptManager}
+	for id, deviceOptions := range options.Devices {
+		deviceParameters := newDeviceParameters(imgPrmt, deviceOptions, options.PromptsAmount, logger)
+		err = operMng.AddDevice(id, deviceParameters)
+		if err != nil {
+			logger.Error("Error add device", "device", id, "error", err)
+			panic(fmt.Sprintf("error add device %s: %v", id, err))
+		}
+		if devicePrompts, ok := deviceParameters.Prompts.(*promptmanager.PromptManager); ok {
+			promptManagers = append(promptManagers, devicePrompts)
+		}
+	}
+
 	imgsrv := ImgSrv{
 		options:          options,
 		logger:           logger,
@@ -171,41 +275,99 @@ func NewImgSrv(port string) *ImgSrv {
 		operManager:      operMng,
 		scheduleLogLevel: scheduleLogLevel,
 		metrics:          appMetrics,
+		storage:          appStorage,
+		watcher:          filewatcher.NewFileWatcher(logger),
+		promptManagers:   promptManagers,
 	}
 
 	// Создание провайдеров
 
-	if !utils.Contains(options.DisabledProviders, "ydArt") && options.ProvidersOptions.YdArtOptions != nil {
+	if !utils.Contains(options.DisabledProviders, PROVIDER_KEY_YDART) && options.ProvidersOptions.YdArtOptions != nil {
 		ydArt := ydart.NewYdArt(imgPrmt, promptManager, logger, options.ProvidersOptions.YdArtOptions)
 		iYdArt := (opermanager.ImageProvider)(ydArt)
 		err = iYdArt.SetImageParameters(&imageParameters)
 		if err != nil {
-			logger.Error("Error setting image parameters for ydArt: %v", err)
+			logger.Error("Error setting image parameters for ydArt", "error", err)
 			panic(fmt.Sprintf("error setting image parameters for ydArt: %v", err))
 		}
 
 		operMng.AddImageProvider(&iYdArt)
 	}
-	if !utils.Contains(options.DisabledProviders, "lim") && options.ProvidersOptions.LimOptions != nil {
+	if !utils.Contains(options.DisabledProviders, PROVIDER_KEY_LIM) && options.ProvidersOptions.LimOptions != nil {
 		lim, err := localimageprovider.NewLim(imgPrmt, logger, options.ProvidersOptions.LimOptions)
 		if err != nil {
-			logger.Error("Error create lim provider: %v", err)
+			logger.Error("Error create lim provider", "error", err)
 			panic(fmt.Sprintf("error create lim provider: %v", err))
 		}
 		iLim := (opermanager.ImageProvider)(lim)
 		err = iLim.SetImageParameters(&imageParameters)
 		if err != nil {
-			logger.Error("Error setting image parameters for lim: %v", err)
+			logger.Error("Error setting image parameters for lim", "error", err)
 			panic(fmt.Sprintf("error setting image parameters for lim: %v", err))
 		}
 
 		operMng.AddImageProvider(&iLim)
 		imgsrv.lim = lim
 	}
+	if !utils.Contains(options.DisabledProviders, PROVIDER_KEY_OPENAI) && options.ProvidersOptions.OpenAiOptions != nil {
+		openAi := openaiimg.NewOpenAiImg(imgPrmt, promptManager, logger, options.ProvidersOptions.OpenAiOptions)
+		iOpenAi := (opermanager.ImageProvider)(openAi)
+		err = iOpenAi.SetImageParameters(&imageParameters)
+		if err != nil {
+			logger.Error("Error setting image parameters for openai", "error", err)
+			panic(fmt.Sprintf("error setting image parameters for openai: %v", err))
+		}
+
+		operMng.AddImageProvider(&iOpenAi)
+	}
+	if !utils.Contains(options.DisabledProviders, PROVIDER_KEY_SD) && options.ProvidersOptions.SdOptions != nil {
+		sd := stablediffusion.NewStableDiffusion(imgPrmt, promptManager, logger, options.ProvidersOptions.SdOptions)
+		iSd := (opermanager.ImageProvider)(sd)
+		err = iSd.SetImageParameters(&imageParameters)
+		if err != nil {
+			logger.Error("Error setting image parameters for stableDiffusion", "error", err)
+			panic(fmt.Sprintf("error setting image parameters for stableDiffusion: %v", err))
+		}
+
+		operMng.AddImageProvider(&iSd)
+	}
+	for _, httpOptions := range options.ProvidersOptions.HttpOptions {
+		if utils.Contains(options.DisabledProviders, httpOptions.Code) {
+			continue
+		}
+		hp := httpprovider.NewHttpProvider(imgPrmt, promptManager, logger, httpOptions)
+		iHp := (opermanager.ImageProvider)(hp)
+		err = iHp.SetImageParameters(&imageParameters)
+		if err != nil {
+			logger.Error("Error setting image parameters for http provider", "provider", httpOptions.Code, "error", err)
+			panic(fmt.Sprintf("error setting image parameters for http provider %s: %v", httpOptions.Code, err))
+		}
+
+		operMng.AddImageProvider(&iHp)
+	}
+	for _, pluginOptions := range options.ProvidersOptions.PluginOptions {
+		if utils.Contains(options.DisabledProviders, pluginOptions.Code) {
+			continue
+		}
+		plugin, err := pluginprovider.NewPluginProvider(imgPrmt, promptManager, logger, pluginOptions)
+		if err != nil {
+			logger.Error("Error create plugin provider", "provider", pluginOptions.Code, "error", err)
+			panic(fmt.Sprintf("error create plugin provider %s: %v", pluginOptions.Code, err))
+		}
+		iPlugin := (opermanager.ImageProvider)(plugin)
+		err = iPlugin.SetImageParameters(&imageParameters)
+		if err != nil {
+			logger.Error("Error setting image parameters for plugin provider", "provider", pluginOptions.Code, "error", err)
+			panic(fmt.Sprintf("error setting image parameters for plugin provider %s: %v", pluginOptions.Code, err))
+		}
+
+		operMng.AddImageProvider(&iPlugin)
+		imgsrv.plugins = append(imgsrv.plugins, plugin)
+	}
 
 	restObj, err := rest.NewRest(port, logger, operMng, promptManager, appMetrics)
 	if err != nil {
-		logger.Error("Error create Rest %v", err)
+		logger.Error("Error create Rest", "error", err)
 		panic(fmt.Sprintf("error create Rest %v", err))
 	}
 
@@ -214,6 +376,38 @@ func NewImgSrv(port string) *ImgSrv {
 	return &imgsrv
 }
 
+// newDeviceParameters дополняет настройки рамки общими настройками сервера
+func newDeviceParameters(imgPrmt imageprocessor.ImageParameters, options DeviceOptions, promptsAmount int, logger *slog.Logger) opermanager.DeviceParameters {
+	if options.IframeImageParameters != nil {
+		if options.IframeImageParameters.ImageWeight > 0 {
+			imgPrmt.ImageWeight = options.IframeImageParameters.ImageWeight
+		}
+		if options.IframeImageParameters.ImageHeight > 0 {
+			imgPrmt.ImageHeight = options.IframeImageParameters.ImageHeight
+		}
+		if options.IframeImageParameters.FitThreshold > 0 {
+			imgPrmt.FitThreshold = options.IframeImageParameters.FitThreshold
+		}
+	}
+
+	parameters := opermanager.DeviceParameters{
+		ImageParameters:  imgPrmt,
+		ThresholdMinutes: options.ImageGenerateThreshold,
+		SleepTimes:       options.SleepTimes,
+		Providers:        options.Providers,
+	}
+
+	if options.PromptsFile != "" {
+		prompts, err := promptmanager.NewPromptManagerWithFile(options.PromptsFile, promptsAmount, logger)
+		if err != nil {
+			logger.Error("Error create device PromptManager", "file", options.PromptsFile, "error", err)
+			panic(fmt.Sprintf("error create device PromptManager %v", err))
+		}
+		parameters.Prompts = prompts
+	}
+	return parameters
+}
+
 func (app *ImgSrv) Start() {
 	app.metrics.Start()
 	err := app.dirManager.Start()
@@ -266,6 +460,39 @@ func (app *ImgSrv) Start() {
 		),
 	)
 
+	// Пополнение очереди свежих изображений. Задание создаётся и при выключенной очереди,
+	// чтобы её можно было включить без перезапуска
+	_, err = app.scheduler.NewJob(
+		gocron.CronJob(
+			// standard cron tab parsing
+			app.options.Prefetch.RefillCron,
+			false,
+		),
+		gocron.NewTask(
+			func() {
+				app.operManager.RefillPrefetchQueue()
+			},
+		),
+	)
+	if err != nil {
+		app.logger.Error("Error create prefetch task", "error", err)
+	}
+
+	// Перечитывание настроек и промптов при изменении файлов
+	app.watchConfigFiles()
+	_, err = app.scheduler.NewJob(
+		gocron.DurationJob(CONFIG_WATCH_INTERVAL),
+		gocron.NewTask(
+			func() {
+				app.watcher.Check()
+			},
+		),
+		gocron.WithSingletonMode(gocron.LimitModeReschedule),
+	)
+	if err != nil {
+		app.logger.Error("Error create config watch task", "error", err)
+	}
+
 	// Обновление данных провайдера локальных изображений
 	if app.lim != nil {
 		app.logger.Debug("Create refresh local image provider task")
@@ -297,18 +524,15 @@ func (app *ImgSrv) Start() {
 
 func (app *ImgSrv) Stop() {
 	_ = app.scheduler.Shutdown()
+	for _, plugin := range app.plugins {
+		plugin.Close()
+	}
+	_ = app.storage.Close()
 }
 
 func readOptions() (ApplOptions, error) {
 	plan, _ := os.ReadFile(FILE_PATH_OPTIONS)
-	data := defaultConfig()
-	err := yaml.Unmarshal(plan, &data)
-
-	if data.ImageLimitMin >= data.ImageLimitMax {
-		panic("Option image_amount_min must be lower then image_amount_max")
-	}
-
-	return data, err
+	return parseOptions(plan)
 }
 
 func logTimezoneConfiguration(logger *slog.Logger) {
@@ -0,0 +1,191 @@
+package appimageserver
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"imgserver/internal/pkg/opermanager"
+	"os"
+	"reflect"
+	"slices"
+	"time"
+)
+
+// CONFIG_WATCH_INTERVAL как часто проверяется изменение файлов настроек и промптов
+const CONFIG_WATCH_INTERVAL = 10 * time.Second
+
+// liveOptionKeys настройки, которые применяются без перезапуска. У prefetch и devices на ходу
+// меняется только часть параметров, остальные проверяет restartRequiredChanges
+var liveOptionKeys = []string{
+	"image_generate_threshold",
+	"sleep_time",
+	"disabled_providers",
+	"provider_selection",
+	"provider_health",
+	"budgets",
+	"prefetch",
+	"history",
+	"devices",
+}
+
+// watchConfigFiles подписывается на изменения options.yml и файлов промптов
+func (app *ImgSrv) watchConfigFiles() {
+	app.watcher.Add(FILE_PATH_OPTIONS, app.reloadOptions)
+	for _, pm := range app.promptManagers {
+		app.watcher.Add(pm.GetFilePath(), func() {
+			if err := pm.Reload(); err != nil {
+				app.logger.Error("Prompts not reloaded, keep previous prompts", "file", pm.GetFilePath(), "error", err)
+			}
+		})
+	}
+}
+
+// reloadOptions перечитывает options.yml. Файл с ошибками отклоняется целиком и сервер работает по прежним настройкам
+func (app *ImgSrv) reloadOptions() {
+	plan, err := os.ReadFile(FILE_PATH_OPTIONS)
+	if err != nil {
+		app.logger.Error("Can not read options file", "file", FILE_PATH_OPTIONS, "error", err)
+		return
+	}
+	options, err := parseOptions(plan)
+	if err != nil {
+		app.logOptionsRejected(err)
+		return
+	}
+
+	changed := changedOptions(app.options, options)
+	if len(changed) == 0 {
+		app.logger.Debug("Options not changed")
+		return
+	}
+	for _, key := range app.restartRequiredChanges(options, changed) {
+		app.logger.Warn("Option changed, restart required to apply it", "option", key)
+	}
+
+	err = app.operManager.ApplyLiveOptions(liveOptions(options))
+	if err != nil {
+		app.logOptionsRejected(err)
+		return
+	}
+	app.options = options
+	app.logger.Info("Options reloaded", "changed", changed)
+}
+
+// logOptionsRejected пишет каждую ошибку отдельной строкой
+func (app *ImgSrv) logOptionsRejected(err error) {
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	for _, err := range errs {
+		app.logger.Error("Options file rejected, keep previous options", "file", FILE_PATH_OPTIONS, "error", err)
+	}
+}
+
+// restartRequiredChanges изменённые настройки, которые вступят в силу только после перезапуска
+func (app *ImgSrv) restartRequiredChanges(options ApplOptions, changed []string) []string {
+	var result []string
+	for _, key := range changed {
+		if !slices.Contains(liveOptionKeys, key) {
+			result = append(result, key)
+		}
+	}
+
+	if options.Prefetch.RefillCron != app.options.Prefetch.RefillCron {
+		result = append(result, "prefetch.refill_cron")
+	}
+	for id, deviceOptions := range options.Devices {
+		oldOptions, ok := app.options.Devices[id]
+		if !ok {
+			result = append(result, "devices."+id)
+			continue
+		}
+		if !sameYaml(deviceOptions.IframeImageParameters, oldOptions.IframeImageParameters) {
+			result = append(result, "devices."+id+".iframe_image_parameters")
+		}
+		if deviceOptions.PromptsFile != oldOptions.PromptsFile {
+			result = append(result, "devices."+id+".prompts_file")
+		}
+	}
+	for id := range app.options.Devices {
+		if _, ok := options.Devices[id]; !ok {
+			result = append(result, "devices."+id)
+		}
+	}
+
+	// Провайдеры, выключенные при запуске, не создавались
+	var existing []string
+	for _, health := range app.operManager.GetProvidersHealth() {
+		existing = append(existing, health.Code)
+	}
+	for _, key := range app.options.DisabledProviders {
+		if !slices.Contains(options.DisabledProviders, key) && !slices.Contains(existing, providerCode(key)) {
+			result = append(result, "disabled_providers."+key)
+		}
+	}
+	return result
+}
+
+// liveOptions настройки для применения без перезапуска
+func liveOptions(options ApplOptions) opermanager.LiveOptions {
+	devices := make(map[string]opermanager.DeviceLiveOptions, len(options.Devices))
+	for id, deviceOptions := range options.Devices {
+		devices[id] = opermanager.DeviceLiveOptions{
+			ThresholdMinutes: deviceOptions.ImageGenerateThreshold,
+			SleepTimes:       deviceOptions.SleepTimes,
+			Providers:        deviceOptions.Providers,
+		}
+	}
+
+	disabled := make([]string, 0, len(options.DisabledProviders))
+	for _, key := range options.DisabledProviders {
+		disabled = append(disabled, providerCode(key))
+	}
+
+	return opermanager.LiveOptions{
+		ThresholdMinutes:  options.ImageGenerateThreshold,
+		SleepTimes:        options.SleepTimes,
+		DisabledProviders: disabled,
+		Selection:         options.ProviderSelection,
+		Health:            options.ProviderHealth,
+		Budgets:           options.Budgets,
+		Prefetch:          options.Prefetch,
+		History:           options.History,
+		Devices:           devices,
+	}
+}
+
+// changedOptions ключи верхнего уровня options.yml, значения которых отличаются
+func changedOptions(oldOptions ApplOptions, newOptions ApplOptions) []string {
+	var changed []string
+	oldValue := reflect.ValueOf(oldOptions)
+	newValue := reflect.ValueOf(newOptions)
+	for i := 0; i < oldValue.NumField(); i++ {
+		if !sameYaml(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			changed = append(changed, oldValue.Type().Field(i).Tag.Get("yaml"))
+		}
+	}
+	return changed
+}
+
+// sameYaml сравнивает настройки так, как они записаны в файле. Внутренние кэши, например у периодов сна, не учитываются
+func sameYaml(a interface{}, b interface{}) bool {
+	aData, errA := yaml.Marshal(a)
+	bData, errB := yaml.Marshal(b)
+	return errA == nil && errB == nil && string(aData) == string(bData)
+}
+
+// parseOptions настройки из содержимого options.yml поверх настроек по умолчанию
+func parseOptions(plan []byte) (ApplOptions, error) {
+	data := defaultConfig()
+	err := yaml.Unmarshal(plan, &data)
+	if err != nil {
+		return data, fmt.Errorf("can not parse options: %w", err)
+	}
+
+	var errs []error
+	if data.ImageLimitMin >= data.ImageLimitMax {
+		errs = append(errs, errors.New("option image_amount_min must be lower then image_amount_max"))
+	}
+	return data, errors.Join(errs...)
+}
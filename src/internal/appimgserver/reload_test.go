@@ -0,0 +1,92 @@
+package appimageserver
+
+import (
+	"imgserver/internal/pkg/imageprocessor"
+	"imgserver/internal/pkg/metrics"
+	"imgserver/internal/pkg/openaiimg"
+	"imgserver/internal/pkg/opermanager"
+	"imgserver/internal/pkg/ydart"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReload_ChangedOptions(t *testing.T) {
+	oldOptions, err := parseOptions([]byte(`
+image_generate_threshold: 30
+sleep_time:
+  - time_range:
+      start_time: "23:00"
+      end_time: "07:00"
+`))
+	require.NoError(t, err)
+	// Периоды сна кэшируют разобранное время, это не изменение настроек
+	_, err = oldOptions.SleepTimes[0].TimeRange.IsWithinRangeInclusive(time.Now())
+	require.NoError(t, err)
+
+	newOptions, err := parseOptions([]byte(`
+image_generate_threshold: 60
+log_level: DEBUG
+sleep_time:
+  - time_range:
+      start_time: "23:00"
+      end_time: "07:00"
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"log_level", "image_generate_threshold"}, changedOptions(oldOptions, newOptions))
+
+	live := liveOptions(newOptions)
+	assert.Equal(t, 60, live.ThresholdMinutes)
+	assert.Len(t, live.SleepTimes, 1)
+}
+
+func TestReload_ParseOptionsErrors(t *testing.T) {
+	_, err := parseOptions([]byte("image_amount_min: 10\nimage_amount_max: 5\n"))
+	assert.ErrorContains(t, err, "image_amount_min")
+
+	_, err = parseOptions([]byte("sleep_time: [\n"))
+	assert.ErrorContains(t, err, "can not parse options")
+}
+
+// fakeProvider провайдер, который ничего не генерирует. Нужен только его код
+type fakeProvider struct {
+	code string
+}
+
+func (fp *fakeProvider) Start() error                                          { return nil }
+func (fp *fakeProvider) GetImageProviderForImageServerName() string            { return fp.code }
+func (fp *fakeProvider) GetImageProviderCode() string                          { return fp.code }
+func (fp *fakeProvider) Generate(bool) (string, error)                         { return "", nil }
+func (fp *fakeProvider) GetImageSlice(string) (bool, []byte, error)            { return false, nil, nil }
+func (fp *fakeProvider) IsReadyForRequest() bool                               { return true }
+func (fp *fakeProvider) SetImageParameters(*opermanager.ImageParameters) error { return nil }
+func (fp *fakeProvider) GetProperties() *opermanager.ProviderProperties {
+	return &opermanager.ProviderProperties{}
+}
+func (fp *fakeProvider) GenerateWithPrompt(string, string, bool) (string, error) {
+	return "", nil
+}
+
+func TestReload_DisabledProviders(t *testing.T) {
+	t.Chdir(t.TempDir())
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	operMng, err := opermanager.NewOperMngr(0, imageprocessor.ImageParameters{ImageWeight: 40, ImageHeight: 30}, nil, nil, metrics.NewAppMetrics(), nil, logger)
+	require.NoError(t, err)
+	var provider opermanager.ImageProvider = &fakeProvider{code: openaiimg.ProviderCode}
+	operMng.AddImageProvider(&provider)
+
+	// Ключи встроенных провайдеров переводятся в их коды, ключи http и plugins - это и есть коды
+	options, err := parseOptions([]byte("disabled_providers: [ydArt, openai, myHttp]\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{ydart.ProviderCode, openaiimg.ProviderCode, "myHttp"}, liveOptions(options).DisabledProviders)
+
+	// openai создан при запуске и включается сразу, ydArt при запуске не создавался
+	app := &ImgSrv{options: options, operManager: operMng, logger: logger}
+	enabled, err := parseOptions([]byte("disabled_providers: [myHttp]\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"disabled_providers.ydArt"}, app.restartRequiredChanges(enabled, changedOptions(options, enabled)))
+}
@@ -1,20 +1,35 @@
 package actioner
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
 type Actioner struct {
 	lastCallTime time.Time
 	threshold    time.Duration
+	mutex        sync.Mutex
 }
 
 func NewActioner(threshold int, thresholdDimensionType time.Duration) *Actioner {
-	return &Actioner{time.Time{}, time.Duration(threshold) * thresholdDimensionType}
+	return &Actioner{lastCallTime: time.Time{}, threshold: time.Duration(threshold) * thresholdDimensionType}
 }
 
 func (act *Actioner) ThresholdOut(now time.Time) bool {
+	act.mutex.Lock()
+	defer act.mutex.Unlock()
 	return now.Sub(act.lastCallTime) >= act.threshold
 }
 
 func (act *Actioner) SetLastCallTime(now time.Time) {
+	act.mutex.Lock()
+	defer act.mutex.Unlock()
 	act.lastCallTime = now
 }
+
+// SetThreshold меняет порог без сброса времени последнего вызова
+func (act *Actioner) SetThreshold(threshold int, thresholdDimensionType time.Duration) {
+	act.mutex.Lock()
+	defer act.mutex.Unlock()
+	act.threshold = time.Duration(threshold) * thresholdDimensionType
+}
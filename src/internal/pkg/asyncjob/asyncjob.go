@@ -0,0 +1,83 @@
+package asyncjob
+
+import (
+	"fmt"
+	"imgserver/internal/pkg/utils"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// JOB_TTL время жизни задания. Результат, который никто не забрал, удаляется вместе с изображением
+const JOB_TTL = 1 * time.Hour
+
+// Jobs выполняет синхронные запросы к провайдеру в фоне и отдаёт результат
+// в терминах ImageProvider: Generate возвращает идентификатор, GetImageSlice - готовность
+type Jobs struct {
+	prefix string
+	ttl    time.Duration
+	jobs   *cache.Cache
+	mutex  sync.Mutex
+}
+
+type job struct {
+	done  bool
+	image []byte
+	err   error
+}
+
+func NewJobs(prefix string) *Jobs {
+	return newJobs(prefix, JOB_TTL)
+}
+
+func newJobs(prefix string, ttl time.Duration) *Jobs {
+	return &Jobs{prefix: prefix, ttl: ttl, jobs: cache.New(ttl, 2*ttl)}
+}
+
+// Start запускает fn в отдельной горутине и возвращает идентификатор задания
+func (j *Jobs) Start(fn func() ([]byte, error)) string {
+	id := j.prefix + utils.NewUlid()
+	item := &job{}
+
+	j.jobs.Set(id, item, j.ttl)
+
+	go func() {
+		image, err := fn()
+
+		j.mutex.Lock()
+		defer j.mutex.Unlock()
+		item.image = image
+		item.err = err
+		item.done = true
+		// Время жизни отсчитывается от завершения: долгая генерация не должна съедать время на получение результата
+		if _, ok := j.jobs.Get(id); ok {
+			j.jobs.Set(id, item, j.ttl)
+		}
+	}()
+
+	return id
+}
+
+// Result возвращает результат задания. Завершённое задание отдаётся один раз
+func (j *Jobs) Result(id string) (bool, []byte, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	object, ok := j.jobs.Get(id)
+	if !ok {
+		// Задание могло потеряться при перезапуске сервера или устареть
+		return true, nil, fmt.Errorf("job not found: %s", id)
+	}
+
+	item := object.(*job)
+	if !item.done {
+		return false, nil, nil
+	}
+
+	j.jobs.Delete(id)
+	if item.err != nil {
+		return true, nil, item.err
+	}
+	return true, item.image, nil
+}
@@ -0,0 +1,64 @@
+package asyncjob
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func waitDone(t *testing.T, jobs *Jobs, id string) {
+	require.Eventually(t, func() bool {
+		jobs.mutex.Lock()
+		defer jobs.mutex.Unlock()
+		object, ok := jobs.jobs.Get(id)
+		return ok && object.(*job).done
+	}, time.Second, time.Millisecond)
+}
+
+func TestJobs_Result(t *testing.T) {
+	jobs := NewJobs("t")
+	release := make(chan struct{})
+	id := jobs.Start(func() ([]byte, error) {
+		<-release
+		return []byte{1, 2}, nil
+	})
+	failedId := jobs.Start(func() ([]byte, error) { return nil, errors.New("failed") })
+
+	done, image, err := jobs.Result(id)
+	require.NoError(t, err)
+	assert.False(t, done)
+	assert.Nil(t, image)
+
+	close(release)
+	waitDone(t, jobs, id)
+	done, image, err = jobs.Result(id)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, []byte{1, 2}, image)
+
+	// Результат отдаётся один раз
+	done, _, err = jobs.Result(id)
+	assert.True(t, done)
+	assert.Error(t, err)
+
+	waitDone(t, jobs, failedId)
+	done, _, err = jobs.Result(failedId)
+	assert.True(t, done)
+	assert.EqualError(t, err, "failed")
+}
+
+func TestJobs_Expire(t *testing.T) {
+	jobs := newJobs("t", 20*time.Millisecond)
+	id := jobs.Start(func() ([]byte, error) { return []byte{1}, nil })
+	waitDone(t, jobs, id)
+
+	// Незабранный результат удаляется по истечении времени жизни
+	require.Eventually(t, func() bool { return jobs.jobs.ItemCount() == 0 }, time.Second, 5*time.Millisecond)
+	done, image, err := jobs.Result(id)
+	assert.True(t, done)
+	assert.Nil(t, image)
+	assert.Error(t, err)
+}
@@ -19,6 +19,15 @@ type fileInfo struct {
 	ModTime time.Time
 }
 
+// FileListener узнаёт об изменениях списка файлов, например чтобы поддерживать индекс метаданных.
+// Вызывается без удержания блокировки DirManager
+type FileListener interface {
+	// FilesRead полный список файлов после чтения каталога
+	FilesRead(files []string)
+	// FilesRemoved файлы, удалённые очисткой
+	FilesRemoved(files []string)
+}
+
 // DirManager управляет файлами в заданном каталоге
 type DirManager struct {
 	directoryPath string
@@ -27,8 +36,11 @@ type DirManager struct {
 	limitMax      int
 	useCleanup    bool
 	fileMap       map[string]struct{}
-	mutex         sync.Mutex
-	logger        *slog.Logger
+	// pinned закреплённые файлы. Очистка их не удаляет и не учитывает в лимите
+	pinned   map[string]struct{}
+	mutex    sync.Mutex
+	listener FileListener
+	logger   *slog.Logger
 }
 
 // NewDirManager создает новый экземпляр DirManager
@@ -41,6 +53,7 @@ func NewDirManager(path string, limitMin int, limitMax int, logger *slog.Logger)
 		logger:        logger,
 		fileList:      []fileInfo{},
 		fileMap:       make(map[string]struct{}),
+		pinned:        make(map[string]struct{}),
 	}
 
 	return manager, nil
@@ -56,10 +69,19 @@ func NewDirManagerWithoutCleanup(path string, logger *slog.Logger) (*DirManager,
 		logger:        logger,
 		fileList:      []fileInfo{},
 		fileMap:       make(map[string]struct{}),
+		pinned:        make(map[string]struct{}),
 	}
 
 	return manager, nil
 }
+
+// SetListener задаёт получателя изменений списка файлов
+func (dm *DirManager) SetListener(listener FileListener) {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+	dm.listener = listener
+}
+
 func (dm *DirManager) Start() error {
 	exists, err := dm.IsDirectoryExists()
 	if err != nil {
@@ -117,10 +139,24 @@ func (dm *DirManager) ReadFiles() error {
 	}
 
 	dm.mutex.Lock()
-	defer dm.mutex.Unlock()
 	dm.fileList = fileList
 	dm.fileMap = fileMap
-	dm.logger.Debug("Read files ", "path", dm.directoryPath, "fileAmount", len(dm.fileList))
+	for name := range dm.pinned {
+		if _, exists := fileMap[name]; !exists {
+			delete(dm.pinned, name)
+		}
+	}
+	listener := dm.listener
+	dm.mutex.Unlock()
+	dm.logger.Debug("Read files ", "path", dm.directoryPath, "fileAmount", len(fileList))
+
+	if listener != nil {
+		names := make([]string, 0, len(fileList))
+		for _, file := range fileList {
+			names = append(names, file.Name)
+		}
+		listener.FilesRead(names)
+	}
 	return nil
 }
 
@@ -138,8 +174,75 @@ func (dm *DirManager) GetRandomFile() string {
 	return dm.fileList[index].Name
 }
 
+// GetFiles возвращает копию списка файлов
+func (dm *DirManager) GetFiles() []string {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	files := make([]string, 0, len(dm.fileList))
+	for _, file := range dm.fileList {
+		files = append(files, file.Name)
+	}
+	return files
+}
+
+// HasFile проверяет, есть ли файл в списке
+func (dm *DirManager) HasFile(filename string) bool {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	_, exists := dm.fileMap[filename]
+	return exists
+}
+
+// SetPinned закрепляет файл или снимает закрепление
+func (dm *DirManager) SetPinned(filename string, pinned bool) {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	if pinned {
+		dm.pinned[filename] = struct{}{}
+	} else {
+		delete(dm.pinned, filename)
+	}
+}
+
+// RemoveFile удаляет файл из каталога и списка
+func (dm *DirManager) RemoveFile(filename string) error {
+	dm.mutex.Lock()
+	if _, exists := dm.fileMap[filename]; !exists {
+		dm.mutex.Unlock()
+		return fs.ErrNotExist
+	}
+	err := os.Remove(filename)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		dm.mutex.Unlock()
+		return err
+	}
+	delete(dm.fileMap, filename)
+	delete(dm.pinned, filename)
+	for i, file := range dm.fileList {
+		if file.Name == filename {
+			dm.fileList = append(dm.fileList[:i], dm.fileList[i+1:]...)
+			break
+		}
+	}
+	dm.mutex.Unlock()
+	dm.logger.Debug("Remove file", "filename", filename)
+
+	dm.notifyRemoved([]string{filename})
+	return nil
+}
+
 // AddFile добавляет новый файл в каталог и список, если он еще не существует
 func (dm *DirManager) AddFile(filename string) error {
+	removed := dm.addFile(filename)
+	dm.notifyRemoved(removed)
+	return nil
+}
+
+// addFile возвращает файлы, удалённые очисткой
+func (dm *DirManager) addFile(filename string) []string {
 	dm.logger.Debug("Add file operation", "filename", filename)
 	dm.mutex.Lock()
 	defer dm.mutex.Unlock()
@@ -172,8 +275,8 @@ func (dm *DirManager) AddFile(filename string) error {
 	})
 	dm.fileMap[fullPath] = struct{}{}
 	// Проверяем лимит и очищаем, если необходимо
-	if len(dm.fileList) > dm.limitMax {
-		dm.innerCleanUp()
+	if dm.unpinnedCount() > dm.limitMax {
+		return dm.innerCleanUp()
 	}
 	return nil
 }
@@ -181,22 +284,46 @@ func (dm *DirManager) AddFile(filename string) error {
 // CleanUp удаляет наиболее старые файлы, если количество файлов превышает заданный предел
 func (dm *DirManager) CleanUp() {
 	dm.mutex.Lock()
-	defer dm.mutex.Unlock()
+	removed := dm.innerCleanUp()
+	dm.mutex.Unlock()
+
+	dm.notifyRemoved(removed)
+}
 
-	dm.innerCleanUp()
+func (dm *DirManager) notifyRemoved(removed []string) {
+	if len(removed) == 0 {
+		return
+	}
+	dm.mutex.Lock()
+	listener := dm.listener
+	dm.mutex.Unlock()
+	if listener != nil {
+		listener.FilesRemoved(removed)
+	}
 }
 
 func (dm *DirManager) GetFileCount() int {
 	return len(dm.fileList)
 }
 
-func (dm *DirManager) innerCleanUp() {
+// unpinnedCount количество файлов, которые учитываются в лимите
+func (dm *DirManager) unpinnedCount() int {
+	count := len(dm.fileList)
+	for _, file := range dm.fileList {
+		if _, ok := dm.pinned[file.Name]; ok {
+			count--
+		}
+	}
+	return count
+}
+
+func (dm *DirManager) innerCleanUp() []string {
 	if !dm.useCleanup {
-		return
+		return nil
 	}
 
-	if len(dm.fileList) <= dm.limitMax {
-		return
+	if dm.unpinnedCount() <= dm.limitMax {
+		return nil
 	}
 	dm.logger.Debug("Need cleanup")
 
@@ -205,20 +332,32 @@ func (dm *DirManager) innerCleanUp() {
 		return dm.fileList[i].ModTime.After(dm.fileList[j].ModTime)
 	})
 
-	// Удаляем лишние файлы
-	for i := dm.limitMin; i < len(dm.fileList); i++ {
+	// Удаляем лишние файлы. Закреплённые остаются и не занимают место среди limitMin новых
+	var removed []string
+	kept := dm.fileList[:0]
+	unpinned := 0
+	for _, file := range dm.fileList {
+		if _, ok := dm.pinned[file.Name]; ok || unpinned < dm.limitMin {
+			if !ok {
+				unpinned++
+			}
+			kept = append(kept, file)
+			continue
+		}
 		// Удаляем из карты
-		delete(dm.fileMap, dm.fileList[i].Name)
+		delete(dm.fileMap, file.Name)
 		// Удаляем файл
-		err := os.Remove(dm.fileList[i].Name)
+		err := os.Remove(file.Name)
 		if err != nil {
-			dm.logger.Warn("Error when delete file", "file", dm.fileList[i].Name, "error", err.Error())
+			dm.logger.Warn("Error when delete file", "file", file.Name, "error", err.Error())
 			continue
 		}
+		removed = append(removed, file.Name)
 	}
 	// Обновляем список файлов
-	dm.fileList = dm.fileList[:dm.limitMin]
+	dm.fileList = kept
 
 	dm.logger.Debug("Cleanup", "length", len(dm.fileList))
+	return removed
 
 }
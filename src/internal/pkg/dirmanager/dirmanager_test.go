@@ -1,7 +1,9 @@
 package dirmanager
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
@@ -164,6 +166,149 @@ func TestDirManager_AddFile(t *testing.T) {
 	Clear()
 }
 
+func TestDirManager_ReadFiles(t *testing.T) {
+	Prepare()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dirPath := filepath.Join("tests", "dm", "readDir")
+
+	dm, err := NewDirManager(dirPath, 3, 5, logger)
+	if err != nil {
+		t.Errorf("Create dm error = %v", err)
+		return
+	}
+	err = os.MkdirAll(dirPath, 0777)
+	if err != nil {
+		t.Errorf("Create dir error = %v", err)
+		return
+	}
+
+	// Старое имя (unix-время) и новое (ULID) должны читаться одинаково
+	names := []string{"f1700000000-orig.jpeg", "f01JBQ2X4Y6Z7K8M9N0P1Q2R3S-orig.jpeg", "notes.txt"}
+	for _, name := range names {
+		_, err := createFileInDir(dirPath, name)
+		if err != nil {
+			t.Errorf("Can not create file = %v", err)
+			return
+		}
+	}
+
+	err = dm.ReadFiles()
+	if err != nil {
+		t.Errorf("ReadFiles() error = %v", err)
+		return
+	}
+
+	if dm.GetFileCount() != 2 {
+		t.Errorf("ReadFiles() got = %v files, want %v", dm.GetFileCount(), 2)
+	}
+
+	Clear()
+}
+
+func TestDirManager_RemoveFile(t *testing.T) {
+	Prepare()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dirPath := filepath.Join("tests", "dm", "removeFile")
+
+	dm, err := NewDirManagerWithoutCleanup(dirPath, logger)
+	if err != nil {
+		t.Errorf("Create dm error = %v", err)
+		return
+	}
+	err = os.MkdirAll(dirPath, 0777)
+	if err != nil {
+		t.Errorf("Create dir error = %v", err)
+		return
+	}
+
+	var paths []string
+	for _, name := range []string{"f1-orig.jpeg", "f2-orig.jpeg"} {
+		path, err := createFileInDir(dirPath, name)
+		if err != nil {
+			t.Errorf("Can not create file = %v", err)
+			return
+		}
+		dm.AddFile(path)
+		paths = append(paths, path)
+	}
+
+	err = dm.RemoveFile(paths[0])
+	if err != nil {
+		t.Errorf("RemoveFile() error = %v", err)
+	}
+	if dm.HasFile(paths[0]) || !dm.HasFile(paths[1]) || dm.GetFileCount() != 1 {
+		t.Errorf("RemoveFile() got = %v files, want %v", dm.GetFiles(), paths[1:])
+	}
+	if _, err := os.Stat(paths[0]); !os.IsNotExist(err) {
+		t.Errorf("RemoveFile() file still exists")
+	}
+
+	// Повторное удаление - файла уже нет в списке
+	err = dm.RemoveFile(paths[0])
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("RemoveFile() error = %v, want %v", err, fs.ErrNotExist)
+	}
+
+	Clear()
+}
+
+func TestDirManager_CleanUpKeepsPinned(t *testing.T) {
+	Prepare()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dirPath := filepath.Join("tests", "dm", "pinned")
+
+	dm, err := NewDirManager(dirPath, 1, 2, logger)
+	if err != nil {
+		t.Errorf("Create dm error = %v", err)
+		return
+	}
+	err = os.MkdirAll(dirPath, 0777)
+	if err != nil {
+		t.Errorf("Create dir error = %v", err)
+		return
+	}
+
+	// Файлы от старого к новому
+	now := time.Now()
+	var paths []string
+	for i := 0; i < 3; i++ {
+		path, err := createFileInDir(dirPath, fmt.Sprintf("f%d-orig.jpeg", i))
+		if err != nil {
+			t.Errorf("Can not create file = %v", err)
+			return
+		}
+		modTime := now.Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(path, modTime, modTime)
+		paths = append(paths, path)
+	}
+
+	// Самый старый закреплён, поэтому три файла не превышают лимит
+	dm.SetPinned(paths[0], true)
+	dm.AddFile(paths[0])
+	dm.AddFile(paths[1])
+	dm.AddFile(paths[2])
+	if dm.GetFileCount() != 3 {
+		t.Errorf("AddFile() got = %v files, want %v", dm.GetFileCount(), 3)
+	}
+
+	path, err := createFileInDir(dirPath, "f3-orig.jpeg")
+	if err != nil {
+		t.Errorf("Can not create file = %v", err)
+		return
+	}
+	dm.AddFile(path)
+
+	// Остаются закреплённый и limitMin новых
+	if !dm.HasFile(paths[0]) || !dm.HasFile(path) || dm.GetFileCount() != 2 {
+		t.Errorf("CleanUp() got = %v files, want %v", dm.GetFiles(), []string{path, paths[0]})
+	}
+	if _, err := os.Stat(paths[0]); err != nil {
+		t.Errorf("CleanUp() removed pinned file: %v", err)
+	}
+
+	Clear()
+}
+
 func Prepare() {
 	os.Mkdir("tests", 0777)
 	os.Mkdir("tests/dm", 0777)
@@ -0,0 +1,244 @@
+// Package fakeydart имитирует API YandexArt для работы и тестов без сети и без оплаты
+package fakeydart
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Options поведение фейкового сервера
+type Options struct {
+	// OperationLatency через сколько после запуска операция будет готова
+	OperationLatency time.Duration
+	// RequestDelay задержка ответа на каждый HTTP-запрос
+	RequestDelay time.Duration
+	// GenerateErrorRate доля запросов на генерацию, которые получат HTTP 500 (0..1)
+	GenerateErrorRate float64
+	// OperationErrorRate доля операций, которые завершатся ошибкой (0..1)
+	OperationErrorRate float64
+	// ImageDir каталог с JPEG, которые отдаются случайным образом. Если пуст - рисуется однотонное изображение
+	ImageDir string
+	// ImageWidth, ImageHeight размер нарисованного изображения. По умолчанию 512x768
+	ImageWidth  int
+	ImageHeight int
+}
+
+type operation struct {
+	Id      string
+	Prompt  string
+	ReadyAt time.Time
+	Failed  bool
+}
+
+type operationResponse struct {
+	Id       string            `json:"id"`
+	Done     bool              `json:"done"`
+	Error    string            `json:"error,omitempty"`
+	Code     string            `json:"code,omitempty"`
+	Message  string            `json:"message,omitempty"`
+	Response map[string]string `json:"response,omitempty"`
+}
+
+type generateRequest struct {
+	ModelUri string `json:"model_uri"`
+	Messages []struct {
+		Text   string  `json:"text"`
+		Weight float64 `json:"weight"`
+	} `json:"messages"`
+}
+
+type Server struct {
+	options    Options
+	logger     *slog.Logger
+	router     *mux.Router
+	images     []string
+	operations map[string]*operation
+	counter    int
+	random     *rand.Rand
+	mutex      sync.Mutex
+}
+
+func NewServer(options Options, logger *slog.Logger) (*Server, error) {
+	if options.ImageWidth == 0 {
+		options.ImageWidth = 512
+	}
+	if options.ImageHeight == 0 {
+		options.ImageHeight = 768
+	}
+
+	server := &Server{
+		options:    options,
+		logger:     logger,
+		router:     mux.NewRouter(),
+		operations: make(map[string]*operation),
+		random:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	if options.ImageDir != "" {
+		images, err := filepath.Glob(filepath.Join(options.ImageDir, "*.jp*g"))
+		if err != nil {
+			return nil, err
+		}
+		if len(images) == 0 {
+			return nil, fmt.Errorf("no jpeg images in %s", options.ImageDir)
+		}
+		server.images = images
+	}
+
+	server.router.HandleFunc("/foundationModels/v1/imageGenerationAsync", server.handleGenerate).Methods("POST")
+	server.router.HandleFunc("/operations/{operationId}", server.handleOperation).Methods("GET")
+	server.router.HandleFunc("/iam/v1/tokens", server.handleIamToken).Methods("POST")
+
+	return server, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.options.RequestDelay > 0 {
+		time.Sleep(s.options.RequestDelay)
+	}
+	s.router.ServeHTTP(w, r)
+}
+
+func (s *Server) handleGenerate(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAuthorization(w, r) {
+		return
+	}
+
+	var request generateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Messages) == 0 {
+		sendError(w, http.StatusBadRequest, "bad request body")
+		return
+	}
+
+	s.mutex.Lock()
+	if s.random.Float64() < s.options.GenerateErrorRate {
+		s.mutex.Unlock()
+		s.logger.Info("Fake generate error")
+		sendError(w, http.StatusInternalServerError, "fake generate error")
+		return
+	}
+	s.counter++
+	op := &operation{
+		Id:      fmt.Sprintf("fake%06d", s.counter),
+		Prompt:  request.Messages[0].Text,
+		ReadyAt: time.Now().Add(s.options.OperationLatency),
+		Failed:  s.random.Float64() < s.options.OperationErrorRate,
+	}
+	s.operations[op.Id] = op
+	s.mutex.Unlock()
+
+	s.logger.Info("Fake generate", "id", op.Id, "model", request.ModelUri, "prompt", op.Prompt, "failed", op.Failed)
+	sendJSON(w, http.StatusOK, operationResponse{Id: op.Id, Done: false})
+}
+
+func (s *Server) handleOperation(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAuthorization(w, r) {
+		return
+	}
+
+	id := mux.Vars(r)["operationId"]
+	s.mutex.Lock()
+	op, ok := s.operations[id]
+	s.mutex.Unlock()
+	if !ok {
+		sendError(w, http.StatusNotFound, "operation not found")
+		return
+	}
+
+	if time.Now().Before(op.ReadyAt) {
+		sendJSON(w, http.StatusOK, operationResponse{Id: id, Done: false})
+		return
+	}
+
+	if op.Failed {
+		sendJSON(w, http.StatusOK, operationResponse{Id: id, Done: true, Error: "fake operation error", Code: "13"})
+		return
+	}
+
+	imageData, err := s.image(op.Prompt)
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sendJSON(w, http.StatusOK, operationResponse{
+		Id:       id,
+		Done:     true,
+		Response: map[string]string{"image": base64.StdEncoding.EncodeToString(imageData)},
+	})
+}
+
+func (s *Server) handleIamToken(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	s.counter++
+	token := fmt.Sprintf("fake-iam-token-%d", s.counter)
+	s.mutex.Unlock()
+
+	sendJSON(w, http.StatusOK, map[string]string{
+		"iamToken":  token,
+		"expiresAt": time.Now().Add(12 * time.Hour).UTC().Format(time.RFC3339),
+	})
+}
+
+func (s *Server) checkAuthorization(w http.ResponseWriter, r *http.Request) bool {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Api-Key ") && !strings.HasPrefix(authorization, "Bearer ") {
+		sendError(w, http.StatusUnauthorized, "authorization header is missing")
+		return false
+	}
+	return true
+}
+
+// image случайный файл из каталога или однотонное изображение, цвет которого зависит от промпта
+func (s *Server) image(prompt string) ([]byte, error) {
+	if len(s.images) > 0 {
+		s.mutex.Lock()
+		file := s.images[s.random.Intn(len(s.images))]
+		s.mutex.Unlock()
+		return os.ReadFile(file)
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(prompt))
+	sum := hash.Sum32()
+	fill := color.RGBA{R: uint8(sum), G: uint8(sum >> 8), B: uint8(sum >> 16), A: 255}
+
+	img := image.NewRGBA(image.Rect(0, 0, s.options.ImageWidth, s.options.ImageHeight))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i] = fill.R
+		img.Pix[i+1] = fill.G
+		img.Pix[i+2] = fill.B
+		img.Pix[i+3] = fill.A
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func sendError(w http.ResponseWriter, statusCode int, message string) {
+	sendJSON(w, statusCode, map[string]string{"error": message, "message": message})
+}
+
+func sendJSON(w http.ResponseWriter, statusCode int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,80 @@
+package filewatcher
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileState состояние файла при последней проверке
+type fileState struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+}
+
+type watchedFile struct {
+	state     fileState
+	callbacks []func()
+}
+
+// FileWatcher следит за изменением файлов настроек. Изменение определяется по времени
+// и размеру файла при каждом вызове Check, например из планировщика
+type FileWatcher struct {
+	files  map[string]*watchedFile
+	logger *slog.Logger
+	mutex  sync.Mutex
+}
+
+func NewFileWatcher(logger *slog.Logger) *FileWatcher {
+	return &FileWatcher{files: make(map[string]*watchedFile), logger: logger}
+}
+
+// Add подписывает на изменения файла. Текущее состояние файла изменением не считается
+func (fw *FileWatcher) Add(path string, onChange func()) {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	file, ok := fw.files[path]
+	if !ok {
+		file = &watchedFile{state: readState(path)}
+		fw.files[path] = file
+	}
+	file.callbacks = append(file.callbacks, onChange)
+	fw.logger.Debug("Watch file", "file", path)
+}
+
+// Check вызывает подписчиков изменившихся файлов. Удаление файла изменением не считается:
+// подписчики узнают о файле, когда он появится снова
+func (fw *FileWatcher) Check() {
+	var callbacks []func()
+
+	fw.mutex.Lock()
+	for path, file := range fw.files {
+		state := readState(path)
+		if state == file.state {
+			continue
+		}
+		file.state = state
+		if !state.exists {
+			fw.logger.Warn("Watched file removed", "file", path)
+			continue
+		}
+		fw.logger.Info("Watched file changed", "file", path)
+		callbacks = append(callbacks, file.callbacks...)
+	}
+	fw.mutex.Unlock()
+
+	for _, callback := range callbacks {
+		callback()
+	}
+}
+
+func readState(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{exists: true, modTime: info.ModTime(), size: info.Size()}
+}
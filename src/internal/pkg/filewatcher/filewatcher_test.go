@@ -0,0 +1,46 @@
+package filewatcher
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileWatcher_Check(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "options.yml")
+	require.NoError(t, os.WriteFile(path, []byte("a: 1"), 0644))
+
+	fw := NewFileWatcher(slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	calls := 0
+	fw.Add(path, func() { calls++ })
+	fw.Add(path, func() { calls += 10 })
+
+	// Без изменений подписчики не вызываются
+	fw.Check()
+	assert.Equal(t, 0, calls)
+
+	require.NoError(t, os.WriteFile(path, []byte("a: 12"), 0644))
+	fw.Check()
+	assert.Equal(t, 11, calls)
+	fw.Check()
+	assert.Equal(t, 11, calls)
+
+	// Изменение только времени файла
+	modTime := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+	fw.Check()
+	assert.Equal(t, 22, calls)
+
+	// Удаление не вызывает подписчиков, а появление файла - вызывает
+	require.NoError(t, os.Remove(path))
+	fw.Check()
+	assert.Equal(t, 22, calls)
+	require.NoError(t, os.WriteFile(path, []byte("a: 1"), 0644))
+	fw.Check()
+	assert.Equal(t, 33, calls)
+}
@@ -0,0 +1,227 @@
+// Package httpclient общий HTTP-клиент провайдеров: таймаут, повторы с backoff и учёт Retry-After
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultMaxRetries      = 2
+	defaultBaseDelayMs     = 500
+	defaultMaxDelaySeconds = 30
+)
+
+// RetryOptions настройки повторов. У идемпотентных запросов повторяются сетевые ошибки, 5xx (кроме 501) и 429.
+// Неидемпотентные запросы повторяются, только если это разрешено через WithSafeRetry
+type RetryOptions struct {
+	// MaxRetries количество повторов. 0 - по умолчанию 2, -1 - без повторов
+	MaxRetries int `yaml:"max_retries"`
+	// BaseDelayMs задержка перед первым повтором. Каждый следующий повтор удваивает её. По умолчанию 500
+	BaseDelayMs int `yaml:"base_delay_ms"`
+	// MaxDelaySeconds верхняя граница задержки, в том числе из Retry-After. По умолчанию 30
+	MaxDelaySeconds int `yaml:"max_delay_seconds"`
+}
+
+type Client struct {
+	httpClient *http.Client
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+	logger     *slog.Logger
+	sleep      func(ctx context.Context, delay time.Duration) error
+}
+
+// NewClient timeout ограничивает одну попытку целиком, включая чтение тела ответа
+func NewClient(timeout time.Duration, options RetryOptions, logger *slog.Logger) *Client {
+	client := &Client{
+		httpClient: &http.Client{Timeout: timeout},
+		maxRetries: options.MaxRetries,
+		baseDelay:  time.Duration(options.BaseDelayMs) * time.Millisecond,
+		maxDelay:   time.Duration(options.MaxDelaySeconds) * time.Second,
+		logger:     logger,
+		sleep:      sleepContext,
+	}
+	if client.maxRetries == 0 {
+		client.maxRetries = defaultMaxRetries
+	} else if client.maxRetries < 0 {
+		client.maxRetries = 0
+	}
+	if client.baseDelay <= 0 {
+		client.baseDelay = defaultBaseDelayMs * time.Millisecond
+	}
+	if client.maxDelay <= 0 {
+		client.maxDelay = defaultMaxDelaySeconds * time.Second
+	}
+	return client
+}
+
+type safeRetryKey struct{}
+
+// WithSafeRetry разрешает повторять неидемпотентный запрос, например POST генерации. Повтор будет
+// только там, где сервер запрос точно не выполнил: 429, 503 с Retry-After и ошибка соединения до отправки.
+// Повтор после 5xx или обрыва ответа мог бы запустить вторую платную генерацию
+func WithSafeRetry(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), safeRetryKey{}, true))
+}
+
+// Do выполняет запрос с повторами. Тело запроса должно уметь перечитываться (http.NewRequest
+// с bytes.Buffer, bytes.Reader или strings.Reader), иначе повторов не будет.
+// Если повторы не помогли, возвращается последний ответ, чтобы вызывающий сам разобрал статус
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	idempotent := isIdempotent(req)
+	safeRetry, _ := ctx.Value(safeRetryKey{}).(bool)
+	canRetry := (idempotent || safeRetry) && (req.Body == nil || req.Body == http.NoBody || req.GetBody != nil)
+
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if attempt > 0 {
+			attemptReq = req.Clone(ctx)
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, fmt.Errorf("can not rewind request body: %v", err)
+				}
+				attemptReq.Body = body
+			}
+		}
+
+		resp, err := c.httpClient.Do(attemptReq)
+
+		delay, retry := c.retryDelay(ctx, resp, err, attempt, idempotent)
+		if !retry || !canRetry || attempt >= c.maxRetries {
+			return resp, err
+		}
+
+		if resp != nil {
+			c.logger.Warn("Retry HTTP request", "url", req.URL.Redacted(), "status", resp.Status, "attempt", attempt+1, "delay", delay)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		} else {
+			c.logger.Warn("Retry HTTP request", "url", req.URL.Redacted(), "error", err, "attempt", attempt+1, "delay", delay)
+		}
+
+		if err := c.sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) Get(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+func (c *Client) Post(url string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return c.Do(req)
+}
+
+// retryDelay решает, нужен ли повтор, и сколько перед ним ждать. Неидемпотентный запрос
+// повторяется, только если сервер его точно не выполнял
+func (c *Client) retryDelay(ctx context.Context, resp *http.Response, err error, attempt int, idempotent bool) (time.Duration, bool) {
+	if err != nil {
+		// Запрос отменён вызывающим - повторять нечего
+		if ctx.Err() != nil {
+			return 0, false
+		}
+		if !idempotent && !isNotSent(err) {
+			return 0, false
+		}
+		return c.backoff(attempt), true
+	}
+
+	retryAfter, hasRetryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+	case resp.StatusCode == http.StatusServiceUnavailable && hasRetryAfter:
+	case idempotent && resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented:
+	default:
+		return 0, false
+	}
+
+	if hasRetryAfter {
+		if retryAfter > c.maxDelay {
+			retryAfter = c.maxDelay
+		}
+		return retryAfter, true
+	}
+	return c.backoff(attempt), true
+}
+
+// isIdempotent повтор запроса не меняет результат. Как и в net/http, запрос с Idempotency-Key
+// считается идемпотентным
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != "" || req.Header.Get("X-Idempotency-Key") != ""
+}
+
+// isNotSent ошибка установки соединения: запрос до сервера не дошёл
+func isNotSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// backoff экспоненциальная задержка со случайным разбросом в пределах второй половины интервала
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.baseDelay
+	for i := 0; i < attempt && delay < c.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > c.maxDelay {
+		delay = c.maxDelay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// parseRetryAfter понимает оба формата заголовка: секунды и HTTP-дату
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := date.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
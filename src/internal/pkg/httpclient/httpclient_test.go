@@ -0,0 +1,256 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestClient клиент, который не спит, а запоминает задержки
+func newTestClient(timeout time.Duration, options RetryOptions) (*Client, *[]time.Duration) {
+	client := NewClient(timeout, options, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	delays := &[]time.Duration{}
+	client.sleep = func(ctx context.Context, delay time.Duration) error {
+		*delays = append(*delays, delay)
+		return ctx.Err()
+	}
+	return client, delays
+}
+
+func TestClient_RetryOn5xxResendsBody(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, `{"prompt":"cat"}`, string(body))
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client, delays := newTestClient(time.Second, RetryOptions{BaseDelayMs: 100})
+	req, err := http.NewRequest(http.MethodPut, server.URL, bytes.NewBufferString(`{"prompt":"cat"}`))
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	require.Len(t, *delays, 2)
+	// Разброс в пределах второй половины интервала, интервал удваивается
+	assert.True(t, (*delays)[0] >= 50*time.Millisecond && (*delays)[0] <= 100*time.Millisecond, (*delays)[0])
+	assert.True(t, (*delays)[1] >= 100*time.Millisecond && (*delays)[1] <= 200*time.Millisecond, (*delays)[1])
+}
+
+func TestClient_ReturnsLastResponseWhenRetriesExhausted(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "broken", http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	client, _ := newTestClient(time.Second, RetryOptions{MaxRetries: 1})
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "broken\n", string(body))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestClient_RetryAfterOn429(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if atomic.LoadInt32(&calls) == 2 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client, delays := newTestClient(time.Second, RetryOptions{MaxDelaySeconds: 10})
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	// Второй Retry-After больше максимума и обрезается
+	assert.Equal(t, []time.Duration{7 * time.Second, 10 * time.Second}, *delays)
+}
+
+func TestClient_NoRetryOn4xx(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	client, delays := newTestClient(time.Second, RetryOptions{})
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Empty(t, *delays)
+}
+
+func TestClient_RetryOnNetworkErrorAndTimeout(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// Первый запрос зависает дольше таймаута
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client, delays := newTestClient(100*time.Millisecond, RetryOptions{})
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, *delays, 1)
+
+	// Сервер недоступен - после всех повторов возвращается ошибка
+	server.Close()
+	client, delays = newTestClient(100*time.Millisecond, RetryOptions{MaxRetries: 2})
+	_, err = client.Get(server.URL)
+	assert.Error(t, err)
+	assert.Len(t, *delays, 2)
+}
+
+func TestClient_DisabledRetriesAndCanceledContext(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client, _ := newTestClient(time.Second, RetryOptions{MaxRetries: -1})
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client, delays := newTestClient(time.Second, RetryOptions{})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	_, err = client.Do(req)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, *delays)
+}
+
+func TestClient_PostRetries(t *testing.T) {
+	var calls int32
+	status := http.StatusInternalServerError
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(status)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	post := func(safeRetry bool) *http.Response {
+		atomic.StoreInt32(&calls, 0)
+		client, _ := newTestClient(time.Second, RetryOptions{})
+		req, err := http.NewRequest(http.MethodPost, server.URL, bytes.NewBufferString(`{"prompt":"cat"}`))
+		require.NoError(t, err)
+		if safeRetry {
+			req = WithSafeRetry(req)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	// Сервер мог начать генерацию до ошибки - POST не повторяется даже с разрешением
+	for _, safeRetry := range []bool{false, true} {
+		resp := post(safeRetry)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	}
+
+	// 429 означает, что запрос не выполнялся, но повтор только по разрешению
+	status = http.StatusTooManyRequests
+	resp := post(false)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	resp = post(true)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// Соединение не установлено - запрос не отправлен и его можно повторить
+	server.Close()
+	for _, safeRetry := range []bool{false, true} {
+		client, delays := newTestClient(100*time.Millisecond, RetryOptions{MaxRetries: 2})
+		req, err := http.NewRequest(http.MethodPost, server.URL, bytes.NewBufferString(`{"prompt":"cat"}`))
+		require.NoError(t, err)
+		if safeRetry {
+			req = WithSafeRetry(req)
+		}
+		_, err = client.Do(req)
+		assert.Error(t, err)
+		if safeRetry {
+			assert.Len(t, *delays, 2)
+		} else {
+			assert.Empty(t, *delays)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	delay, ok := parseRetryAfter("5", now)
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, delay)
+
+	delay, ok = parseRetryAfter(now.Add(time.Minute).Format(http.TimeFormat), now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, delay)
+
+	_, ok = parseRetryAfter("soon", now)
+	assert.False(t, ok)
+	_, ok = parseRetryAfter("", now)
+	assert.False(t, ok)
+}
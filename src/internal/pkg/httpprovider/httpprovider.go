@@ -0,0 +1,467 @@
+package httpprovider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"imgserver/internal/pkg/actioner"
+	"imgserver/internal/pkg/asyncjob"
+	"imgserver/internal/pkg/httpclient"
+	"imgserver/internal/pkg/imageprocessor"
+	"imgserver/internal/pkg/opermanager"
+	"imgserver/internal/pkg/promptmanager"
+	"imgserver/internal/pkg/timerange"
+	"imgserver/internal/pkg/utils"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const defaultTimeout = 180
+
+var _ opermanager.ImageProvider = (*HttpProvider)(nil)
+var _ opermanager.GenerationInfoProvider = (*HttpProvider)(nil)
+
+type HttpSleepTime struct {
+	TimeRange *timerange.TimeRange `yaml:"time_range"`
+}
+
+// RequestOptions описание HTTP-запроса. Url, заголовки и тело - шаблоны Go
+type RequestOptions struct {
+	Url     string            `yaml:"url"`
+	Method  string            `yaml:"method"`
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+}
+
+// ResponseOptions селекторы полей ответа
+type ResponseOptions struct {
+	JobId     string `yaml:"job_id"`
+	Done      string `yaml:"done"`
+	DoneValue string `yaml:"done_value"`
+	Error     string `yaml:"error"`
+	Image     string `yaml:"image"`
+	ImageUrl  string `yaml:"image_url"`
+}
+
+type HttpProviderOptions struct {
+	Code                   string                  `yaml:"code"`
+	Name                   string                  `yaml:"name"`
+	ImageGenerateThreshold int                     `yaml:"image_generate_threshold"`
+	SleepTimes             []HttpSleepTime         `yaml:"sleep_time"`
+	TimeoutSeconds         int                     `yaml:"timeout_seconds"`
+	Retry                  httpclient.RetryOptions `yaml:"retry"`
+	Request                RequestOptions          `yaml:"request"`
+	Response               ResponseOptions         `yaml:"response"`
+	// Poll запрос состояния задания. Нужен, если в Response задан job_id
+	Poll         *RequestOptions  `yaml:"poll"`
+	PollResponse *ResponseOptions `yaml:"poll_response"`
+}
+
+// TemplateParameters значения, доступные в шаблонах.
+// В теле строки экранированы для вставки внутрь JSON-строки, в url - для вставки в путь или параметр
+type TemplateParameters struct {
+	Prompt   string
+	Negative string
+	Width    int
+	Height   int
+	JobId    string
+}
+
+type compiledRequest struct {
+	method  string
+	url     *template.Template
+	headers map[string]*template.Template
+	body    *template.Template
+}
+
+type HttpProvider struct {
+	httpClient      *httpclient.Client
+	logger          *slog.Logger
+	options         *HttpProviderOptions
+	imageParameters *opermanager.ImageParameters
+	promptManager   *promptmanager.PromptManager
+	actioner        *actioner.Actioner
+	ipr             *imageprocessor.Ipr
+	properties      *opermanager.ProviderProperties
+	jobs            *asyncjob.Jobs
+	request         *compiledRequest
+	poll            *compiledRequest
+	*opermanager.GenerationInfoCache
+}
+
+func NewHttpProvider(imageParameters imageprocessor.ImageParameters, promptManager *promptmanager.PromptManager, logger *slog.Logger, options *HttpProviderOptions) *HttpProvider {
+	if options.TimeoutSeconds <= 0 {
+		options.TimeoutSeconds = defaultTimeout
+	}
+
+	return &HttpProvider{
+		httpClient:          httpclient.NewClient(time.Duration(options.TimeoutSeconds)*time.Second, options.Retry, logger),
+		logger:              logger.With("provider", options.Code),
+		options:             options,
+		promptManager:       promptManager,
+		actioner:            actioner.NewActioner(options.ImageGenerateThreshold, time.Minute),
+		ipr:                 imageprocessor.NewIpr(imageParameters, logger),
+		jobs:                asyncjob.NewJobs("http"),
+		GenerationInfoCache: opermanager.NewGenerationInfoCache(),
+		properties: &opermanager.ProviderProperties{
+			IsCanWorkWithPrompt:  true,
+			IsNeedSaveLocalFiles: true,
+		},
+	}
+}
+
+func (hp *HttpProvider) Start() error {
+	if hp.options.Code == "" {
+		return fmt.Errorf("http provider code is empty")
+	}
+
+	request, err := compileRequest("request", &hp.options.Request)
+	if err != nil {
+		return err
+	}
+	hp.request = request
+
+	if hp.isPolling() {
+		if hp.options.Poll == nil {
+			return fmt.Errorf("http provider %s: poll request is required when response.job_id is set", hp.options.Code)
+		}
+		poll, err := compileRequest("poll", hp.options.Poll)
+		if err != nil {
+			return err
+		}
+		hp.poll = poll
+		if hp.options.PollResponse == nil {
+			return fmt.Errorf("http provider %s: poll_response is required when response.job_id is set", hp.options.Code)
+		}
+		if hp.options.PollResponse.Image == "" && hp.options.PollResponse.ImageUrl == "" {
+			return fmt.Errorf("http provider %s: poll_response.image or poll_response.image_url is required", hp.options.Code)
+		}
+	} else if hp.options.Response.Image == "" && hp.options.Response.ImageUrl == "" {
+		return fmt.Errorf("http provider %s: response.image or response.image_url is required", hp.options.Code)
+	}
+
+	return nil
+}
+
+func (hp *HttpProvider) SetImageParameters(parameters *opermanager.ImageParameters) error {
+	hp.imageParameters = parameters
+	return nil
+}
+
+func (hp *HttpProvider) GetImageProviderForImageServerName() string {
+	if hp.options.Name != "" {
+		return hp.options.Name
+	}
+	return hp.options.Code
+}
+
+func (hp *HttpProvider) GetImageProviderCode() string {
+	return hp.options.Code
+}
+
+func (hp *HttpProvider) GetProperties() *opermanager.ProviderProperties {
+	return hp.properties
+}
+
+func (hp *HttpProvider) Generate(isDirectCall bool) (string, error) {
+	prompt, err := hp.promptManager.GetRandomPrompt()
+	if err != nil {
+		hp.logger.Error("Error when get prompt", "error", err.Error())
+		return "", err
+	}
+
+	negative := ""
+	if prompt.Negative != nil {
+		negative = *prompt.Negative
+	}
+	id, err := hp.GenerateWithPrompt(prompt.Prompt, negative, isDirectCall)
+	if err == nil {
+		hp.PutGenerationInfo(id, &opermanager.GenerationInfo{Prompt: prompt.Prompt, Negative: negative, PromptIdx: prompt.Idx})
+	}
+	return id, err
+}
+
+func (hp *HttpProvider) GenerateWithPrompt(prompt string, negative string, isDirectCall bool) (string, error) {
+	if prompt == "" {
+		return "", fmt.Errorf("prompt is empty")
+	}
+
+	parameters := TemplateParameters{Prompt: prompt, Negative: strings.TrimSpace(negative)}
+	if hp.imageParameters != nil {
+		parameters.Width = hp.imageParameters.Weight
+		parameters.Height = hp.imageParameters.Height
+	}
+	hp.logger.Debug("generate with prompt", "prompt", prompt, "negative", negative, "isDirect", isDirectCall)
+
+	var id string
+	if hp.isPolling() {
+		document, err := hp.execute(hp.request, parameters)
+		if err != nil {
+			resultError := fmt.Errorf("error generate image: %v", err)
+			hp.logger.Error(resultError.Error())
+			return "", resultError
+		}
+		if message, ok := selectString(document, hp.options.Response.Error); hp.options.Response.Error != "" && ok && message != "" {
+			resultError := fmt.Errorf("provider return error: %s", message)
+			hp.logger.Error(resultError.Error())
+			return "", resultError
+		}
+		jobId, ok := selectString(document, hp.options.Response.JobId)
+		if !ok || jobId == "" {
+			resultError := fmt.Errorf("provider return empty job id")
+			hp.logger.Error(resultError.Error())
+			return "", resultError
+		}
+		id = jobId
+	} else {
+		id = hp.jobs.Start(func() ([]byte, error) {
+			document, err := hp.execute(hp.request, parameters)
+			if err != nil {
+				hp.logger.Error("Generation error", "error", err)
+				return nil, err
+			}
+			_, image, err := hp.extractImage(document, &hp.options.Response)
+			if err != nil {
+				hp.logger.Error("Generation error", "error", err)
+			}
+			return image, err
+		})
+	}
+
+	if !isDirectCall {
+		hp.actioner.SetLastCallTime(time.Now())
+	}
+
+	hp.logger.Debug("Http provider operation id", "id", id)
+	return id, nil
+}
+
+func (hp *HttpProvider) GetImageSlice(operationId string) (bool, []byte, error) {
+	if !hp.isPolling() {
+		return hp.jobs.Result(operationId)
+	}
+
+	document, err := hp.execute(hp.poll, TemplateParameters{JobId: operationId})
+	if err != nil {
+		return false, nil, fmt.Errorf("error when poll job: %v", err)
+	}
+
+	response := hp.options.PollResponse
+	if response.Done != "" && !isDone(document, response) {
+		if message, ok := selectString(document, response.Error); response.Error != "" && ok && message != "" {
+			return true, nil, fmt.Errorf("provider return error: %s", message)
+		}
+		return false, nil, nil
+	}
+
+	return hp.extractImage(document, response)
+}
+
+func (hp *HttpProvider) IsReadyForRequest() bool {
+	if !hp.actioner.ThresholdOut(time.Now()) {
+		// Провайдер вызывался недавно. Он не готов к новому вызову.
+		return false
+	}
+
+	now := time.Now()
+	for _, st := range hp.options.SleepTimes {
+		inclusive, err := st.TimeRange.IsWithinRangeInclusive(now)
+		if err != nil {
+			hp.logger.Error("Get time range error", "error", err)
+		}
+		if inclusive {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (hp *HttpProvider) isPolling() bool {
+	return hp.options.Response.JobId != ""
+}
+
+// extractImage достаёт изображение из ответа. Если изображения ещё нет - задание не завершено
+func (hp *HttpProvider) extractImage(document interface{}, response *ResponseOptions) (bool, []byte, error) {
+	if message, ok := selectString(document, response.Error); response.Error != "" && ok && message != "" {
+		return true, nil, fmt.Errorf("provider return error: %s", message)
+	}
+
+	if response.Image != "" {
+		if image, ok := selectString(document, response.Image); ok && image != "" {
+			if idx := strings.Index(image, ","); strings.HasPrefix(image, "data:") && idx > 0 {
+				image = image[idx+1:]
+			}
+			jpg, err := hp.ipr.ConvertBase64ToJpg(image)
+			if err != nil {
+				return true, nil, fmt.Errorf("error image processing: %v", err)
+			}
+			return true, jpg, nil
+		}
+	}
+
+	if response.ImageUrl != "" {
+		if imageUrl, ok := selectString(document, response.ImageUrl); ok && imageUrl != "" {
+			jpg, err := hp.download(imageUrl)
+			if err != nil {
+				return false, nil, err
+			}
+			return true, jpg, nil
+		}
+	}
+
+	if response.Done != "" || !hp.isPolling() {
+		// Задание завершено, но изображения нет
+		return true, nil, fmt.Errorf("image not found in response")
+	}
+	return false, nil, nil
+}
+
+func isDone(document interface{}, response *ResponseOptions) bool {
+	value, ok := selectString(document, response.Done)
+	if !ok {
+		return false
+	}
+	if response.DoneValue != "" {
+		return value == response.DoneValue
+	}
+	return value == "true"
+}
+
+func (hp *HttpProvider) execute(request *compiledRequest, parameters TemplateParameters) (interface{}, error) {
+	urlParameters := parameters
+	urlParameters.Prompt = url.QueryEscape(parameters.Prompt)
+	urlParameters.Negative = url.QueryEscape(parameters.Negative)
+	urlParameters.JobId = url.PathEscape(parameters.JobId)
+
+	requestUrl, err := executeTemplate(request.url, urlParameters)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader
+	if request.body != nil {
+		bodyParameters := parameters
+		bodyParameters.Prompt = utils.EscapeJSONString(parameters.Prompt)
+		bodyParameters.Negative = utils.EscapeJSONString(parameters.Negative)
+		bodyParameters.JobId = utils.EscapeJSONString(parameters.JobId)
+		text, err := executeTemplate(request.body, bodyParameters)
+		if err != nil {
+			return nil, err
+		}
+		body = strings.NewReader(text)
+	}
+
+	req, err := http.NewRequest(request.method, requestUrl, body)
+	if err != nil {
+		return nil, fmt.Errorf("error when create request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range request.headers {
+		text, err := executeTemplate(value, parameters)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(name, text)
+	}
+
+	hp.logger.Debug("Execute request", "method", request.method, "url", requestUrl)
+	// POST генерации может быть платным и повторяется, только если сервер его не выполнял
+	resp, err := hp.httpClient.Do(httpclient.WithSafeRetry(req))
+	if err != nil {
+		return nil, fmt.Errorf("error when execute request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error when read body: %v", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		hp.logger.Error("Get response", "status", resp.Status, "body", string(respBody))
+		return nil, fmt.Errorf("unexpected status code: %s", resp.Status)
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(respBody, &document); err != nil {
+		return nil, fmt.Errorf("error when parse body: %v", err)
+	}
+	return document, nil
+}
+
+func (hp *HttpProvider) download(imageUrl string) ([]byte, error) {
+	resp, err := hp.httpClient.Get(imageUrl)
+	if err != nil {
+		return nil, fmt.Errorf("error when download image: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code when download image: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error when read image: %v", err)
+	}
+	return hp.ipr.ConvertSliceToJpg(data)
+}
+
+func compileRequest(name string, options *RequestOptions) (*compiledRequest, error) {
+	if options.Url == "" {
+		return nil, fmt.Errorf("%s url is empty", name)
+	}
+
+	method := strings.ToUpper(options.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	urlTemplate, err := parseTemplate(name+".url", options.Url)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &compiledRequest{method: method, url: urlTemplate, headers: make(map[string]*template.Template)}
+	for header, value := range options.Headers {
+		headerTemplate, err := parseTemplate(name+".headers."+header, value)
+		if err != nil {
+			return nil, err
+		}
+		result.headers[header] = headerTemplate
+	}
+
+	if options.Body != "" {
+		bodyTemplate, err := parseTemplate(name+".body", options.Body)
+		if err != nil {
+			return nil, err
+		}
+		result.body = bodyTemplate
+	}
+	return result, nil
+}
+
+func parseTemplate(name string, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("can not parse template %s: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+func executeTemplate(tmpl *template.Template, parameters TemplateParameters) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, parameters); err != nil {
+		return "", fmt.Errorf("can not execute template %s: %w", tmpl.Name(), err)
+	}
+	return buf.String(), nil
+}
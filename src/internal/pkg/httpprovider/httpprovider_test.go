@@ -0,0 +1,174 @@
+package httpprovider
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"imgserver/internal/pkg/imageprocessor"
+	"imgserver/internal/pkg/opermanager"
+	"imgserver/internal/pkg/testutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestProvider(t *testing.T, options *HttpProviderOptions) *HttpProvider {
+	logger := testutil.NewLogger()
+	hp := NewHttpProvider(imageprocessor.ImageParameters{ImageWeight: 320, ImageHeight: 480}, nil, logger, options)
+	_ = hp.SetImageParameters(&opermanager.ImageParameters{Weight: 320, Height: 480})
+	require.NoError(t, hp.Start())
+	return hp
+}
+
+func TestSelectValue(t *testing.T) {
+	var document interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{"data":[{"b64":"abc"},{"b64":"def"}],"status":{"done":true,"progress":0.5}}`), &document))
+
+	value, ok := selectString(document, "data.1.b64")
+	assert.True(t, ok)
+	assert.Equal(t, "def", value)
+
+	value, ok = selectString(document, "$.data[0].b64")
+	assert.True(t, ok)
+	assert.Equal(t, "abc", value)
+
+	value, ok = selectString(document, "status.done")
+	assert.True(t, ok)
+	assert.Equal(t, "true", value)
+
+	value, ok = selectString(document, "status.progress")
+	assert.True(t, ok)
+	assert.Equal(t, "0.5", value)
+
+	_, ok = selectString(document, "data.5.b64")
+	assert.False(t, ok)
+	_, ok = selectString(document, "status")
+	assert.False(t, ok)
+}
+
+func TestHttpProvider_Sync(t *testing.T) {
+	imageBase64 := testutil.CreatePngBase64(t)
+	var body map[string]interface{}
+	var auth string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		_, _ = w.Write([]byte(`{"result":{"images":["data:image/png;base64,` + imageBase64 + `"]}}`))
+	}))
+	defer server.Close()
+
+	hp := newTestProvider(t, &HttpProviderOptions{
+		Code: "sync",
+		Request: RequestOptions{
+			Url:     server.URL + "/generate",
+			Headers: map[string]string{"Authorization": "Bearer key"},
+			Body:    `{"prompt":"{{.Prompt}}","negative":"{{.Negative}}","w":{{.Width}},"h":{{.Height}}}`,
+		},
+		Response: ResponseOptions{Image: "result.images[0]"},
+	})
+
+	id, err := hp.GenerateWithPrompt(`say "hi"`, "dark", true)
+	require.NoError(t, err)
+
+	data, err := testutil.WaitImage(t, hp, id)
+	require.NoError(t, err)
+	assert.NotEmpty(t, data)
+
+	assert.Equal(t, "Bearer key", auth)
+	assert.Equal(t, `say "hi"`, body["prompt"])
+	assert.Equal(t, "dark", body["negative"])
+	assert.Equal(t, float64(320), body["w"])
+	assert.Equal(t, float64(480), body["h"])
+}
+
+func TestHttpProvider_Polling(t *testing.T) {
+	imageBase64 := testutil.CreatePngBase64(t)
+	var mu sync.Mutex
+	polls := 0
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"job":{"id":"j 1"}}`))
+	})
+	mux.HandleFunc("/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "j 1", r.PathValue("id"))
+		mu.Lock()
+		polls++
+		current := polls
+		mu.Unlock()
+		if current == 1 {
+			_, _ = w.Write([]byte(`{"state":"running"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"state":"succeeded","url":"` + server.URL + `/files/1"}`))
+	})
+	mux.HandleFunc("/files/1", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := base64.StdEncoding.DecodeString(imageBase64)
+		_, _ = w.Write(data)
+	})
+
+	hp := newTestProvider(t, &HttpProviderOptions{
+		Code:         "poll",
+		Request:      RequestOptions{Url: server.URL + "/jobs", Body: `{"prompt":"{{.Prompt}}"}`},
+		Response:     ResponseOptions{JobId: "job.id"},
+		Poll:         &RequestOptions{Url: server.URL + "/jobs/{{.JobId}}", Method: "get"},
+		PollResponse: &ResponseOptions{Done: "state", DoneValue: "succeeded", Error: "error", ImageUrl: "url"},
+	})
+
+	id, err := hp.GenerateWithPrompt("cat", "", false)
+	require.NoError(t, err)
+	assert.Equal(t, "j 1", id)
+	assert.True(t, hp.IsReadyForRequest()) // порог не задан
+
+	done, _, err := hp.GetImageSlice(id)
+	require.NoError(t, err)
+	assert.False(t, done)
+
+	data, err := testutil.WaitImage(t, hp, id)
+	require.NoError(t, err)
+	assert.NotEmpty(t, data)
+}
+
+func TestHttpProvider_PollingError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			_, _ = w.Write([]byte(`{"id":"1"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"failed","error":{"message":"nsfw"}}`))
+	}))
+	defer server.Close()
+
+	hp := newTestProvider(t, &HttpProviderOptions{
+		Code:         "poll",
+		Request:      RequestOptions{Url: server.URL},
+		Response:     ResponseOptions{JobId: "id"},
+		Poll:         &RequestOptions{Url: server.URL + "/{{.JobId}}", Method: "GET"},
+		PollResponse: &ResponseOptions{Done: "status", DoneValue: "succeeded", Error: "error.message", Image: "image"},
+	})
+
+	id, err := hp.GenerateWithPrompt("cat", "", true)
+	require.NoError(t, err)
+
+	done, _, err := hp.GetImageSlice(id)
+	assert.True(t, done)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "nsfw")
+}
+
+func TestHttpProvider_InvalidOptions(t *testing.T) {
+	logger := testutil.NewLogger()
+	hp := NewHttpProvider(imageprocessor.ImageParameters{}, nil, logger, &HttpProviderOptions{
+		Code:     "bad",
+		Request:  RequestOptions{Url: "http://localhost"},
+		Response: ResponseOptions{JobId: "id"},
+	})
+	assert.Error(t, hp.Start())
+}
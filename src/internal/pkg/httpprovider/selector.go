@@ -0,0 +1,71 @@
+package httpprovider
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// selectValue извлекает значение из разобранного JSON по пути вида
+// "data.0.b64_json", "data[0].b64_json" или "$.output.images[1]"
+func selectValue(document interface{}, path string) (interface{}, bool) {
+	path = strings.TrimSpace(path)
+	path = strings.TrimPrefix(path, "$")
+	path = strings.TrimPrefix(path, ".")
+	if path == "" {
+		return document, document != nil
+	}
+
+	current := document
+	for _, part := range splitPath(path) {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[part]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case []interface{}:
+			idx, err := strconv.Atoi(part)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, false
+			}
+			current = node[idx]
+		default:
+			return nil, false
+		}
+	}
+	return current, current != nil
+}
+
+// selectString как selectValue, но приводит скалярное значение к строке
+func selectString(document interface{}, path string) (string, bool) {
+	value, ok := selectValue(document, path)
+	if !ok {
+		return "", false
+	}
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case map[string]interface{}, []interface{}:
+		return "", false
+	default:
+		return fmt.Sprint(v), true
+	}
+}
+
+func splitPath(path string) []string {
+	path = strings.ReplaceAll(path, "[", ".")
+	path = strings.ReplaceAll(path, "]", "")
+	parts := make([]string, 0)
+	for _, part := range strings.Split(path, ".") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
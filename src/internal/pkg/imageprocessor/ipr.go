@@ -3,17 +3,23 @@ package imageprocessor
 import (
 	"bytes"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"github.com/disintegration/imaging"
 	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
 	"image"
 	"image/color"
+	_ "image/gif"
 	"image/jpeg"
+	_ "image/png"
 	"log/slog"
 	"math"
 	"os"
 )
 
+var ErrUnsupportedImage = errors.New("unsupported image")
+
 type ImageParameters struct {
 	ImageWeight  int
 	ImageHeight  int
@@ -36,6 +42,21 @@ func (ipr *Ipr) ConvertImageFileToJpg(filePath string) ([]byte, error) {
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
 
+	return ipr.ConvertSliceToJpg(data)
+}
+
+func (ipr *Ipr) ConvertBase64ToJpg(imageBase64 string) ([]byte, error) {
+	// Декодирование Base64
+	imgBytes, err := base64.StdEncoding.DecodeString(imageBase64)
+	if err != nil {
+		return nil, fmt.Errorf("error when decode Base64: %v", err)
+	}
+
+	return ipr.ConvertSliceToJpg(imgBytes)
+}
+
+// ConvertSliceToJpg приводит изображение любого поддерживаемого формата к JPEG
+func (ipr *Ipr) ConvertSliceToJpg(data []byte) ([]byte, error) {
 	// Определяем формат
 	_, format, err := image.DecodeConfig(bytes.NewReader(data))
 	if err != nil {
@@ -60,36 +81,21 @@ func (ipr *Ipr) ConvertImageFileToJpg(filePath string) ([]byte, error) {
 	return encoded, nil
 }
 
-func (ipr *Ipr) ConvertBase64ToJpg(imageBase64 string) ([]byte, error) {
-	// Декодирование Base64
-	imgBytes, err := base64.StdEncoding.DecodeString(imageBase64)
+// NormalizeImage готовит изображение, загруженное пользователем, к хранению как оригинал:
+// поворачивает по EXIF, уменьшает до maxSide по большей стороне и перекодирует в JPEG.
+// Из GIF берётся первый кадр
+func (ipr *Ipr) NormalizeImage(data []byte, maxSide int) ([]byte, error) {
+	src, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
 	if err != nil {
-		return nil, fmt.Errorf("error when decode Base64: %v", err)
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
 	}
 
-	// Определяем формат
-	_, format, err := image.DecodeConfig(bytes.NewReader(imgBytes))
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode image config: %w", err)
-	}
-
-	if format == "jpeg" {
-		return imgBytes, nil // исходные байты — JPEG, возвращаем как есть
-	}
-
-	// Декодируем изображение из байтов
-	src, _, err := image.Decode(bytes.NewReader(imgBytes))
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode image: %w", err)
-	}
-
-	//TODO Повторяющийся код. Может в один объеденить?
-	encoded, err := encodeJPEG(src)
-	if err != nil {
-		return nil, err
+	bounds := src.Bounds()
+	if maxSide > 0 && (bounds.Dx() > maxSide || bounds.Dy() > maxSide) {
+		ipr.logger.Debug("Downscale image", "width", bounds.Dx(), "height", bounds.Dy(), "maxSide", maxSide)
+		src = imaging.Fit(src, maxSide, maxSide, imaging.Lanczos)
 	}
-
-	return encoded, nil
+	return encodeJPEG(src)
 }
 
 // ProcessImageFromSLice  Обрабатывает изображение из массива. Ответ: (fit, original, error)
@@ -5,7 +5,9 @@ import (
 	"image"
 	"image/color"
 	"image/draw"
+	"image/gif"
 	"image/jpeg"
+	"image/png"
 	"log/slog"
 	"testing"
 
@@ -206,3 +208,40 @@ func TestIpr_ProcessImage_SmallRatioDiff_ResizeWithoutPadding(t *testing.T) {
 	// Углы не чёрные → значит, не было pad
 	assert.False(t, isBlack(result.At(10, 10)), "should not have black padding")
 }
+
+// ========================================
+// ТЕСТ: нормализация загруженного изображения
+// ========================================
+func TestIpr_NormalizeImage(t *testing.T) {
+	ipr := newTestIpr()
+
+	// PNG уменьшается до maxSide и перекодируется в JPEG
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, createRedImage(400, 200)))
+	normalized, err := ipr.NormalizeImage(buf.Bytes(), 100)
+	require.NoError(t, err)
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(normalized))
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, 100, config.Width)
+	assert.Equal(t, 50, config.Height)
+
+	// Из GIF берётся первый кадр
+	buf.Reset()
+	palette := color.Palette{color.Black, color.RGBA{255, 0, 0, 255}}
+	first := image.NewPaletted(image.Rect(0, 0, 20, 10), palette)
+	draw.Draw(first, first.Bounds(), &image.Uniform{palette[1]}, image.Point{}, draw.Src)
+	second := image.NewPaletted(image.Rect(0, 0, 20, 10), palette)
+	require.NoError(t, gif.EncodeAll(&buf, &gif.GIF{Image: []*image.Paletted{first, second}, Delay: []int{10, 10}}))
+	normalized, err = ipr.NormalizeImage(buf.Bytes(), 100)
+	require.NoError(t, err)
+
+	result, _, err := image.Decode(bytes.NewReader(normalized))
+	require.NoError(t, err)
+	assert.Equal(t, 20, result.Bounds().Dx())
+	assert.True(t, isRed(result.At(5, 5)))
+
+	_, err = ipr.NormalizeImage([]byte("not an image"), 100)
+	assert.ErrorIs(t, err, ErrUnsupportedImage)
+}
@@ -0,0 +1,39 @@
+package imageprocessor
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/disintegration/imaging"
+	"image"
+	"math/bits"
+)
+
+// PerceptualHash разностный хэш (dHash) изображения. У похожих изображений хэши отличаются на несколько бит
+func PerceptualHash(src image.Image) uint64 {
+	small := imaging.Resize(imaging.Grayscale(src), 9, 8, imaging.Box)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.NRGBAAt(x, y).R > small.NRGBAAt(x+1, y).R {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// HashDistance количество различающихся бит двух перцептивных хэшей
+func HashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// DescribeImage размеры и перцептивный хэш изображения
+func DescribeImage(data []byte) (int, int, uint64, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return src.Bounds().Dx(), src.Bounds().Dy(), PerceptualHash(src), nil
+}
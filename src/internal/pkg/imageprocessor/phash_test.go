@@ -0,0 +1,46 @@
+package imageprocessor
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createGradientImage горизонтальный градиент. reverse - от светлого к тёмному
+func createGradientImage(w, h int, reverse bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		v := uint8(x * 255 / (w - 1))
+		if reverse {
+			v = 255 - v
+		}
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	return img
+}
+
+func TestPerceptualHash(t *testing.T) {
+	src := createGradientImage(300, 200, false)
+
+	// Масштабирование и сжатие почти не меняют хэш
+	resized := imaging.Resize(src, 150, 100, imaging.Lanczos)
+	assert.LessOrEqual(t, HashDistance(PerceptualHash(src), PerceptualHash(resized)), 4)
+
+	// Противоположное изображение сильно отличается
+	assert.Greater(t, HashDistance(PerceptualHash(src), PerceptualHash(createGradientImage(300, 200, true))), 32)
+
+	width, height, hash, err := DescribeImage(encodeToJPEG(src))
+	require.NoError(t, err)
+	assert.Equal(t, 300, width)
+	assert.Equal(t, 200, height)
+	assert.LessOrEqual(t, HashDistance(PerceptualHash(src), hash), 4)
+
+	_, _, _, err = DescribeImage([]byte("not an image"))
+	assert.Error(t, err)
+}
@@ -16,6 +16,7 @@ type AppMetrics struct {
 	// Карта метрик по типам запросов
 	RequestTypes  map[string]*RequestTypeMetrics
 	DailyCounters map[string]*DailyCounter
+	Gauges        map[string]metrics.Gauge
 	cleanupPeriod time.Duration
 	ttl           time.Duration
 	ticker        *time.Ticker
@@ -64,6 +65,7 @@ func NewAppMetrics() *AppMetrics {
 	return &AppMetrics{
 		RequestTypes:  make(map[string]*RequestTypeMetrics),
 		DailyCounters: make(map[string]*DailyCounter),
+		Gauges:        make(map[string]metrics.Gauge),
 		// Устанавливаем время старта
 		StartTime:     time.Now(),
 		ttl:           time.Duration(48) * time.Hour,
@@ -133,6 +135,25 @@ func (m *AppMetrics) GetDailyMetricSafe(metricTime time.Time, metricType string)
 	return metric
 }
 
+func (m *AppMetrics) GetGaugeSafe(gaugeType string) metrics.Gauge {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.Gauges[gaugeType]; ok {
+		return existing
+	}
+
+	gauge := metrics.NewGauge()
+	metrics.GetOrRegister(fmt.Sprintf("app.gauges.%s", gaugeType), gauge)
+
+	m.Gauges[gaugeType] = gauge
+	return gauge
+}
+
+func (m *AppMetrics) UpdateGauge(gaugeType string, value int64) {
+	m.GetGaugeSafe(gaugeType).Update(value)
+}
+
 func (m *AppMetrics) IncrementSuccessRequest(requestType string) {
 	metric := m.GetRequestTypeMetricsSafe(requestType)
 	metric.IncrementSuccessRequest()
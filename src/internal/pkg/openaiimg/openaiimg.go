@@ -0,0 +1,373 @@
+package openaiimg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"imgserver/internal/pkg/actioner"
+	"imgserver/internal/pkg/asyncjob"
+	"imgserver/internal/pkg/httpclient"
+	"imgserver/internal/pkg/imageprocessor"
+	"imgserver/internal/pkg/opermanager"
+	"imgserver/internal/pkg/promptmanager"
+	"imgserver/internal/pkg/timerange"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ProviderCode = "OpenAi"
+
+	// Способы передачи негативного промпта
+	NegativeModeText      = "text"      // дописать к промпту текстом
+	NegativeModeSeparator = "separator" // LocalAI: "промпт|негатив"
+	NegativeModeField     = "field"     // отдельное поле negative_prompt
+
+	defaultBaseURL = "https://api.openai.com"
+	defaultTimeout = 180
+)
+
+var _ opermanager.ImageProvider = (*OpenAiImg)(nil)
+var _ opermanager.GenerationInfoProvider = (*OpenAiImg)(nil)
+
+// Размеры по умолчанию, из которых выбирается ближайший к пропорциям рамки
+var defaultSizes = []string{"1024x1024", "1024x1536", "1536x1024"}
+
+type OpenAiSleepTime struct {
+	TimeRange *timerange.TimeRange `yaml:"time_range"`
+}
+
+type OpenAiOptions struct {
+	ImageGenerateThreshold int                     `yaml:"image_generate_threshold"`
+	SleepTimes             []OpenAiSleepTime       `yaml:"sleep_time"`
+	BaseURL                string                  `yaml:"base_url"`
+	ApiKey                 string                  `yaml:"api_key"`
+	Model                  string                  `yaml:"model"`
+	Size                   string                  `yaml:"size"`
+	Sizes                  []string                `yaml:"sizes"`
+	Quality                string                  `yaml:"quality"`
+	ResponseFormat         string                  `yaml:"response_format"`
+	NegativePromptMode     string                  `yaml:"negative_prompt_mode"`
+	TimeoutSeconds         int                     `yaml:"timeout_seconds"`
+	Retry                  httpclient.RetryOptions `yaml:"retry"`
+}
+
+type OpenAiImg struct {
+	httpClient      *httpclient.Client
+	logger          *slog.Logger
+	options         *OpenAiOptions
+	imageParameters *opermanager.ImageParameters
+	promptManager   *promptmanager.PromptManager
+	actioner        *actioner.Actioner
+	ipr             *imageprocessor.Ipr
+	properties      *opermanager.ProviderProperties
+	jobs            *asyncjob.Jobs
+	*opermanager.GenerationInfoCache
+}
+
+type generateRequest struct {
+	Model          string `json:"model,omitempty"`
+	Prompt         string `json:"prompt"`
+	NegativePrompt string `json:"negative_prompt,omitempty"`
+	N              int    `json:"n"`
+	Size           string `json:"size,omitempty"`
+	Quality        string `json:"quality,omitempty"`
+	ResponseFormat string `json:"response_format,omitempty"`
+}
+
+type generateResponse struct {
+	Data  []imageData    `json:"data"`
+	Error *responseError `json:"error,omitempty"`
+}
+
+type imageData struct {
+	B64Json string `json:"b64_json"`
+	Url     string `json:"url"`
+}
+
+type responseError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+}
+
+func NewOpenAiImg(imageParameters imageprocessor.ImageParameters, promptManager *promptmanager.PromptManager, logger *slog.Logger, options *OpenAiOptions) *OpenAiImg {
+	if options.BaseURL == "" {
+		options.BaseURL = defaultBaseURL
+	}
+	options.BaseURL = strings.TrimRight(options.BaseURL, "/")
+	if options.NegativePromptMode == "" {
+		options.NegativePromptMode = NegativeModeText
+	}
+	if options.TimeoutSeconds <= 0 {
+		options.TimeoutSeconds = defaultTimeout
+	}
+
+	return &OpenAiImg{
+		httpClient:          httpclient.NewClient(time.Duration(options.TimeoutSeconds)*time.Second, options.Retry, logger),
+		logger:              logger,
+		options:             options,
+		promptManager:       promptManager,
+		actioner:            actioner.NewActioner(options.ImageGenerateThreshold, time.Minute),
+		ipr:                 imageprocessor.NewIpr(imageParameters, logger),
+		jobs:                asyncjob.NewJobs("oai"),
+		GenerationInfoCache: opermanager.NewGenerationInfoCache(),
+		properties: &opermanager.ProviderProperties{
+			IsCanWorkWithPrompt:  true,
+			IsNeedSaveLocalFiles: true,
+		},
+	}
+}
+
+func (oai *OpenAiImg) Start() error {
+	switch oai.options.NegativePromptMode {
+	case NegativeModeText, NegativeModeSeparator, NegativeModeField:
+	default:
+		return fmt.Errorf("unknown negative_prompt_mode: %s", oai.options.NegativePromptMode)
+	}
+	return nil
+}
+
+func (oai *OpenAiImg) SetImageParameters(parameters *opermanager.ImageParameters) error {
+	oai.imageParameters = parameters
+	return nil
+}
+
+func (oai *OpenAiImg) GetImageProviderForImageServerName() string {
+	return "OpenAiCompatible"
+}
+
+func (oai *OpenAiImg) GetImageProviderCode() string {
+	return ProviderCode
+}
+
+func (oai *OpenAiImg) GetProperties() *opermanager.ProviderProperties {
+	return oai.properties
+}
+
+func (oai *OpenAiImg) Generate(isDirectCall bool) (string, error) {
+	prompt, err := oai.promptManager.GetRandomPrompt()
+	if err != nil {
+		oai.logger.Error("Error when get prompt", "error", err.Error())
+		return "", err
+	}
+
+	negative := ""
+	if prompt.Negative != nil {
+		negative = *prompt.Negative
+	}
+	id, err := oai.GenerateWithPrompt(prompt.Prompt, negative, isDirectCall)
+	if err == nil {
+		oai.PutGenerationInfo(id, &opermanager.GenerationInfo{Prompt: prompt.Prompt, Negative: negative, Model: oai.options.Model, PromptIdx: prompt.Idx})
+	}
+	return id, err
+}
+
+// GenerateWithPrompt API синхронное, поэтому запрос выполняется в фоне,
+// а в качестве идентификатора операции возвращается собственный идентификатор задания
+func (oai *OpenAiImg) GenerateWithPrompt(prompt string, negative string, isDirectCall bool) (string, error) {
+	if prompt == "" {
+		return "", fmt.Errorf("prompt is empty")
+	}
+
+	request := oai.buildRequest(prompt, negative)
+	oai.logger.Debug("generate with prompt", "prompt", request.Prompt, "negative", request.NegativePrompt, "size", request.Size, "isDirect", isDirectCall)
+
+	if !isDirectCall {
+		oai.actioner.SetLastCallTime(time.Now())
+	}
+
+	jobId := oai.jobs.Start(func() ([]byte, error) {
+		image, err := oai.generate(request)
+		if err != nil {
+			oai.logger.Error("OpenAi generation error", "error", err)
+		}
+		return image, err
+	})
+
+	oai.logger.Debug("OpenAi job id", "id", jobId)
+	return jobId, nil
+}
+
+func (oai *OpenAiImg) GetImageSlice(operationId string) (bool, []byte, error) {
+	return oai.jobs.Result(operationId)
+}
+
+func (oai *OpenAiImg) IsReadyForRequest() bool {
+	if !oai.actioner.ThresholdOut(time.Now()) {
+		// Провайдер вызывался недавно. Он не готов к новому вызову.
+		return false
+	}
+
+	now := time.Now()
+	for _, st := range oai.options.SleepTimes {
+		inclusive, err := st.TimeRange.IsWithinRangeInclusive(now)
+		if err != nil {
+			oai.logger.Error("Get time range error", "error", err)
+		}
+		if inclusive {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (oai *OpenAiImg) buildRequest(prompt string, negative string) generateRequest {
+	request := generateRequest{
+		Model:          oai.options.Model,
+		Prompt:         prompt,
+		N:              1,
+		Size:           oai.chooseSize(),
+		Quality:        oai.options.Quality,
+		ResponseFormat: oai.options.ResponseFormat,
+	}
+
+	negative = strings.TrimSpace(negative)
+	if negative == "" {
+		return request
+	}
+
+	switch oai.options.NegativePromptMode {
+	case NegativeModeSeparator:
+		request.Prompt = prompt + "|" + negative
+	case NegativeModeField:
+		request.NegativePrompt = negative
+	default:
+		request.Prompt = prompt + ". Avoid: " + negative
+	}
+	return request
+}
+
+// chooseSize Явно заданный размер используется как есть.
+// Иначе из списка допустимых выбирается размер, ближайший к пропорциям рамки
+func (oai *OpenAiImg) chooseSize() string {
+	if oai.options.Size != "" {
+		return oai.options.Size
+	}
+
+	sizes := oai.options.Sizes
+	if len(sizes) == 0 {
+		sizes = defaultSizes
+	}
+
+	if oai.imageParameters == nil || oai.imageParameters.Height == 0 {
+		return sizes[0]
+	}
+
+	targetRatio := float64(oai.imageParameters.Weight) / float64(oai.imageParameters.Height)
+	result := sizes[0]
+	bestDiff := math.MaxFloat64
+	for _, size := range sizes {
+		w, h, err := parseSize(size)
+		if err != nil {
+			oai.logger.Warn("Skip invalid size", "size", size, "error", err)
+			continue
+		}
+		diff := math.Abs(float64(w)/float64(h) - targetRatio)
+		if diff < bestDiff {
+			bestDiff = diff
+			result = size
+		}
+	}
+	return result
+}
+
+func (oai *OpenAiImg) generate(request generateRequest) ([]byte, error) {
+	url := fmt.Sprintf("%s/v1/images/generations", oai.options.BaseURL)
+
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("error when data marshalling: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("error when create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if oai.options.ApiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+oai.options.ApiKey)
+	}
+
+	// Генерация платная: повтор только если сервер запрос не выполнял
+	resp, err := oai.httpClient.Do(httpclient.WithSafeRetry(req))
+	if err != nil {
+		return nil, fmt.Errorf("error when execute request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error when read body: %v", err)
+	}
+
+	var response generateResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status code: %s", resp.Status)
+		}
+		return nil, fmt.Errorf("error when parse body: %v", err)
+	}
+
+	if response.Error != nil {
+		return nil, fmt.Errorf("OpenAi return error: %s %s", response.Error.Code, response.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %s", resp.Status)
+	}
+	if len(response.Data) == 0 {
+		return nil, fmt.Errorf("OpenAi return empty data")
+	}
+
+	if response.Data[0].B64Json != "" {
+		return oai.ipr.ConvertBase64ToJpg(response.Data[0].B64Json)
+	}
+	if response.Data[0].Url != "" {
+		return oai.download(response.Data[0].Url)
+	}
+	return nil, fmt.Errorf("OpenAi return neither b64_json nor url")
+}
+
+func (oai *OpenAiImg) download(url string) ([]byte, error) {
+	resp, err := oai.httpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("error when download image: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code when download image: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error when read image: %v", err)
+	}
+	return oai.ipr.ConvertSliceToJpg(data)
+}
+
+func parseSize(size string) (int, int, error) {
+	parts := strings.Split(strings.ToLower(size), "x")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("size must be WIDTHxHEIGHT")
+	}
+	w, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	h, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	if w <= 0 || h <= 0 {
+		return 0, 0, fmt.Errorf("size must be positive")
+	}
+	return w, h, nil
+}
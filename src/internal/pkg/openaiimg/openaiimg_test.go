@@ -0,0 +1,119 @@
+package openaiimg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"imgserver/internal/pkg/imageprocessor"
+	"imgserver/internal/pkg/opermanager"
+	"imgserver/internal/pkg/testutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestProvider(options *OpenAiOptions) *OpenAiImg {
+	logger := testutil.NewLogger()
+	oai := NewOpenAiImg(imageprocessor.ImageParameters{ImageWeight: 320, ImageHeight: 480}, nil, logger, options)
+	_ = oai.SetImageParameters(&opermanager.ImageParameters{Weight: 320, Height: 480})
+	return oai
+}
+
+func TestOpenAiImg_GenerateWithPrompt(t *testing.T) {
+	pngData := testutil.CreatePng(t)
+	var got generateRequest
+	var auth string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/images/generations", r.URL.Path)
+		auth = r.Header.Get("Authorization")
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_ = json.NewEncoder(w).Encode(generateResponse{Data: []imageData{{B64Json: base64.StdEncoding.EncodeToString(pngData)}}})
+	}))
+	defer server.Close()
+
+	oai := newTestProvider(&OpenAiOptions{BaseURL: server.URL + "/", ApiKey: "secret", Model: "dall-e-3", Quality: "hd"})
+	require.NoError(t, oai.Start())
+
+	jobId, err := oai.GenerateWithPrompt("cat", "dog", true)
+	require.NoError(t, err)
+
+	data, err := testutil.WaitImage(t, oai, jobId)
+	require.NoError(t, err)
+
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+
+	assert.Equal(t, "Bearer secret", auth)
+	assert.Equal(t, "dall-e-3", got.Model)
+	assert.Equal(t, "hd", got.Quality)
+	assert.Equal(t, "1024x1536", got.Size) // ближайший к 320x480
+	assert.Equal(t, "cat. Avoid: dog", got.Prompt)
+
+	// Повторно результат не отдаётся
+	done, _, err := oai.GetImageSlice(jobId)
+	assert.True(t, done)
+	assert.Error(t, err)
+}
+
+func TestOpenAiImg_UrlResponse(t *testing.T) {
+	pngData := testutil.CreatePng(t)
+	var got generateRequest
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/v1/images/generations", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_ = json.NewEncoder(w).Encode(generateResponse{Data: []imageData{{Url: server.URL + "/files/1.png"}}})
+	})
+	mux.HandleFunc("/files/1.png", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(pngData)
+	})
+
+	oai := newTestProvider(&OpenAiOptions{BaseURL: server.URL, Size: "512x512", NegativePromptMode: NegativeModeField, ImageGenerateThreshold: 10})
+	jobId, err := oai.GenerateWithPrompt("cat", "dog", false)
+	require.NoError(t, err)
+
+	data, err := testutil.WaitImage(t, oai, jobId)
+	require.NoError(t, err)
+	assert.NotEmpty(t, data)
+
+	assert.Equal(t, "512x512", got.Size)
+	assert.Equal(t, "cat", got.Prompt)
+	assert.Equal(t, "dog", got.NegativePrompt)
+
+	// Автоматический вызов запускает отсчёт порога
+	assert.False(t, oai.IsReadyForRequest())
+}
+
+func TestOpenAiImg_ErrorResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(generateResponse{Error: &responseError{Code: "content_policy_violation", Message: "rejected"}})
+	}))
+	defer server.Close()
+
+	oai := newTestProvider(&OpenAiOptions{BaseURL: server.URL, NegativePromptMode: NegativeModeSeparator})
+	jobId, err := oai.GenerateWithPrompt("cat", "", true)
+	require.NoError(t, err)
+
+	_, err = testutil.WaitImage(t, oai, jobId)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "content_policy_violation")
+}
+
+func TestOpenAiImg_BuildRequestNegativeModes(t *testing.T) {
+	oai := newTestProvider(&OpenAiOptions{NegativePromptMode: NegativeModeSeparator})
+	assert.Equal(t, "cat|dog", oai.buildRequest("cat", "dog").Prompt)
+	assert.Equal(t, "cat", oai.buildRequest("cat", " ").Prompt)
+
+	oai = newTestProvider(&OpenAiOptions{NegativePromptMode: "unknown"})
+	assert.Error(t, oai.Start())
+}
@@ -0,0 +1,167 @@
+package opermanager
+
+import (
+	"errors"
+	"fmt"
+	"imgserver/internal/pkg/storage"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const BUDGETS_BUCKET = "budgets"
+
+var ErrBudgetExhausted = errors.New("provider budget is exhausted")
+
+// BudgetOptions ограничения на количество и стоимость генераций провайдера. 0 - без ограничения
+type BudgetOptions struct {
+	MaxPerDay       int     `yaml:"max_per_day"`
+	MaxPerMonth     int     `yaml:"max_per_month"`
+	PricePerImage   float64 `yaml:"price_per_image"`
+	MaxCostPerDay   float64 `yaml:"max_cost_per_day"`
+	MaxCostPerMonth float64 `yaml:"max_cost_per_month"`
+	Currency        string  `yaml:"currency"`
+}
+
+// budgetUsage израсходованное провайдером за текущие сутки и месяц. Хранится в хранилище
+type budgetUsage struct {
+	Day        string `json:"day"`
+	DayCount   int    `json:"day_count"`
+	Month      string `json:"month"`
+	MonthCount int    `json:"month_count"`
+}
+
+type budgetTracker struct {
+	options map[string]BudgetOptions
+	usage   map[string]*budgetUsage
+	storage *storage.Storage
+	logger  *slog.Logger
+	now     func() time.Time
+	mutex   sync.Mutex
+}
+
+func newBudgetTracker(storage *storage.Storage, logger *slog.Logger) *budgetTracker {
+	return &budgetTracker{
+		options: make(map[string]BudgetOptions),
+		usage:   make(map[string]*budgetUsage),
+		storage: storage,
+		logger:  logger,
+		now:     time.Now,
+	}
+}
+
+func (bt *budgetTracker) setOptions(options map[string]BudgetOptions) error {
+	for code, budget := range options {
+		if budget.MaxPerDay < 0 || budget.MaxPerMonth < 0 || budget.PricePerImage < 0 ||
+			budget.MaxCostPerDay < 0 || budget.MaxCostPerMonth < 0 {
+			return fmt.Errorf("negative budget value for provider %s", code)
+		}
+		if (budget.MaxCostPerDay > 0 || budget.MaxCostPerMonth > 0) && budget.PricePerImage == 0 {
+			return fmt.Errorf("cost limit without price_per_image for provider %s", code)
+		}
+	}
+
+	bt.mutex.Lock()
+	defer bt.mutex.Unlock()
+	bt.options = make(map[string]BudgetOptions, len(options))
+	for code, budget := range options {
+		bt.options[code] = budget
+	}
+	return nil
+}
+
+// getUsage возвращает расход за текущий период. При смене суток или месяца счётчик обнуляется
+func (bt *budgetTracker) getUsage(code string) *budgetUsage {
+	usage, ok := bt.usage[code]
+	if !ok {
+		usage = &budgetUsage{}
+		if bt.storage != nil {
+			_, err := bt.storage.Get(BUDGETS_BUCKET, code, usage)
+			if err != nil {
+				bt.logger.Error("Can not read provider budget", "provider", code, "error", err)
+			}
+		}
+		bt.usage[code] = usage
+	}
+
+	now := bt.now()
+	day := now.Format("2006-01-02")
+	month := now.Format("2006-01")
+	if usage.Day != day {
+		usage.Day = day
+		usage.DayCount = 0
+	}
+	if usage.Month != month {
+		usage.Month = month
+		usage.MonthCount = 0
+	}
+	return usage
+}
+
+func (bt *budgetTracker) saveUsage(code string, usage *budgetUsage) {
+	if bt.storage == nil {
+		return
+	}
+	err := bt.storage.Put(BUDGETS_BUCKET, code, usage)
+	if err != nil {
+		bt.logger.Error("Can not save provider budget", "provider", code, "error", err)
+	}
+}
+
+// exhaustedReason возвращает причину, по которой ещё одна генерация превысит бюджет, или пустую строку
+func (bt *budgetTracker) exhaustedReason(code string) string {
+	bt.mutex.Lock()
+	defer bt.mutex.Unlock()
+	return bt.exhaustedReasonLocked(code)
+}
+
+func (bt *budgetTracker) exhaustedReasonLocked(code string) string {
+	budget, ok := bt.options[code]
+	if !ok {
+		return ""
+	}
+
+	usage := bt.getUsage(code)
+	switch {
+	case budget.MaxPerDay > 0 && usage.DayCount >= budget.MaxPerDay:
+		return fmt.Sprintf("daily budget of %d generations is exhausted", budget.MaxPerDay)
+	case budget.MaxPerMonth > 0 && usage.MonthCount >= budget.MaxPerMonth:
+		return fmt.Sprintf("monthly budget of %d generations is exhausted", budget.MaxPerMonth)
+	case budget.MaxCostPerDay > 0 && float64(usage.DayCount+1)*budget.PricePerImage > budget.MaxCostPerDay:
+		return fmt.Sprintf("daily cost limit of %.2f %s is exhausted", budget.MaxCostPerDay, budget.Currency)
+	case budget.MaxCostPerMonth > 0 && float64(usage.MonthCount+1)*budget.PricePerImage > budget.MaxCostPerMonth:
+		return fmt.Sprintf("monthly cost limit of %.2f %s is exhausted", budget.MaxCostPerMonth, budget.Currency)
+	}
+	return ""
+}
+
+// reserve учитывает генерацию до обращения к провайдеру, чтобы параллельные запросы не превысили бюджет
+func (bt *budgetTracker) reserve(code string) error {
+	bt.mutex.Lock()
+	defer bt.mutex.Unlock()
+
+	if reason := bt.exhaustedReasonLocked(code); reason != "" {
+		return fmt.Errorf("%w: %s: %s", ErrBudgetExhausted, code, reason)
+	}
+
+	usage := bt.getUsage(code)
+	usage.DayCount++
+	usage.MonthCount++
+	bt.saveUsage(code, usage)
+	return nil
+}
+
+// release возвращает генерацию, которую провайдер не принял
+func (bt *budgetTracker) release(code string) {
+	bt.mutex.Lock()
+	defer bt.mutex.Unlock()
+
+	usage := bt.getUsage(code)
+	if usage.DayCount > 0 {
+		usage.DayCount--
+	}
+	if usage.MonthCount > 0 {
+		usage.MonthCount--
+	}
+	bt.saveUsage(code, usage)
+}
@@ -0,0 +1,77 @@
+package opermanager
+
+import (
+	"imgserver/internal/pkg/storage"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBudgetTracker(t *testing.T, st *storage.Storage, now *time.Time, options map[string]BudgetOptions) *budgetTracker {
+	bt := newBudgetTracker(st, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	bt.now = func() time.Time { return *now }
+	require.NoError(t, bt.setOptions(options))
+	return bt
+}
+
+func TestBudgetTracker_LimitsPersisted(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	path := filepath.Join(t.TempDir(), "test.db")
+	st, err := storage.NewStorage(path, logger)
+	require.NoError(t, err)
+
+	now := time.Date(2025, 1, 31, 10, 0, 0, 0, time.Local)
+	options := map[string]BudgetOptions{"A": {MaxPerDay: 2, MaxPerMonth: 3}}
+	bt := newTestBudgetTracker(t, st, &now, options)
+
+	require.NoError(t, bt.reserve("A"))
+	require.NoError(t, bt.reserve("A"))
+	assert.ErrorIs(t, bt.reserve("A"), ErrBudgetExhausted)
+	assert.Empty(t, bt.exhaustedReason("B"), "provider without budget must not be limited")
+
+	// После рестарта счётчик читается из хранилища
+	require.NoError(t, st.Close())
+	st, err = storage.NewStorage(path, logger)
+	require.NoError(t, err)
+	defer st.Close()
+
+	bt = newTestBudgetTracker(t, st, &now, options)
+	assert.NotEmpty(t, bt.exhaustedReason("A"), "budget must survive restart")
+
+	// Новые сутки и новый месяц - оба счётчика сброшены
+	now = time.Date(2025, 2, 1, 10, 0, 0, 0, time.Local)
+	require.NoError(t, bt.reserve("A"))
+	require.NoError(t, bt.reserve("A"))
+
+	// Новые сутки того же месяца - дневной лимит сброшен, месячный нет
+	now = time.Date(2025, 2, 2, 10, 0, 0, 0, time.Local)
+	require.NoError(t, bt.reserve("A"))
+	assert.Equal(t, "monthly budget of 3 generations is exhausted", bt.exhaustedReason("A"))
+}
+
+func TestBudgetTracker_CostLimitAndRelease(t *testing.T) {
+	now := time.Date(2025, 1, 1, 10, 0, 0, 0, time.Local)
+	bt := newTestBudgetTracker(t, nil, &now, map[string]BudgetOptions{
+		"A": {PricePerImage: 2.5, MaxCostPerMonth: 5, Currency: "RUB"},
+	})
+
+	require.NoError(t, bt.reserve("A"))
+	require.NoError(t, bt.reserve("A"))
+	assert.ErrorIs(t, bt.reserve("A"), ErrBudgetExhausted)
+
+	// Провайдер не принял запрос - генерация не тратит бюджет
+	bt.release("A")
+	assert.Empty(t, bt.exhaustedReason("A"))
+}
+
+func TestBudgetTracker_InvalidOptions(t *testing.T) {
+	bt := newBudgetTracker(nil, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+
+	assert.Error(t, bt.setOptions(map[string]BudgetOptions{"A": {MaxCostPerDay: 10}}), "cost limit without price")
+	assert.Error(t, bt.setOptions(map[string]BudgetOptions{"A": {MaxPerDay: -1}}))
+}
@@ -0,0 +1,160 @@
+package opermanager
+
+import (
+	"errors"
+	"fmt"
+	"imgserver/internal/pkg/actioner"
+	"imgserver/internal/pkg/imageprocessor"
+	"imgserver/internal/pkg/promptmanager"
+	"regexp"
+	"slices"
+	"time"
+)
+
+var ErrUnknownDevice = errors.New("unknown device")
+
+var deviceIdPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// PromptSource набор промптов, из которого выбирается промпт для рамки
+type PromptSource interface {
+	GetRandomPrompt() (promptmanager.PromptValue, error)
+}
+
+// DeviceParameters настройки отдельной рамки. Незаданные значения берутся из настроек сервера
+type DeviceParameters struct {
+	ImageParameters imageprocessor.ImageParameters
+	// ThresholdMinutes 0 - как у сервера
+	ThresholdMinutes int
+	// SleepTimes nil - периоды сна сервера
+	SleepTimes []*SleepTime
+	// Providers коды разрешённых провайдеров. Пустой список - все провайдеры
+	Providers []string
+	// Prompts nil - промпт выбирает сам провайдер
+	Prompts PromptSource
+}
+
+// device рамка, для которой готовятся изображения
+type device struct {
+	id              string
+	imageParameters ImageParameters
+	ipr             *imageprocessor.Ipr
+	actioner        *actioner.Actioner
+	// sleepTimes и providers меняются при перечитывании настроек, читать их под op.settingsMutex
+	sleepTimes    []*SleepTime
+	providers     []string
+	prompts       PromptSource
+	blackFileName string
+	// parameters настройки рамки как заданы, без подстановки настроек сервера
+	parameters DeviceParameters
+}
+
+// AddDevice регистрирует рамку. Запросы без идентификатора рамки обслуживаются по настройкам сервера
+func (op *OperMngr) AddDevice(id string, parameters DeviceParameters) error {
+	if !deviceIdPattern.MatchString(id) {
+		return fmt.Errorf("invalid device id %q: only latin letters, digits, '-' and '_' are allowed", id)
+	}
+	if _, exists := op.devices[id]; exists {
+		return fmt.Errorf("duplicate device id: %s", id)
+	}
+
+	imageParameters := parameters.ImageParameters
+	if imageParameters.ImageWeight <= 0 || imageParameters.ImageHeight <= 0 {
+		return fmt.Errorf("device %s: image size must be positive", id)
+	}
+
+	dev := &device{
+		id:              id,
+		imageParameters: ImageParameters{Height: imageParameters.ImageHeight, Weight: imageParameters.ImageWeight},
+		ipr:             imageprocessor.NewIpr(imageParameters, op.logger),
+		actioner:        actioner.NewActioner(0, time.Minute),
+		prompts:         parameters.Prompts,
+		blackFileName:   "black-" + id + ".jpeg",
+		parameters:      parameters,
+	}
+	op.resolveDeviceSettings(dev)
+	op.devices[id] = dev
+	op.logger.Info("Add device", "device", id, "width", imageParameters.ImageWeight, "height", imageParameters.ImageHeight)
+	return nil
+}
+
+// resolveDeviceSettings подставляет настройки сервера вместо незаданных настроек рамки.
+// Вызывается при добавлении рамки и под op.settingsMutex при перечитывании настроек
+func (op *OperMngr) resolveDeviceSettings(dev *device) {
+	threshold := dev.parameters.ThresholdMinutes
+	if threshold <= 0 {
+		threshold = op.thresholdMinutes
+	}
+	sleepTimes := dev.parameters.SleepTimes
+	if sleepTimes == nil {
+		sleepTimes = op.sleepTimes
+	}
+
+	dev.actioner.SetThreshold(threshold, time.Minute)
+	dev.sleepTimes = sleepTimes
+	dev.providers = dev.parameters.Providers
+}
+
+// getDevice пустой идентификатор - рамка по умолчанию
+func (op *OperMngr) getDevice(id string) (*device, error) {
+	if id == "" {
+		return op.defaultDevice, nil
+	}
+	dev, ok := op.devices[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDevice, id)
+	}
+	return dev, nil
+}
+
+// findDevice рамка операции. Если рамку убрали из настроек, изображение готовится по настройкам сервера
+func (op *OperMngr) findDevice(id string) *device {
+	dev, err := op.getDevice(id)
+	if err != nil {
+		op.logger.Warn("Device not found, use default", "device", id)
+		return op.defaultDevice
+	}
+	return dev
+}
+
+// startDevices проверяет настройки рамок и готовит для них чёрные изображения
+func (op *OperMngr) startDevices() error {
+	for _, dev := range op.devices {
+		for _, st := range dev.sleepTimes {
+			if _, err := st.TimeRange.IsWithinRangeInclusive(time.Now()); err != nil {
+				return fmt.Errorf("device %s: %v", dev.id, err)
+			}
+		}
+		for _, code := range dev.providers {
+			if op.findImageProvider(code) == nil {
+				return fmt.Errorf("device %s: unknown provider %s", dev.id, code)
+			}
+		}
+		err := op.createBlackJPEGSafe(dev.imageParameters.Weight, dev.imageParameters.Height, dev.blackFileName)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deviceProviders провайдеры, разрешённые для рамки
+// и не выключенные в настройках сервера
+func (op *OperMngr) deviceProviders(dev *device, providers []*ImageProvider) []*ImageProvider {
+	op.settingsMutex.RLock()
+	defer op.settingsMutex.RUnlock()
+
+	if len(dev.providers) == 0 && len(op.disabledProviders) == 0 {
+		return providers
+	}
+	result := make([]*ImageProvider, 0, len(providers))
+	for _, provider := range providers {
+		code := (*provider).GetImageProviderCode()
+		if slices.Contains(op.disabledProviders, code) {
+			continue
+		}
+		if len(dev.providers) == 0 || slices.Contains(dev.providers, code) {
+			result = append(result, provider)
+		}
+	}
+	return result
+}
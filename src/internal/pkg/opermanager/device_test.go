@@ -0,0 +1,82 @@
+package opermanager
+
+import (
+	"bytes"
+	"image/jpeg"
+	"imgserver/internal/pkg/imageprocessor"
+	"imgserver/internal/pkg/promptmanager"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePromptSource struct {
+	prompt string
+}
+
+func (fps *fakePromptSource) GetRandomPrompt() (promptmanager.PromptValue, error) {
+	return promptmanager.PromptValue{Prompt: fps.prompt}, nil
+}
+
+func requireImageSize(t *testing.T, op *OperMngr, id string, width, height int) {
+	status, err := op.GetOperationStatus(id)
+	require.NoError(t, err)
+	require.Equal(t, StatusDone, status.Status)
+	fileName, err := op.GetFileName(id)
+	require.NoError(t, err)
+	data, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, width, config.Width)
+	assert.Equal(t, height, config.Height)
+}
+
+func TestDevice_RenderAndProviders(t *testing.T) {
+	t.Chdir(t.TempDir())
+	first := &imageFakeProvider{fakeProvider: fakeProvider{code: "First", ready: true}, image: newTestJpeg(t), withPrompt: true}
+	second := &imageFakeProvider{fakeProvider: fakeProvider{code: "Second", ready: true}, image: newTestJpeg(t)}
+	op := newTestOperMngr(t, nil, func(op *OperMngr) {
+		require.NoError(t, op.AddDevice("kitchen", DeviceParameters{
+			ImageParameters: imageprocessor.ImageParameters{ImageWeight: 20, ImageHeight: 10},
+			Providers:       []string{"First"},
+			Prompts:         &fakePromptSource{prompt: "kitchen prompt"},
+		}))
+	}, first, second)
+
+	for i := 0; i < 5; i++ {
+		id, err := op.StartDeviceOperation("kitchen", "auto", "", "")
+		require.NoError(t, err)
+		op.CheckPendingOperations()
+		requireImageSize(t, op, id, 20, 10)
+	}
+	assert.EqualValues(t, 5, first.generated.Load())
+	assert.EqualValues(t, 0, second.generated.Load())
+	assert.Equal(t, "kitchen prompt", first.lastPrompt)
+
+	// Рамка по умолчанию
+	id, err := op.StartOperation("auto", "", "")
+	require.NoError(t, err)
+	op.CheckPendingOperations()
+	requireImageSize(t, op, id, 40, 30)
+
+	_, err = op.StartDeviceOperation("hall", "auto", "", "")
+	assert.ErrorIs(t, err, ErrUnknownDevice)
+}
+
+func TestDevice_InvalidParameters(t *testing.T) {
+	t.Chdir(t.TempDir())
+	provider := &imageFakeProvider{fakeProvider: fakeProvider{code: "Fake", ready: true}, image: newTestJpeg(t)}
+	op := newTestOperMngr(t, nil, nil, provider)
+	params := DeviceParameters{ImageParameters: imageprocessor.ImageParameters{ImageWeight: 20, ImageHeight: 10}}
+
+	assert.Error(t, op.AddDevice("../hall", params))
+	assert.Error(t, op.AddDevice("hall", DeviceParameters{}))
+	require.NoError(t, op.AddDevice("hall", params))
+	assert.Error(t, op.AddDevice("hall", params))
+
+	require.NoError(t, op.AddDevice("bedroom", DeviceParameters{ImageParameters: params.ImageParameters, Providers: []string{"Unknown"}}))
+	assert.Error(t, op.startDevices())
+}
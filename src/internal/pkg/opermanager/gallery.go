@@ -0,0 +1,189 @@
+package opermanager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MAX_RENDER_SIZE ограничение стороны изображения при перерисовке, чтобы запрос не съел всю память
+const MAX_RENDER_SIZE = 8192
+
+var ErrInvalidImageSize = errors.New("invalid image size")
+
+// ImageFilter условия отбора изображений галереи. Пустые поля не учитываются
+type ImageFilter struct {
+	ProviderCode string
+	From         time.Time
+	To           time.Time
+	// Prompt часть текста промпта без учёта регистра
+	Prompt string
+}
+
+func (filter *ImageFilter) match(record *ImageMetadata) bool {
+	if filter.ProviderCode != "" && !strings.EqualFold(filter.ProviderCode, record.ProviderCode) {
+		return false
+	}
+	if !filter.From.IsZero() && record.CreatedAt.Before(filter.From) {
+		return false
+	}
+	if !filter.To.IsZero() && !record.CreatedAt.Before(filter.To) {
+		return false
+	}
+	if filter.Prompt != "" {
+		if record.Info == nil || !strings.Contains(strings.ToLower(record.Info.Prompt), strings.ToLower(filter.Prompt)) {
+			return false
+		}
+	}
+	return true
+}
+
+// ListImages изображения каталога оригиналов, от новых к старым.
+// Возвращает страницу и общее количество подходящих под фильтр изображений
+func (op *OperMngr) ListImages(filter ImageFilter, offset int, limit int) ([]ImageMetadata, int, error) {
+	records := make(map[string]ImageMetadata)
+	if op.storage != nil {
+		err := op.storage.ForEach(IMAGES_BUCKET, func(key string, data []byte) error {
+			var record ImageMetadata
+			if err := json.Unmarshal(data, &record); err != nil {
+				op.logger.Warn("Skip broken image record", "file", key, "error", err)
+				return nil
+			}
+			records[key] = record
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	result := make([]ImageMetadata, 0)
+	for _, file := range op.dirManager.GetFiles() {
+		name := filepath.Base(file)
+		record, ok := records[name]
+		if !ok {
+			// Индекс ещё не построен. Показываем файл хотя бы по имени
+			record = ImageMetadata{FileName: name, Source: ImageSourceLegacy}
+		}
+		if filter.match(&record) {
+			result = append(result, record)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].FileName > result[j].FileName
+		}
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+
+	total := len(result)
+	if offset >= total {
+		return []ImageMetadata{}, total, nil
+	}
+	end := total
+	if limit > 0 && offset+limit < total {
+		end = offset + limit
+	}
+	return result[offset:end], total, nil
+}
+
+// GetImagePath путь к оригиналу по имени файла. Имя не может указывать за пределы каталога оригиналов
+func (op *OperMngr) GetImagePath(fileName string) (string, error) {
+	if fileName == "" || fileName != filepath.Base(fileName) || !strings.HasSuffix(fileName, ".jpeg") {
+		return "", fmt.Errorf("%w: %s", ErrImageNotFound, fileName)
+	}
+	path := filepath.Join(op.dirManager.GetDirectoryPath(), fileName)
+	if !op.dirManager.HasFile(path) {
+		return "", fmt.Errorf("%w: %s", ErrImageNotFound, fileName)
+	}
+	return path, nil
+}
+
+// RenderImage готовит оригинал для рамки. Нулевые ширина и высота - размер рамки
+func (op *OperMngr) RenderImage(deviceId string, fileName string, width int, height int) ([]byte, error) {
+	dev, err := op.getDevice(deviceId)
+	if err != nil {
+		return nil, err
+	}
+	if width == 0 && height == 0 {
+		width = dev.imageParameters.Weight
+		height = dev.imageParameters.Height
+	}
+	if width <= 0 || height <= 0 || width > MAX_RENDER_SIZE || height > MAX_RENDER_SIZE {
+		return nil, fmt.Errorf("%w: %dx%d", ErrInvalidImageSize, width, height)
+	}
+
+	path, err := op.GetImagePath(fileName)
+	if err != nil {
+		return nil, err
+	}
+	imageData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fit, _, err := dev.ipr.ProcessImageFromSLice(imageData, width, height, false)
+	return fit, err
+}
+
+// ImageUpdate изменяемые отметки изображения. nil - не менять
+type ImageUpdate struct {
+	Favorite *bool
+	Pinned   *bool
+}
+
+// UpdateImage отмечает изображение избранным или закреплённым
+func (op *OperMngr) UpdateImage(fileName string, update ImageUpdate) (*ImageMetadata, error) {
+	path, err := op.GetImagePath(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if op.storage == nil {
+		return nil, fmt.Errorf("%w: %s", ErrImageNotFound, fileName)
+	}
+
+	op.index.mutex.Lock()
+	defer op.index.mutex.Unlock()
+
+	record, err := op.GetImageMetadata(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if update.Favorite != nil {
+		record.Favorite = *update.Favorite
+	}
+	if update.Pinned != nil {
+		record.Pinned = *update.Pinned
+	}
+	err = op.storage.Put(IMAGES_BUCKET, record.FileName, record)
+	if err != nil {
+		return nil, err
+	}
+	op.applyImageFlags(path, record)
+	op.logger.Info("Image updated", "file", fileName, "favorite", record.Favorite, "pinned", record.Pinned)
+	return record, nil
+}
+
+// DeleteImage удаляет оригинал из каталога. Запись метаданных удаляется через DirManager
+func (op *OperMngr) DeleteImage(fileName string) error {
+	path, err := op.GetImagePath(fileName)
+	if err != nil {
+		return err
+	}
+	err = op.dirManager.RemoveFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrImageNotFound, fileName)
+	}
+	if err != nil {
+		return err
+	}
+	op.logger.Info("Image deleted", "file", fileName)
+	return nil
+}
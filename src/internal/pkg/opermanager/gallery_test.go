@@ -0,0 +1,191 @@
+package opermanager
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"imgserver/internal/pkg/imageprocessor"
+	"imgserver/internal/pkg/storage"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGallery_ListRenderDelete(t *testing.T) {
+	t.Chdir(t.TempDir())
+	st, err := storage.NewStorage("test.db", slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	require.NoError(t, err)
+	defer st.Close()
+
+	provider := &imageFakeProvider{fakeProvider: fakeProvider{code: "Fake", ready: true}, image: newTestJpeg(t), withPrompt: true, saveLocal: true}
+
+	// Старый файл, лежавший в каталоге до индекса. Кладётся до запуска, чтобы чтение каталога
+	// при старте не проиндексировало его раньше, чем поменяется время файла
+	legacy := filepath.Join("images", "f1700000000-orig.jpeg")
+	require.NoError(t, os.MkdirAll("images", 0755))
+	require.NoError(t, os.WriteFile(legacy, provider.image, 0644))
+	created := time.Date(2024, 3, 10, 12, 0, 0, 0, time.Local)
+	require.NoError(t, os.Chtimes(legacy, created, created))
+
+	op := newTestOperMngr(t, st, nil, provider)
+	require.NoError(t, op.dirManager.ReadFiles())
+
+	_, err = op.StartOperation("ydart", "Red fox in the snow", "")
+	require.NoError(t, err)
+	op.CheckPendingOperations()
+
+	images, total, err := op.ListImages(ImageFilter{}, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, 2, total)
+	assert.Equal(t, ImageSourceGenerated, images[0].Source)
+	assert.Equal(t, "f1700000000-orig.jpeg", images[1].FileName)
+
+	// Постраничная выдача
+	images, total, err = op.ListImages(ImageFilter{}, 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+	require.Len(t, images, 1)
+	assert.Equal(t, "f1700000000-orig.jpeg", images[0].FileName)
+
+	images, _, err = op.ListImages(ImageFilter{}, 5, 1)
+	require.NoError(t, err)
+	assert.Empty(t, images)
+
+	// Фильтры
+	images, total, err = op.ListImages(ImageFilter{Prompt: "red FOX"}, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	generated := images[0].FileName
+
+	_, total, err = op.ListImages(ImageFilter{ProviderCode: "fake"}, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+
+	images, total, err = op.ListImages(ImageFilter{From: created.Add(-time.Hour), To: created.Add(time.Hour)}, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	assert.Equal(t, "f1700000000-orig.jpeg", images[0].FileName)
+
+	// Перерисовка под рамку и под заданный размер
+	data, err := op.RenderImage("", generated, 0, 0)
+	require.NoError(t, err)
+	assertJpegSize(t, data, 40, 30)
+
+	data, err = op.RenderImage("", generated, 20, 20)
+	require.NoError(t, err)
+	assertJpegSize(t, data, 20, 20)
+
+	_, err = op.RenderImage("", generated, 0, 20)
+	assert.ErrorIs(t, err, ErrInvalidImageSize)
+	_, err = op.RenderImage("", "../test.db", 20, 20)
+	assert.ErrorIs(t, err, ErrImageNotFound)
+
+	// Удаление убирает файл, запись индекса и файл из выбора
+	require.NoError(t, op.DeleteImage(generated))
+	_, err = os.Stat(filepath.Join("images", generated))
+	assert.True(t, os.IsNotExist(err))
+	_, err = op.GetImageMetadata(generated)
+	assert.ErrorIs(t, err, ErrImageNotFound)
+	assert.Equal(t, []string{legacy}, op.dirManager.GetFiles())
+
+	assert.ErrorIs(t, op.DeleteImage(generated), ErrImageNotFound)
+}
+
+func assertJpegSize(t *testing.T, data []byte, width int, height int) {
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, width, config.Width)
+	assert.Equal(t, height, config.Height)
+}
+
+func TestGallery_UpdateImage(t *testing.T) {
+	t.Chdir(t.TempDir())
+	st, err := storage.NewStorage("test.db", slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	require.NoError(t, err)
+	defer st.Close()
+
+	provider := &imageFakeProvider{fakeProvider: fakeProvider{code: "Fake", ready: true}, image: newTestJpeg(t)}
+	op := newTestOperMngr(t, st, nil, provider)
+
+	name := "f1700000000-orig.jpeg"
+	require.NoError(t, os.WriteFile(filepath.Join("images", name), provider.image, 0644))
+	require.NoError(t, op.dirManager.ReadFiles())
+
+	favorite, pinned := true, true
+	metadata, err := op.UpdateImage(name, ImageUpdate{Favorite: &favorite, Pinned: &pinned})
+	require.NoError(t, err)
+	assert.True(t, metadata.Favorite)
+	assert.True(t, metadata.Pinned)
+	assert.Contains(t, op.history.favorites, name)
+
+	// Непереданная отметка не меняется
+	favorite = false
+	metadata, err = op.UpdateImage(name, ImageUpdate{Favorite: &favorite})
+	require.NoError(t, err)
+	assert.False(t, metadata.Favorite)
+	assert.True(t, metadata.Pinned)
+	assert.NotContains(t, op.history.favorites, name)
+
+	// После перечитывания каталога отметки восстанавливаются из индекса
+	favorite = true
+	_, err = op.UpdateImage(name, ImageUpdate{Favorite: &favorite})
+	require.NoError(t, err)
+	op.history.favorites = make(map[string]struct{})
+	require.NoError(t, op.dirManager.ReadFiles())
+	assert.Contains(t, op.history.favorites, name)
+
+	_, err = op.UpdateImage("unknown.jpeg", ImageUpdate{Favorite: &favorite})
+	assert.ErrorIs(t, err, ErrImageNotFound)
+}
+
+func TestGallery_AddUploadedImage(t *testing.T) {
+	t.Chdir(t.TempDir())
+	st, err := storage.NewStorage("test.db", slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	require.NoError(t, err)
+	defer st.Close()
+
+	provider := &imageFakeProvider{fakeProvider: fakeProvider{code: "Fake", ready: true}, image: newTestJpeg(t)}
+	op := newTestOperMngr(t, st, nil, provider)
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 48))))
+	metadata, err := op.AddUploadedImage(buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, ImageSourceUploaded, metadata.Source)
+	assert.Equal(t, 64, metadata.Width)
+	assert.Equal(t, 48, metadata.Height)
+
+	// Оригинал сохранён как JPEG и участвует в выборе изображений
+	path, err := op.GetImagePath(metadata.FileName)
+	require.NoError(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assertJpegSize(t, data, 64, 48)
+
+	stored, err := op.GetImageMetadata(metadata.FileName)
+	require.NoError(t, err)
+	assert.Equal(t, ImageSourceUploaded, stored.Source)
+
+	_, err = op.AddUploadedImage([]byte("not an image"))
+	assert.ErrorIs(t, err, imageprocessor.ErrUnsupportedImage)
+	assert.Len(t, op.dirManager.GetFiles(), 1)
+
+	// Пачка с испорченным файлом не сохраняется целиком
+	_, err = op.AddUploadedImages([][]byte{buf.Bytes(), []byte("not an image")})
+	var uploadErr *UploadError
+	require.ErrorAs(t, err, &uploadErr)
+	assert.Equal(t, 1, uploadErr.Index)
+	assert.ErrorIs(t, err, imageprocessor.ErrUnsupportedImage)
+	assert.Len(t, op.dirManager.GetFiles(), 1)
+
+	images, err := op.AddUploadedImages([][]byte{buf.Bytes(), buf.Bytes()})
+	require.NoError(t, err)
+	assert.Len(t, images, 2)
+	assert.Len(t, op.dirManager.GetFiles(), 3)
+}
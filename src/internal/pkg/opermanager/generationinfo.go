@@ -0,0 +1,40 @@
+package opermanager
+
+import (
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+const generationInfoTTL = 10 * time.Minute
+
+// GenerationInfoCache хранит параметры запущенных генераций, пока их не заберёт OperMngr.
+// Провайдер встраивает его, чтобы реализовать GenerationInfoProvider
+type GenerationInfoCache struct {
+	infos *cache.Cache
+}
+
+func NewGenerationInfoCache() *GenerationInfoCache {
+	return &GenerationInfoCache{infos: cache.New(generationInfoTTL, 2*generationInfoTTL)}
+}
+
+func (gic *GenerationInfoCache) PutGenerationInfo(externalId string, info *GenerationInfo) {
+	gic.infos.SetDefault(externalId, info)
+}
+
+// SetPromptIdx дополняет параметры генерации номером промпта из файла
+func (gic *GenerationInfoCache) SetPromptIdx(externalId string, idx int) {
+	if info, ok := gic.infos.Get(externalId); ok {
+		info.(*GenerationInfo).PromptIdx = idx
+	}
+}
+
+// GetGenerationInfo параметры генерации. Отдаются один раз
+func (gic *GenerationInfoCache) GetGenerationInfo(externalId string) *GenerationInfo {
+	info, ok := gic.infos.Get(externalId)
+	if !ok {
+		return nil
+	}
+	gic.infos.Delete(externalId)
+	return info.(*GenerationInfo)
+}
@@ -0,0 +1,196 @@
+package opermanager
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "half_open"
+)
+
+const (
+	METRIC_TEMPLATE_PROVIDER_STATE    = "PROVIDER_STATE_"
+	METRIC_TEMPLATE_PROVIDER_FAILURES = "PROVIDER_FAILURES_"
+	METRIC_TEMPLATE_PROVIDER_TRIPS    = "PROVIDER_TRIPS_"
+)
+
+// HealthOptions настройки автоматического выключения сбоящего провайдера
+type HealthOptions struct {
+	// FailureThreshold количество ошибок подряд, после которого провайдер выключается
+	FailureThreshold int `yaml:"failure_threshold"`
+	// CoolDownSeconds время выключения после первого срабатывания. Каждое следующее срабатывание подряд удваивает его
+	CoolDownSeconds int `yaml:"cool_down_seconds"`
+	// MaxCoolDownSeconds верхняя граница времени выключения
+	MaxCoolDownSeconds int `yaml:"max_cool_down_seconds"`
+}
+
+// ProviderHealth состояние провайдера
+type ProviderHealth struct {
+	Code                string       `json:"code"`
+	State               CircuitState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	Trips               int          `json:"trips"`
+	LastError           string       `json:"last_error,omitempty"`
+	LastErrorTime       time.Time    `json:"last_error_time,omitempty"`
+	LastSuccessTime     time.Time    `json:"last_success_time,omitempty"`
+	OpenUntil           time.Time    `json:"open_until,omitempty"`
+	probeStarted        time.Time
+}
+
+type healthTracker struct {
+	options   HealthOptions
+	providers map[string]*ProviderHealth
+	now       func() time.Time
+	mutex     sync.Mutex
+}
+
+func defaultHealthOptions() HealthOptions {
+	return HealthOptions{FailureThreshold: 3, CoolDownSeconds: 60, MaxCoolDownSeconds: 3600}
+}
+
+func newHealthTracker() *healthTracker {
+	return &healthTracker{
+		options:   defaultHealthOptions(),
+		providers: make(map[string]*ProviderHealth),
+		now:       time.Now,
+	}
+}
+
+func (ht *healthTracker) setOptions(options HealthOptions) error {
+	defaults := defaultHealthOptions()
+	if options.FailureThreshold == 0 {
+		options.FailureThreshold = defaults.FailureThreshold
+	}
+	if options.CoolDownSeconds == 0 {
+		options.CoolDownSeconds = defaults.CoolDownSeconds
+	}
+	if options.MaxCoolDownSeconds == 0 {
+		options.MaxCoolDownSeconds = defaults.MaxCoolDownSeconds
+	}
+	if options.FailureThreshold < 0 || options.CoolDownSeconds < 0 || options.MaxCoolDownSeconds < options.CoolDownSeconds {
+		return fmt.Errorf("invalid provider health options: %+v", options)
+	}
+
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+	ht.options = options
+	return nil
+}
+
+func (ht *healthTracker) get(code string) *ProviderHealth {
+	health, ok := ht.providers[code]
+	if !ok {
+		health = &ProviderHealth{Code: code, State: CircuitClosed}
+		ht.providers[code] = health
+	}
+	return health
+}
+
+// unavailableReason возвращает причину, по которой провайдер нельзя выбирать, или пустую строку
+func (ht *healthTracker) unavailableReason(code string) string {
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+
+	health := ht.get(code)
+	now := ht.now()
+	switch health.State {
+	case CircuitOpen:
+		if now.Before(health.OpenUntil) {
+			return fmt.Sprintf("circuit is open until %s", health.OpenUntil.Format(time.RFC3339))
+		}
+	case CircuitHalfOpen:
+		// Пробный запрос ещё не завершился. Если он потерялся, через время выключения разрешаем новый
+		if now.Before(health.probeStarted.Add(ht.coolDown(health.Trips))) {
+			return "circuit is half open, probe request is in progress"
+		}
+	}
+	return ""
+}
+
+// acquire вызывается, когда провайдер выбран. Для выключенного провайдера запрос становится пробным
+func (ht *healthTracker) acquire(code string) {
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+
+	health := ht.get(code)
+	if health.State != CircuitClosed {
+		health.State = CircuitHalfOpen
+		health.probeStarted = ht.now()
+	}
+}
+
+func (ht *healthTracker) recordSuccess(code string) ProviderHealth {
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+
+	health := ht.get(code)
+	health.State = CircuitClosed
+	health.ConsecutiveFailures = 0
+	health.Trips = 0
+	health.OpenUntil = time.Time{}
+	health.LastSuccessTime = ht.now()
+	return *health
+}
+
+// recordFailure учитывает ошибку. tripped - провайдер выключен этой ошибкой
+func (ht *healthTracker) recordFailure(code string, err error) (health ProviderHealth, tripped bool) {
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+
+	current := ht.get(code)
+	now := ht.now()
+	current.ConsecutiveFailures++
+	current.LastError = err.Error()
+	current.LastErrorTime = now
+
+	// Пробный запрос не прошёл или ошибок стало слишком много - выключаем провайдер
+	if current.State == CircuitHalfOpen ||
+		(current.State == CircuitClosed && current.ConsecutiveFailures >= ht.options.FailureThreshold) {
+		current.Trips++
+		current.State = CircuitOpen
+		current.OpenUntil = now.Add(ht.coolDown(current.Trips))
+		tripped = true
+	}
+	return *current, tripped
+}
+
+// coolDown время выключения после trips срабатываний подряд
+func (ht *healthTracker) coolDown(trips int) time.Duration {
+	coolDown := time.Duration(ht.options.CoolDownSeconds) * time.Second
+	maxCoolDown := time.Duration(ht.options.MaxCoolDownSeconds) * time.Second
+	for i := 1; i < trips && coolDown < maxCoolDown; i++ {
+		coolDown *= 2
+	}
+	if coolDown > maxCoolDown {
+		return maxCoolDown
+	}
+	return coolDown
+}
+
+func (ht *healthTracker) snapshot(codes []string) []ProviderHealth {
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+
+	result := make([]ProviderHealth, 0, len(codes))
+	for _, code := range codes {
+		result = append(result, *ht.get(code))
+	}
+	return result
+}
+
+func (state CircuitState) metricValue() int64 {
+	switch state {
+	case CircuitHalfOpen:
+		return 1
+	case CircuitOpen:
+		return 2
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,137 @@
+package opermanager
+
+import (
+	"errors"
+	"imgserver/internal/pkg/storage"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHealthTracker(t *testing.T, now *time.Time) *healthTracker {
+	ht := newHealthTracker()
+	ht.now = func() time.Time { return *now }
+	require.NoError(t, ht.setOptions(HealthOptions{FailureThreshold: 2, CoolDownSeconds: 10, MaxCoolDownSeconds: 30}))
+	return ht
+}
+
+func TestHealthTracker_OpensAfterThreshold(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	ht := newTestHealthTracker(t, &now)
+
+	_, tripped := ht.recordFailure("A", errors.New("boom"))
+	assert.False(t, tripped, "circuit must stay closed after first failure")
+	assert.Empty(t, ht.unavailableReason("A"))
+
+	health, tripped := ht.recordFailure("A", errors.New("boom"))
+	assert.True(t, tripped)
+	assert.Equal(t, CircuitOpen, health.State)
+	assert.Equal(t, "boom", health.LastError)
+	assert.Equal(t, now.Add(10*time.Second), health.OpenUntil)
+	assert.NotEmpty(t, ht.unavailableReason("A"))
+}
+
+func TestHealthTracker_HalfOpenProbe(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	ht := newTestHealthTracker(t, &now)
+	ht.recordFailure("A", errors.New("boom"))
+	ht.recordFailure("A", errors.New("boom"))
+
+	// После выключения провайдер допускается к одному пробному запросу
+	now = now.Add(11 * time.Second)
+	require.Empty(t, ht.unavailableReason("A"))
+	ht.acquire("A")
+	assert.NotEmpty(t, ht.unavailableReason("A"), "second probe must wait for the first one")
+
+	// Пробный запрос не прошёл - время выключения удваивается
+	health, tripped := ht.recordFailure("A", errors.New("still broken"))
+	assert.True(t, tripped)
+	assert.Equal(t, 2, health.Trips)
+	assert.Equal(t, now.Add(20*time.Second), health.OpenUntil)
+
+	// Верхняя граница
+	now = now.Add(21 * time.Second)
+	ht.acquire("A")
+	health, _ = ht.recordFailure("A", errors.New("still broken"))
+	assert.Equal(t, now.Add(30*time.Second), health.OpenUntil)
+
+	now = now.Add(31 * time.Second)
+	ht.acquire("A")
+	health = ht.recordSuccess("A")
+	assert.Equal(t, CircuitClosed, health.State)
+	assert.Zero(t, health.ConsecutiveFailures)
+	assert.Zero(t, health.Trips)
+	assert.Equal(t, now, health.LastSuccessTime)
+}
+
+func TestHealthTracker_LostProbe(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	ht := newTestHealthTracker(t, &now)
+	ht.recordFailure("A", errors.New("boom"))
+	ht.recordFailure("A", errors.New("boom"))
+
+	now = now.Add(11 * time.Second)
+	ht.acquire("A")
+
+	// Потерявшийся пробный запрос не блокирует провайдера навсегда
+	now = now.Add(11 * time.Second)
+	assert.Empty(t, ht.unavailableReason("A"))
+}
+
+func TestHealthTracker_InvalidOptions(t *testing.T) {
+	ht := newHealthTracker()
+
+	assert.Error(t, ht.setOptions(HealthOptions{CoolDownSeconds: 100, MaxCoolDownSeconds: 10}))
+}
+
+// pollErrorProvider первые pollErrors опросов завершаются сетевой ошибкой
+type pollErrorProvider struct {
+	imageFakeProvider
+	pollErrors int
+}
+
+func (pep *pollErrorProvider) GetImageSlice(id string) (bool, []byte, error) {
+	if pep.pollErrors > 0 {
+		pep.pollErrors--
+		return false, nil, errors.New("timeout")
+	}
+	return pep.imageFakeProvider.GetImageSlice(id)
+}
+
+func TestOperMngr_TransientPollErrorKeepsCircuitClosed(t *testing.T) {
+	t.Chdir(t.TempDir())
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	st, err := storage.NewStorage("test.db", logger)
+	require.NoError(t, err)
+	defer st.Close()
+
+	provider := &pollErrorProvider{
+		imageFakeProvider: imageFakeProvider{fakeProvider: fakeProvider{code: "Fake", ready: true}, image: newTestJpeg(t)},
+		pollErrors:        2,
+	}
+	op := newTestOperMngr(t, st, nil, provider)
+	require.NoError(t, op.SetHealthOptions(HealthOptions{FailureThreshold: 1, CoolDownSeconds: 60}))
+
+	id, err := op.StartOperation("ydart", "", "")
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = op.GetOperationStatus(id)
+		assert.Error(t, err)
+	}
+	health := op.GetProvidersHealth()
+	require.Len(t, health, 1)
+	assert.Equal(t, CircuitClosed, health[0].State)
+	assert.Zero(t, health[0].ConsecutiveFailures)
+
+	// Операция завершается при следующем опросе
+	_, err = op.GetOperationStatus(id)
+	require.NoError(t, err)
+	status, err := op.GetOperationStatus(id)
+	require.NoError(t, err)
+	assert.Equal(t, StatusDone, status.Status)
+	assert.Equal(t, CircuitClosed, op.GetProvidersHealth()[0].State)
+}
@@ -0,0 +1,346 @@
+package opermanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"imgserver/internal/pkg/storage"
+	"log/slog"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const HISTORY_BUCKET = "history"
+
+const (
+	HistoryModeRandom  = "random"  // как раньше, случайный файл
+	HistoryModeShuffle = "shuffle" // каждый файл показывается один раз за круг
+	HistoryModeWindow  = "window"  // не повторять последние window_size показанных файлов
+)
+
+const (
+	defaultHistoryWindowSize = 100
+	defaultFavoriteWeight    = 3
+)
+
+// HistoryOptions правила выбора сохранённых изображений, чтобы рамка не показывала одно и то же
+type HistoryOptions struct {
+	Mode       string `yaml:"mode"`
+	WindowSize int    `yaml:"window_size"`
+	// PreferLeastShown из подходящих файлов выбирать те, что показывались реже всего
+	PreferLeastShown bool `yaml:"prefer_least_shown"`
+	// FavoriteWeight во сколько раз чаще выбирать избранные изображения из подходящих
+	FavoriteWeight int `yaml:"favorite_weight"`
+}
+
+// shownImage история показа файла на рамке. Хранится в хранилище с ключом "<рамка>/<имя файла>"
+type shownImage struct {
+	Count     int       `json:"count"`
+	LastShown time.Time `json:"last_shown"`
+	// Cycle номер круга, в котором файл показывался последний раз (режим shuffle)
+	Cycle int `json:"cycle"`
+}
+
+type deviceHistory struct {
+	cycle  int
+	images map[string]*shownImage
+}
+
+type historyTracker struct {
+	options HistoryOptions
+	devices map[string]*deviceHistory
+	// favorites имена избранных файлов
+	favorites map[string]struct{}
+	storage   *storage.Storage
+	logger    *slog.Logger
+	now       func() time.Time
+	mutex     sync.Mutex
+}
+
+func newHistoryTracker(storage *storage.Storage, logger *slog.Logger) *historyTracker {
+	return &historyTracker{
+		options:   HistoryOptions{Mode: HistoryModeRandom, FavoriteWeight: defaultFavoriteWeight},
+		devices:   make(map[string]*deviceHistory),
+		favorites: make(map[string]struct{}),
+		storage:   storage,
+		logger:    logger,
+		now:       time.Now,
+	}
+}
+
+func (ht *historyTracker) setOptions(options HistoryOptions) error {
+	switch options.Mode {
+	case "":
+		options.Mode = HistoryModeRandom
+	case HistoryModeRandom, HistoryModeShuffle, HistoryModeWindow:
+	default:
+		return fmt.Errorf("unknown history mode: %s", options.Mode)
+	}
+	if options.WindowSize < 0 {
+		return fmt.Errorf("negative history window size: %d", options.WindowSize)
+	}
+	if options.WindowSize == 0 {
+		options.WindowSize = defaultHistoryWindowSize
+	}
+	if options.FavoriteWeight < 0 {
+		return fmt.Errorf("negative favorite weight: %d", options.FavoriteWeight)
+	}
+	if options.FavoriteWeight == 0 {
+		options.FavoriteWeight = defaultFavoriteWeight
+	}
+
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+	ht.options = options
+	return nil
+}
+
+// getDevice история рамки. При первом обращении загружается из хранилища
+func (ht *historyTracker) getDevice(deviceId string) *deviceHistory {
+	history, ok := ht.devices[deviceId]
+	if ok {
+		return history
+	}
+
+	history = &deviceHistory{images: make(map[string]*shownImage)}
+	ht.devices[deviceId] = history
+	if ht.storage == nil {
+		return history
+	}
+
+	prefix := deviceId + "/"
+	err := ht.storage.ForEach(HISTORY_BUCKET, func(key string, data []byte) error {
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		var image shownImage
+		if err := json.Unmarshal(data, &image); err != nil {
+			ht.logger.Warn("Skip broken history record", "key", key, "error", err)
+			return nil
+		}
+		history.images[strings.TrimPrefix(key, prefix)] = &image
+		// После рестарта продолжаем последний круг
+		if image.Cycle > history.cycle {
+			history.cycle = image.Cycle
+		}
+		return nil
+	})
+	if err != nil {
+		ht.logger.Error("Can not read show history", "device", deviceId, "error", err)
+	}
+	return history
+}
+
+// choose выбирает файл для показа на рамке. Пустая строка - выбирать не из чего
+func (ht *historyTracker) choose(deviceId string, files []string) string {
+	if len(files) == 0 {
+		return ""
+	}
+
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+
+	if ht.options.Mode == HistoryModeRandom && !ht.options.PreferLeastShown {
+		return ht.pick(files)
+	}
+
+	history := ht.getDevice(deviceId)
+	candidates := files
+	switch ht.options.Mode {
+	case HistoryModeShuffle:
+		candidates = ht.notShownInCycle(deviceId, history, files)
+	case HistoryModeWindow:
+		candidates = ht.outsideWindow(history, files)
+	}
+
+	if ht.options.PreferLeastShown {
+		candidates = leastShown(history, candidates)
+	}
+	return ht.pick(candidates)
+}
+
+// pick случайный файл с учётом веса избранных
+func (ht *historyTracker) pick(files []string) string {
+	weight := ht.options.FavoriteWeight
+	if weight == 1 || len(ht.favorites) == 0 {
+		return files[rand.Intn(len(files))]
+	}
+
+	total := 0
+	for _, file := range files {
+		total += ht.fileWeight(file)
+	}
+	point := rand.Intn(total)
+	for _, file := range files {
+		point -= ht.fileWeight(file)
+		if point < 0 {
+			return file
+		}
+	}
+	return files[len(files)-1]
+}
+
+func (ht *historyTracker) fileWeight(file string) int {
+	if _, ok := ht.favorites[filepath.Base(file)]; ok {
+		return ht.options.FavoriteWeight
+	}
+	return 1
+}
+
+// setFavorite отмечает файл избранным или снимает отметку
+func (ht *historyTracker) setFavorite(file string, favorite bool) {
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+
+	if favorite {
+		ht.favorites[filepath.Base(file)] = struct{}{}
+	} else {
+		delete(ht.favorites, filepath.Base(file))
+	}
+}
+
+// notShownInCycle файлы, ещё не показанные в текущем круге. Когда показаны все, начинается новый круг
+func (ht *historyTracker) notShownInCycle(deviceId string, history *deviceHistory, files []string) []string {
+	result := make([]string, 0, len(files))
+	for _, file := range files {
+		image, ok := history.images[filepath.Base(file)]
+		if !ok || image.Cycle != history.cycle {
+			result = append(result, file)
+		}
+	}
+	if len(result) > 0 {
+		return result
+	}
+
+	history.cycle++
+	ht.logger.Debug("Start new show cycle", "device", deviceId, "cycle", history.cycle, "files", len(files))
+	ht.pruneMissing(deviceId, history, files)
+
+	// Последний показанный файл не должен открыть новый круг
+	last := mostRecent(history, files)
+	for _, file := range files {
+		if file != last || len(files) == 1 {
+			result = append(result, file)
+		}
+	}
+	return result
+}
+
+// outsideWindow файлы, не входящие в последние window_size показанных
+func (ht *historyTracker) outsideWindow(history *deviceHistory, files []string) []string {
+	shown := make([]time.Time, 0, len(files))
+	for _, file := range files {
+		if image, ok := history.images[filepath.Base(file)]; ok {
+			shown = append(shown, image.LastShown)
+		}
+	}
+
+	window := ht.options.WindowSize
+	if window >= len(files) {
+		// Окно больше каталога. Исключаем всё, кроме самого давно показанного
+		window = len(files) - 1
+	}
+	if window <= 0 || len(shown) == 0 {
+		return files
+	}
+
+	sort.Slice(shown, func(i, j int) bool { return shown[i].After(shown[j]) })
+	if window > len(shown) {
+		window = len(shown)
+	}
+	cutoff := shown[window-1]
+
+	result := make([]string, 0, len(files))
+	for _, file := range files {
+		image, ok := history.images[filepath.Base(file)]
+		if !ok || image.LastShown.Before(cutoff) {
+			result = append(result, file)
+		}
+	}
+	if len(result) == 0 {
+		return files
+	}
+	return result
+}
+
+// recordShown отмечает показ файла на рамке
+func (ht *historyTracker) recordShown(deviceId string, file string) {
+	if file == "" {
+		return
+	}
+
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+
+	history := ht.getDevice(deviceId)
+	name := filepath.Base(file)
+	image, ok := history.images[name]
+	if !ok {
+		image = &shownImage{}
+		history.images[name] = image
+	}
+	image.Count++
+	image.LastShown = ht.now()
+	image.Cycle = history.cycle
+
+	if ht.storage == nil {
+		return
+	}
+	err := ht.storage.Put(HISTORY_BUCKET, deviceId+"/"+name, image)
+	if err != nil {
+		ht.logger.Error("Can not save show history", "device", deviceId, "file", name, "error", err)
+	}
+}
+
+// pruneMissing удаляет историю файлов, которых больше нет в каталоге
+func (ht *historyTracker) pruneMissing(deviceId string, history *deviceHistory, files []string) {
+	existing := make(map[string]struct{}, len(files))
+	for _, file := range files {
+		existing[filepath.Base(file)] = struct{}{}
+	}
+	for name := range history.images {
+		if _, ok := existing[name]; ok {
+			continue
+		}
+		delete(history.images, name)
+		if ht.storage != nil {
+			if err := ht.storage.Delete(HISTORY_BUCKET, deviceId+"/"+name); err != nil {
+				ht.logger.Error("Can not delete show history", "device", deviceId, "file", name, "error", err)
+			}
+		}
+	}
+}
+
+func leastShown(history *deviceHistory, files []string) []string {
+	minCount := -1
+	var result []string
+	for _, file := range files {
+		count := 0
+		if image, ok := history.images[filepath.Base(file)]; ok {
+			count = image.Count
+		}
+		if minCount < 0 || count < minCount {
+			minCount = count
+			result = result[:0]
+		}
+		if count == minCount {
+			result = append(result, file)
+		}
+	}
+	return result
+}
+
+func mostRecent(history *deviceHistory, files []string) string {
+	var result string
+	var last time.Time
+	for _, file := range files {
+		if image, ok := history.images[filepath.Base(file)]; ok && image.LastShown.After(last) {
+			last = image.LastShown
+			result = file
+		}
+	}
+	return result
+}
@@ -0,0 +1,121 @@
+package opermanager
+
+import (
+	"fmt"
+	"imgserver/internal/pkg/storage"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHistoryTracker(t *testing.T, st *storage.Storage, now *time.Time, options HistoryOptions) *historyTracker {
+	ht := newHistoryTracker(st, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	ht.now = func() time.Time {
+		*now = now.Add(time.Second)
+		return *now
+	}
+	require.NoError(t, ht.setOptions(options))
+	return ht
+}
+
+func newTestFiles(count int) []string {
+	files := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		files = append(files, filepath.Join("images", "original", fmt.Sprintf("f%d-orig.jpeg", i)))
+	}
+	return files
+}
+
+func show(ht *historyTracker, deviceId string, files []string) string {
+	file := ht.choose(deviceId, files)
+	ht.recordShown(deviceId, file)
+	return file
+}
+
+func TestHistoryTracker_Shuffle(t *testing.T) {
+	now := time.Date(2025, 1, 1, 10, 0, 0, 0, time.Local)
+	ht := newTestHistoryTracker(t, nil, &now, HistoryOptions{Mode: HistoryModeShuffle})
+	files := newTestFiles(10)
+
+	for cycle := 0; cycle < 3; cycle++ {
+		shown := make(map[string]struct{})
+		var last string
+		for i := 0; i < len(files); i++ {
+			file := show(ht, "kitchen", files)
+			assert.NotEqual(t, last, file)
+			shown[file] = struct{}{}
+			last = file
+		}
+		// За круг каждый файл показан ровно один раз
+		assert.Len(t, shown, len(files))
+	}
+}
+
+func TestHistoryTracker_WindowPersisted(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	st, err := storage.NewStorage(filepath.Join(t.TempDir(), "test.db"), logger)
+	require.NoError(t, err)
+	defer st.Close()
+
+	now := time.Date(2025, 1, 1, 10, 0, 0, 0, time.Local)
+	options := HistoryOptions{Mode: HistoryModeWindow, WindowSize: 3}
+	ht := newTestHistoryTracker(t, st, &now, options)
+	files := newTestFiles(5)
+
+	recent := []string{show(ht, "kitchen", files), show(ht, "kitchen", files), show(ht, "kitchen", files)}
+	assert.Len(t, map[string]string{recent[0]: "", recent[1]: "", recent[2]: ""}, 3)
+
+	// После рестарта последние показанные файлы по-прежнему исключены
+	restored := newTestHistoryTracker(t, st, &now, options)
+	for i := 0; i < 20; i++ {
+		assert.NotContains(t, recent, restored.choose("kitchen", files))
+	}
+	// У другой рамки своя история
+	assert.Empty(t, restored.getDevice("hall").images)
+}
+
+func TestHistoryTracker_PreferLeastShown(t *testing.T) {
+	now := time.Date(2025, 1, 1, 10, 0, 0, 0, time.Local)
+	ht := newTestHistoryTracker(t, nil, &now, HistoryOptions{PreferLeastShown: true})
+	files := newTestFiles(3)
+
+	ht.recordShown("", files[0])
+	ht.recordShown("", files[0])
+	ht.recordShown("", files[1])
+	assert.Equal(t, files[2], ht.choose("", files))
+
+	ht.recordShown("", files[2])
+	assert.Equal(t, files[1], ht.choose("", files[:2]))
+}
+
+func TestHistoryTracker_FavoriteWeight(t *testing.T) {
+	now := time.Date(2025, 1, 1, 10, 0, 0, 0, time.Local)
+	ht := newTestHistoryTracker(t, nil, &now, HistoryOptions{FavoriteWeight: 1000})
+	files := newTestFiles(2)
+	ht.setFavorite(files[1], true)
+
+	favorites := 0
+	for i := 0; i < 50; i++ {
+		if ht.choose("", files) == files[1] {
+			favorites++
+		}
+	}
+	assert.Greater(t, favorites, 40)
+
+	// Снятая отметка больше не влияет на выбор
+	ht.setFavorite(files[1], false)
+	assert.Empty(t, ht.favorites)
+}
+
+func TestHistoryTracker_InvalidOptions(t *testing.T) {
+	ht := newHistoryTracker(nil, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	assert.Error(t, ht.setOptions(HistoryOptions{Mode: "unknown"}))
+	assert.Error(t, ht.setOptions(HistoryOptions{Mode: HistoryModeWindow, WindowSize: -1}))
+	assert.Error(t, ht.setOptions(HistoryOptions{FavoriteWeight: -1}))
+	assert.Empty(t, ht.choose("", nil))
+}
@@ -10,12 +10,29 @@ type ProviderProperties struct {
 	IsNeedSaveLocalFiles bool
 }
 
+// GenerationInfo параметры, с которыми провайдер запустил генерацию. Позволяют повторить понравившееся изображение
+type GenerationInfo struct {
+	Prompt   string `json:"prompt,omitempty"`
+	Negative string `json:"negative,omitempty"`
+	Model    string `json:"model,omitempty"`
+	Seed     *int64 `json:"seed,omitempty"`
+	// PromptIdx номер промпта в файле промптов. 0 - промпт передан в запросе
+	PromptIdx int `json:"prompt_idx,omitempty"`
+}
+
+// GenerationInfoProvider необязательный интерфейс провайдера, который умеет рассказать о параметрах генерации
+type GenerationInfoProvider interface {
+	// GetGenerationInfo возвращает параметры по внешнему идентификатору операции или nil
+	GetGenerationInfo(externalId string) *GenerationInfo
+}
+
 type ImageProvider interface {
 	Start() error
 	GetImageProviderForImageServerName() string
 	GetImageProviderCode() string
 	Generate(isDirectCall bool) (string, error)
-	GenerateWithPrompt(prompt string, isDirectCall bool) (string, error)
+	// GenerateWithPrompt negative - негативная часть промпта, может быть пустой
+	GenerateWithPrompt(prompt string, negative string, isDirectCall bool) (string, error)
 	// GetImageSlice Возвращаёт бинарный массив в формате JPEG
 	GetImageSlice(operationId string) (bool, []byte, error)
 	IsReadyForRequest() bool
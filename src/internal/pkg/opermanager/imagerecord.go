@@ -0,0 +1,225 @@
+package opermanager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"imgserver/internal/pkg/imageprocessor"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const IMAGES_BUCKET = "images"
+
+const (
+	ImageSourceGenerated = "generated" // получено от провайдера
+	ImageSourceLegacy    = "legacy"    // файл был в каталоге до появления индекса
+	ImageSourceUploaded  = "uploaded"  // загружено пользователем
+)
+
+var ErrImageNotFound = errors.New("image not found")
+
+// ImageMetadata сведения о сохранённом оригинале изображения. Ключ в хранилище - имя файла
+type ImageMetadata struct {
+	FileName     string          `json:"file_name"`
+	Source       string          `json:"source"`
+	OperationId  string          `json:"operation_id,omitempty"`
+	ProviderCode string          `json:"provider_code,omitempty"`
+	Info         *GenerationInfo `json:"info,omitempty"`
+	Width        int             `json:"width"`
+	Height       int             `json:"height"`
+	Size         int64           `json:"size"`
+	// Hash перцептивный хэш (dHash) в шестнадцатеричном виде. Помогает искать похожие изображения
+	Hash         string `json:"hash,omitempty"`
+	DisplayCount int    `json:"display_count"`
+	// Favorite избранное изображение чаще выбирается для показа
+	Favorite bool `json:"favorite,omitempty"`
+	// Pinned закреплённое изображение не удаляется очисткой каталога
+	Pinned    bool      `json:"pinned,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// imageIndex поддерживает метаданные в актуальном состоянии при чтении и очистке каталога оригиналов
+type imageIndex struct {
+	op *OperMngr
+	// mutex не даёт перестроению индекса и обновлению записей затереть друг друга
+	mutex sync.Mutex
+}
+
+func (op *OperMngr) saveImageRecord(operation *Operation, originalFileName string, imageData []byte) {
+	if op.storage == nil {
+		return
+	}
+
+	record := ImageMetadata{
+		FileName:    filepath.Base(originalFileName),
+		Source:      ImageSourceGenerated,
+		OperationId: operation.Id,
+		Info:        operation.Info,
+		Size:        int64(len(imageData)),
+		CreatedAt:   time.Now(),
+	}
+	if operation.Provider != nil {
+		record.ProviderCode = (*operation.Provider).GetImageProviderCode()
+	}
+	op.describeImage(&record, imageData)
+
+	op.index.mutex.Lock()
+	defer op.index.mutex.Unlock()
+	op.putImageRecord(&record)
+}
+
+func (op *OperMngr) describeImage(record *ImageMetadata, imageData []byte) {
+	width, height, hash, err := imageprocessor.DescribeImage(imageData)
+	if err != nil {
+		op.logger.Warn("Can not describe image", "file", record.FileName, "error", err)
+		return
+	}
+	record.Width = width
+	record.Height = height
+	record.Hash = fmt.Sprintf("%016x", hash)
+}
+
+func (op *OperMngr) putImageRecord(record *ImageMetadata) {
+	err := op.storage.Put(IMAGES_BUCKET, record.FileName, record)
+	if err != nil {
+		op.logger.Error("Can not save image record", "file", record.FileName, "error", err)
+	}
+}
+
+// GetImageMetadata метаданные оригинала по имени файла
+func (op *OperMngr) GetImageMetadata(fileName string) (*ImageMetadata, error) {
+	if op.storage == nil {
+		return nil, ErrImageNotFound
+	}
+	var record ImageMetadata
+	found, err := op.storage.Get(IMAGES_BUCKET, filepath.Base(fileName), &record)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrImageNotFound, fileName)
+	}
+	return &record, nil
+}
+
+// incrementDisplayCount учитывает показ оригинала на рамке
+func (op *OperMngr) incrementDisplayCount(fileName string) {
+	if op.storage == nil || fileName == "" {
+		return
+	}
+	op.index.mutex.Lock()
+	defer op.index.mutex.Unlock()
+
+	record, err := op.GetImageMetadata(fileName)
+	if err != nil {
+		op.logger.Debug("Image record not found", "file", fileName, "error", err)
+		return
+	}
+	record.DisplayCount++
+	op.putImageRecord(record)
+}
+
+// FilesRead добавляет в индекс файлы, сохранённые до его появления, и удаляет записи пропавших файлов.
+// Восстанавливает отметки избранных и закреплённых файлов
+func (ii *imageIndex) FilesRead(files []string) {
+	op := ii.op
+	if op.storage == nil {
+		return
+	}
+
+	ii.mutex.Lock()
+	defer ii.mutex.Unlock()
+
+	indexed := make(map[string]ImageMetadata)
+	err := op.storage.ForEach(IMAGES_BUCKET, func(key string, data []byte) error {
+		var record ImageMetadata
+		if err := json.Unmarshal(data, &record); err != nil {
+			op.logger.Warn("Skip broken image record", "file", key, "error", err)
+			return nil
+		}
+		indexed[key] = record
+		return nil
+	})
+	if err != nil {
+		op.logger.Error("Can not read image index", "error", err)
+		return
+	}
+
+	added := 0
+	for _, file := range files {
+		name := filepath.Base(file)
+		if record, ok := indexed[name]; ok {
+			delete(indexed, name)
+			op.applyImageFlags(file, &record)
+			continue
+		}
+		if ii.indexLegacyFile(file) {
+			added++
+		}
+	}
+
+	removed := 0
+	for name := range indexed {
+		// Файл мог появиться уже после чтения каталога
+		if _, err := os.Stat(filepath.Join(op.dirManager.GetDirectoryPath(), name)); err == nil {
+			continue
+		}
+		if err := op.storage.Delete(IMAGES_BUCKET, name); err != nil {
+			op.logger.Error("Can not delete image record", "file", name, "error", err)
+			continue
+		}
+		removed++
+	}
+	op.logger.Info("Image index updated", "files", len(files), "added", added, "removed", removed)
+}
+
+func (ii *imageIndex) indexLegacyFile(file string) bool {
+	op := ii.op
+	info, err := os.Stat(file)
+	if err != nil {
+		op.logger.Warn("Can not index image", "file", file, "error", err)
+		return false
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		op.logger.Warn("Can not index image", "file", file, "error", err)
+		return false
+	}
+
+	record := ImageMetadata{
+		FileName:  filepath.Base(file),
+		Source:    ImageSourceLegacy,
+		Size:      info.Size(),
+		CreatedAt: info.ModTime(),
+	}
+	op.describeImage(&record, data)
+	op.putImageRecord(&record)
+	return true
+}
+
+// FilesRemoved удаляет записи файлов, удалённых очисткой каталога
+func (ii *imageIndex) FilesRemoved(files []string) {
+	op := ii.op
+	if op.storage == nil {
+		return
+	}
+
+	ii.mutex.Lock()
+	defer ii.mutex.Unlock()
+	for _, file := range files {
+		name := filepath.Base(file)
+		op.history.setFavorite(name, false)
+		if err := op.storage.Delete(IMAGES_BUCKET, name); err != nil {
+			op.logger.Error("Can not delete image record", "file", name, "error", err)
+		}
+	}
+}
+
+// applyImageFlags передаёт отметки изображения в каталог и в выбор изображений для показа
+func (op *OperMngr) applyImageFlags(file string, record *ImageMetadata) {
+	op.dirManager.SetPinned(file, record.Pinned)
+	op.history.setFavorite(file, record.Favorite)
+}
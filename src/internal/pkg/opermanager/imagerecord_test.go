@@ -0,0 +1,87 @@
+package opermanager
+
+import (
+	"imgserver/internal/pkg/storage"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageIndex_GeneratedAndShown(t *testing.T) {
+	t.Chdir(t.TempDir())
+	st, err := storage.NewStorage("test.db", slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	require.NoError(t, err)
+	defer st.Close()
+
+	provider := &imageFakeProvider{fakeProvider: fakeProvider{code: "Fake", ready: true}, image: newTestJpeg(t), withPrompt: true, saveLocal: true}
+	op := newTestOperMngr(t, st, nil, provider)
+
+	id, err := op.StartOperation("ydart", "red fox", "blur")
+	require.NoError(t, err)
+	op.CheckPendingOperations()
+
+	files := op.dirManager.GetFiles()
+	require.Len(t, files, 1)
+	metadata, err := op.GetImageMetadata(filepath.Base(files[0]))
+	require.NoError(t, err)
+	assert.Equal(t, ImageSourceGenerated, metadata.Source)
+	assert.Equal(t, id, metadata.OperationId)
+	assert.Equal(t, "Fake", metadata.ProviderCode)
+	require.NotNil(t, metadata.Info)
+	assert.Equal(t, "red fox", metadata.Info.Prompt)
+	assert.Equal(t, "blur", metadata.Info.Negative)
+	assert.Equal(t, 80, metadata.Width)
+	assert.Equal(t, 60, metadata.Height)
+	assert.Equal(t, int64(len(provider.image)), metadata.Size)
+	assert.Len(t, metadata.Hash, 16)
+	// Свежее изображение показывается рамке сразу
+	assert.Equal(t, 1, metadata.DisplayCount)
+
+	_, err = op.StartOperation("old", "", "")
+	require.NoError(t, err)
+	metadata, err = op.GetImageMetadata(filepath.Base(files[0]))
+	require.NoError(t, err)
+	assert.Equal(t, 2, metadata.DisplayCount)
+
+	_, err = op.GetImageMetadata("unknown.jpeg")
+	assert.ErrorIs(t, err, ErrImageNotFound)
+}
+
+func TestImageIndex_RebuildLegacy(t *testing.T) {
+	t.Chdir(t.TempDir())
+	st, err := storage.NewStorage("test.db", slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	require.NoError(t, err)
+	defer st.Close()
+
+	provider := &imageFakeProvider{fakeProvider: fakeProvider{code: "Fake", ready: true}, image: newTestJpeg(t)}
+	op := newTestOperMngr(t, st, nil, provider)
+
+	legacy := filepath.Join("images", "f1700000000-orig.jpeg")
+	require.NoError(t, os.WriteFile(legacy, provider.image, 0644))
+	require.NoError(t, op.dirManager.ReadFiles())
+
+	metadata, err := op.GetImageMetadata("f1700000000-orig.jpeg")
+	require.NoError(t, err)
+	assert.Equal(t, ImageSourceLegacy, metadata.Source)
+	assert.Equal(t, 80, metadata.Width)
+	assert.NotEmpty(t, metadata.Hash)
+	assert.False(t, metadata.CreatedAt.IsZero())
+
+	// Испорченная запись строится заново
+	require.NoError(t, st.Put(IMAGES_BUCKET, "f1700000000-orig.jpeg", "broken"))
+	require.NoError(t, op.dirManager.ReadFiles())
+	metadata, err = op.GetImageMetadata("f1700000000-orig.jpeg")
+	require.NoError(t, err)
+	assert.Equal(t, ImageSourceLegacy, metadata.Source)
+	assert.Equal(t, 80, metadata.Width)
+
+	// Пропавший файл удаляется из индекса при следующем чтении каталога
+	require.NoError(t, os.Remove(legacy))
+	require.NoError(t, op.dirManager.ReadFiles())
+	_, err = op.GetImageMetadata("f1700000000-orig.jpeg")
+	assert.ErrorIs(t, err, ErrImageNotFound)
+}
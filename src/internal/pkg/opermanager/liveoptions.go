@@ -0,0 +1,120 @@
+package opermanager
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LiveOptions настройки сервера, которые применяются без перезапуска
+type LiveOptions struct {
+	ThresholdMinutes int
+	SleepTimes       []*SleepTime
+	// DisabledProviders коды провайдеров (GetImageProviderCode), которые не выбираются для новых операций
+	DisabledProviders []string
+	Selection         SelectionOptions
+	Health            HealthOptions
+	Budgets           map[string]BudgetOptions
+	Prefetch          PrefetchOptions
+	History           HistoryOptions
+	// Devices настройки рамок по идентификаторам. Рамки добавляются и удаляются только перезапуском
+	Devices map[string]DeviceLiveOptions
+}
+
+// DeviceLiveOptions настройки рамки, которые применяются без перезапуска. Незаданные значения - как у сервера
+type DeviceLiveOptions struct {
+	ThresholdMinutes int
+	SleepTimes       []*SleepTime
+	Providers        []string
+}
+
+// ApplyLiveOptions проверяет настройки целиком и только затем применяет. При ошибке ничего не меняется.
+// Операции, которые уже начались, доделываются по старым настройкам
+func (op *OperMngr) ApplyLiveOptions(options LiveOptions) error {
+	if err := op.checkLiveOptions(options); err != nil {
+		return err
+	}
+
+	op.settingsMutex.Lock()
+	op.thresholdMinutes = options.ThresholdMinutes
+	op.sleepTimes = options.SleepTimes
+	op.disabledProviders = options.DisabledProviders
+	op.defaultDevice.actioner.SetThreshold(options.ThresholdMinutes, time.Minute)
+	op.defaultDevice.sleepTimes = options.SleepTimes
+	for id, dev := range op.devices {
+		if deviceOptions, ok := options.Devices[id]; ok {
+			dev.parameters.ThresholdMinutes = deviceOptions.ThresholdMinutes
+			dev.parameters.SleepTimes = deviceOptions.SleepTimes
+			dev.parameters.Providers = deviceOptions.Providers
+		}
+		op.resolveDeviceSettings(dev)
+	}
+	op.settingsMutex.Unlock()
+
+	// Ошибок здесь уже не будет: те же настройки прошли проверку
+	_ = op.selector.setOptions(options.Selection)
+	_ = op.health.setOptions(options.Health)
+	_ = op.budget.setOptions(options.Budgets)
+	_ = op.SetPrefetchOptions(options.Prefetch)
+	_ = op.history.setOptions(options.History)
+
+	op.logger.Info("Live options applied", "threshold", options.ThresholdMinutes, "sleep_times", len(options.SleepTimes),
+		"disabled_providers", options.DisabledProviders, "devices", len(options.Devices))
+	return nil
+}
+
+// checkLiveOptions собирает все ошибки настроек, чтобы их можно было исправить за один раз
+func (op *OperMngr) checkLiveOptions(options LiveOptions) error {
+	var errs []error
+
+	errs = append(errs, checkSleepTimes(options.SleepTimes, "")...)
+	for id, deviceOptions := range options.Devices {
+		if _, ok := op.devices[id]; !ok {
+			op.logger.Warn("New device is added only after restart", "device", id)
+			continue
+		}
+		errs = append(errs, checkSleepTimes(deviceOptions.SleepTimes, id)...)
+		for _, code := range deviceOptions.Providers {
+			if op.findImageProvider(code) == nil {
+				errs = append(errs, fmt.Errorf("device %s: unknown provider %s", id, code))
+			}
+		}
+	}
+
+	// Проверяем на отдельных экземплярах, чтобы не задеть действующие настройки
+	if err := newProviderSelector().setOptions(options.Selection); err != nil {
+		errs = append(errs, err)
+	}
+	if err := newHealthTracker().setOptions(options.Health); err != nil {
+		errs = append(errs, err)
+	}
+	if err := newBudgetTracker(nil, op.logger).setOptions(options.Budgets); err != nil {
+		errs = append(errs, err)
+	}
+	if options.Prefetch.QueueSize < 0 {
+		errs = append(errs, fmt.Errorf("negative prefetch queue size: %d", options.Prefetch.QueueSize))
+	}
+	if err := newHistoryTracker(nil, op.logger).setOptions(options.History); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func checkSleepTimes(sleepTimes []*SleepTime, deviceId string) []error {
+	var errs []error
+	for _, st := range sleepTimes {
+		var err error
+		if st == nil || st.TimeRange == nil {
+			err = errors.New("sleep time without time_range")
+		} else {
+			_, err = st.TimeRange.IsWithinRangeInclusive(time.Now())
+		}
+		if err != nil && deviceId != "" {
+			err = fmt.Errorf("device %s: %w", deviceId, err)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
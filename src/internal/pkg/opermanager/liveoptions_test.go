@@ -0,0 +1,67 @@
+package opermanager
+
+import (
+	"imgserver/internal/pkg/imageprocessor"
+	"imgserver/internal/pkg/timerange"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLiveOptions_Apply(t *testing.T) {
+	t.Chdir(t.TempDir())
+	// Коды встроенных провайдеров ydArt и openai. Ключи disabled_providers переводит в коды приложение
+	ydArt := &imageFakeProvider{fakeProvider: fakeProvider{code: "YandexArt", ready: true}, image: newTestJpeg(t)}
+	openAi := &imageFakeProvider{fakeProvider: fakeProvider{code: "OpenAi", ready: true}, image: newTestJpeg(t)}
+	op := newTestOperMngr(t, nil, func(op *OperMngr) {
+		require.NoError(t, op.AddDevice("kitchen", DeviceParameters{
+			ImageParameters: imageprocessor.ImageParameters{ImageWeight: 20, ImageHeight: 10},
+		}))
+	}, ydArt, openAi)
+	kitchen, err := op.getDevice("kitchen")
+	require.NoError(t, err)
+
+	// Весь день - период сна, openai выключен, рамке разрешён только ydArt
+	allDay := []*SleepTime{{TimeRange: &timerange.TimeRange{Start: "00:00", End: "23:59:59"}, BlackImageMode: true}}
+	err = op.ApplyLiveOptions(LiveOptions{
+		ThresholdMinutes:  30,
+		SleepTimes:        allDay,
+		DisabledProviders: []string{"OpenAi"},
+		Devices:           map[string]DeviceLiveOptions{"kitchen": {Providers: []string{"YandexArt"}}},
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	assert.NotNil(t, op.checkSleepTime(op.defaultDevice, now))
+	// Рамка без своих периодов сна берёт их у сервера
+	assert.NotNil(t, op.checkSleepTime(kitchen, now))
+	providers := op.deviceProviders(op.defaultDevice, op.imageProviders)
+	require.Len(t, providers, 1)
+	assert.Equal(t, "YandexArt", (*providers[0]).GetImageProviderCode())
+
+	kitchen.actioner.SetLastCallTime(now.Add(-20 * time.Minute))
+	assert.False(t, kitchen.actioner.ThresholdOut(now))
+	kitchen.actioner.SetLastCallTime(now.Add(-40 * time.Minute))
+	assert.True(t, kitchen.actioner.ThresholdOut(now))
+
+	// Настройки с ошибками отклоняются целиком
+	err = op.ApplyLiveOptions(LiveOptions{
+		SleepTimes: []*SleepTime{{TimeRange: &timerange.TimeRange{Start: "25:00", End: "26:00"}}},
+		Selection:  SelectionOptions{Policy: "unknown"},
+		Devices:    map[string]DeviceLiveOptions{"kitchen": {Providers: []string{"Unknown"}}},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown provider selection policy")
+	assert.Contains(t, err.Error(), "device kitchen: unknown provider Unknown")
+	assert.NotNil(t, op.checkSleepTime(op.defaultDevice, now))
+	assert.Len(t, op.deviceProviders(op.defaultDevice, op.imageProviders), 1)
+
+	// Без периодов сна и выключенных провайдеров
+	require.NoError(t, op.ApplyLiveOptions(LiveOptions{}))
+	assert.Nil(t, op.checkSleepTime(kitchen, now))
+	assert.Len(t, op.deviceProviders(op.defaultDevice, op.imageProviders), 2)
+	// Рамка, которой нет в настройках, работает по прежним настройкам до перезапуска
+	assert.Len(t, op.deviceProviders(kitchen, op.imageProviders), 1)
+}
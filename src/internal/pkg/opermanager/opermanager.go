@@ -8,13 +8,15 @@ import (
 	"imgserver/internal/pkg/dirmanager"
 	"imgserver/internal/pkg/imageprocessor"
 	"imgserver/internal/pkg/metrics"
+	"imgserver/internal/pkg/storage"
 	"imgserver/internal/pkg/timerange"
+	"imgserver/internal/pkg/utils"
 	"log/slog"
 	"math/rand"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/patrickmn/go-cache"
@@ -31,6 +33,7 @@ const (
 
 const BLACK_FILE_NAME = "black.jpeg"
 const TEMPORARY_IMAGE_DIR = "tmp_images"
+const OPERATION_TTL = 1 * time.Hour
 
 type Status string
 
@@ -60,15 +63,28 @@ type OperMngr struct {
 	imageProviders           []*ImageProvider
 	imageProvidersWithPrompt []*ImageProvider
 	//ydArt              *ydart.YdArt
-	dirManager      *dirmanager.DirManager
-	dirManagerTemp  *dirmanager.DirManager
-	idMutex         *IdMutex
-	actioner        *actioner.Actioner
-	sleepTimes      []*SleepTime
-	imageParameters ImageParameters
+	dirManager     *dirmanager.DirManager
+	dirManagerTemp *dirmanager.DirManager
+	idMutex        *IdMutex
+	sleepTimes     []*SleepTime
+	// thresholdMinutes порог обращения к провайдеру по умолчанию для рамок
+	thresholdMinutes int
+	// disabledProviders провайдеры, выключенные при перечитывании настроек
+	disabledProviders []string
+	// settingsMutex защищает настройки, которые меняются при перечитывании настроек
+	settingsMutex sync.RWMutex
+	defaultDevice *device
+	devices       map[string]*device
 	//TODO create
-	ipr     *imageprocessor.Ipr
-	metrics *metrics.AppMetrics
+	metrics  *metrics.AppMetrics
+	storage  *storage.Storage
+	selector *providerSelector
+	health   *healthTracker
+	budget   *budgetTracker
+	prefetch *prefetchQueue
+	history  *historyTracker
+	index    *imageIndex
+	showNext *showNextQueue
 }
 type OperStatus struct {
 	Status Status
@@ -81,7 +97,14 @@ type Operation struct {
 	ExternalId string
 	FileName   string
 	Type       generatorType
-	status     *OperStatus
+	Info       *GenerationInfo
+	// Prefetch фоновая генерация для очереди свежих изображений
+	Prefetch bool
+	// DeviceId рамка, для которой готовится изображение. Пусто - рамка по умолчанию
+	DeviceId  string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	status    *OperStatus
 }
 
 func NewOperMngr(thresholdMinutes int,
@@ -89,9 +112,10 @@ func NewOperMngr(thresholdMinutes int,
 	sleepTimes []*SleepTime,
 	dirManager *dirmanager.DirManager,
 	metrics *metrics.AppMetrics,
+	storage *storage.Storage,
 	logger *slog.Logger) (*OperMngr, error) {
-	pendingOperations := cache.New(1*time.Hour, 2*time.Hour)
-	completeOperations := cache.New(1*time.Hour, 2*time.Hour)
+	pendingOperations := cache.New(OPERATION_TTL, 2*OPERATION_TTL)
+	completeOperations := cache.New(OPERATION_TTL, 2*OPERATION_TTL)
 
 	dirManagerTemp, err := dirmanager.NewDirManager(TEMPORARY_IMAGE_DIR, 5, 10, logger)
 
@@ -106,12 +130,42 @@ func NewOperMngr(thresholdMinutes int,
 		dirManagerTemp:     dirManagerTemp,
 		logger:             logger,
 		idMutex:            NewIdMutex(),
-		actioner:           actioner.NewActioner(thresholdMinutes, time.Minute),
 		sleepTimes:         sleepTimes,
-		imageParameters:    ImageParameters{Height: imageParameters.ImageHeight, Weight: imageParameters.ImageWeight},
-		metrics:            metrics,
-		ipr:                imageprocessor.NewIpr(imageParameters, logger),
+		thresholdMinutes:   thresholdMinutes,
+		defaultDevice: &device{
+			imageParameters: ImageParameters{Height: imageParameters.ImageHeight, Weight: imageParameters.ImageWeight},
+			ipr:             imageprocessor.NewIpr(imageParameters, logger),
+			actioner:        actioner.NewActioner(thresholdMinutes, time.Minute),
+			sleepTimes:      sleepTimes,
+			blackFileName:   BLACK_FILE_NAME,
+		},
+		devices:  make(map[string]*device),
+		metrics:  metrics,
+		storage:  storage,
+		selector: newProviderSelector(),
+		health:   newHealthTracker(),
+		budget:   newBudgetTracker(storage, logger),
+		prefetch: newPrefetchQueue(),
+		history:  newHistoryTracker(storage, logger),
+		showNext: newShowNextQueue(),
+	}
+
+	// Вместе с кэшем из хранилища удаляются устаревшие операции.
+	// Незавершённая операция удаляется из кэша и при переходе в завершённые, её трогать нельзя
+	pendingOperations.OnEvicted(func(id string, _ interface{}) {
+		if _, ok := completeOperations.Get(id); !ok {
+			operMng.deleteOperation(id)
+		}
+	})
+	completeOperations.OnEvicted(func(id string, _ interface{}) {
+		operMng.deleteOperation(id)
+	})
+
+	operMng.index = &imageIndex{op: &operMng}
+	if dirManager != nil {
+		dirManager.SetListener(operMng.index)
 	}
+
 	return &operMng, nil
 }
 
@@ -126,10 +180,29 @@ func (op *OperMngr) Start() error {
 	if len(op.imageProviders) == 0 {
 		return fmt.Errorf("no image providers found")
 	}
-	err := op.createBlackJPEGSafe(op.imageParameters.Weight, op.imageParameters.Height, BLACK_FILE_NAME)
+
+	// Код провайдера сохраняется вместе с операцией, поэтому он должен быть уникальным
+	codes := make(map[string]struct{}, len(op.imageProviders))
+	for _, provider := range op.imageProviders {
+		code := (*provider).GetImageProviderCode()
+		if _, exists := codes[code]; exists {
+			return fmt.Errorf("duplicate image provider code: %s", code)
+		}
+		codes[code] = struct{}{}
+	}
+	err := op.createBlackJPEGSafe(op.defaultDevice.imageParameters.Weight, op.defaultDevice.imageParameters.Height, BLACK_FILE_NAME)
 	if err != nil {
 		return err
 	}
+	err = op.startDevices()
+	if err != nil {
+		return err
+	}
+	err = op.restoreShowNextQueues()
+	if err != nil {
+		op.logger.Error("Error restore show next queues", "error", err)
+		return fmt.Errorf("error restore show next queues: %v", err)
+	}
 
 	for _, st := range op.sleepTimes {
 		_, err = st.TimeRange.IsWithinRangeInclusive(time.Now())
@@ -153,165 +226,314 @@ func (op *OperMngr) Start() error {
 		}
 	}
 
+	err = os.MkdirAll(PREFETCH_IMAGE_DIR, 0755)
+	if err != nil {
+		return fmt.Errorf("error create prefetch directory: %v", err)
+	}
+	err = op.restorePrefetchQueue()
+	if err != nil {
+		op.logger.Error("Error restore prefetch queue", "error", err)
+		return fmt.Errorf("error restore prefetch queue: %v", err)
+	}
+
+	pending, err := op.restoreOperations()
+	if err != nil {
+		op.logger.Error("Error restore operations", "error", err)
+		return fmt.Errorf("error restore operations: %v", err)
+	}
+	if pending > 0 {
+		// Не дожидаемся планировщика: провайдер мог закончить работу, пока сервер был остановлен
+		go op.CheckPendingOperations()
+	}
+	go op.RefillPrefetchQueue()
+
 	return nil
 }
 
-func (op *OperMngr) StartOperation(optype string, prompt string) (string, error) {
+func (op *OperMngr) findImageProvider(code string) *ImageProvider {
+	for _, provider := range op.imageProviders {
+		if (*provider).GetImageProviderCode() == code {
+			return provider
+		}
+	}
+	return nil
+}
+
+func (op *OperMngr) StartOperation(optype string, prompt string, negative string) (string, error) {
+	return op.StartDeviceOperation("", optype, prompt, negative)
+}
+
+// StartDeviceOperation запускает операцию для рамки. Пустой deviceId - рамка по умолчанию
+func (op *OperMngr) StartDeviceOperation(deviceId string, optype string, prompt string, negative string) (string, error) {
 	//op.metrics.TotalRequests.Inc(1)
+	dev, err := op.getDevice(deviceId)
+	if err != nil {
+		return "", err
+	}
+
 	if optype == "ydart" {
-		op.logger.Info("Start direct provider operation")
-		provider := op.getImageProvider(len(prompt) > 0)
-		return op.startProviderOperation(provider, prompt, true)
+		op.logger.Info("Start direct provider operation", "device", dev.id)
+		provider := op.getImageProvider(dev, len(prompt) > 0)
+		if provider == nil {
+			return "", fmt.Errorf("no provider allowed for device %s", dev.id)
+		}
+		return op.startProviderOperation(dev, provider, prompt, negative, true, false)
 	} else if optype == "old" {
-		return op.startOldPictureOperation()
+		return op.startOldPictureOperation(dev)
 	}
-	return op.startAutoOperation()
+	return op.startAutoOperation(dev)
 
 }
 
-func (op *OperMngr) getImageProvider(withPrompt bool) *ImageProvider {
+func (op *OperMngr) getImageProvider(dev *device, withPrompt bool) *ImageProvider {
+	providers := op.imageProviders
 	if withPrompt {
-		if len(op.imageProvidersWithPrompt) == 1 {
-			op.logger.Debug("Get provider", "provider", (*op.imageProvidersWithPrompt[0]).GetImageProviderForImageServerName())
-			return op.imageProvidersWithPrompt[0]
+		providers = op.imageProvidersWithPrompt
+	}
+	providers = op.deviceProviders(dev, providers)
+	if len(providers) == 0 {
+		return nil
+	}
+
+	// Предпочитаем провайдеров, у которых остался бюджет. Если бюджет исчерпан у всех,
+	// запрос получит ошибку ErrBudgetExhausted
+	var withBudget []*ImageProvider
+	for _, provider := range providers {
+		if op.budget.exhaustedReason((*provider).GetImageProviderCode()) == "" {
+			withBudget = append(withBudget, provider)
 		}
+	}
+	if len(withBudget) > 0 {
+		providers = withBudget
+	}
 
-		idx := rand.Intn(len(op.imageProvidersWithPrompt))
-		op.logger.Debug("Get provider", "provider", (*op.imageProvidersWithPrompt[idx]).GetImageProviderForImageServerName())
+	if len(providers) == 1 {
+		op.logger.Debug("Get provider", "provider", (*providers[0]).GetImageProviderForImageServerName())
+		return providers[0]
+	}
 
-		return op.imageProvidersWithPrompt[idx]
-	} else {
+	idx := rand.Intn(len(providers))
+	op.logger.Debug("Get provider", "provider", (*providers[idx]).GetImageProviderForImageServerName())
 
-		if len(op.imageProviders) == 1 {
-			op.logger.Debug("Get provider", "provider", (*op.imageProviders[0]).GetImageProviderForImageServerName())
-			return op.imageProviders[0]
-		}
+	return providers[idx]
+}
 
-		idx := rand.Intn(len(op.imageProviders))
-		op.logger.Debug("Get provider", "provider", (*op.imageProviders[idx]).GetImageProviderForImageServerName())
+// SetBudgetOptions задаёт бюджеты провайдеров по их кодам
+func (op *OperMngr) SetBudgetOptions(options map[string]BudgetOptions) error {
+	return op.budget.setOptions(options)
+}
 
-		return op.imageProviders[idx]
-	}
+// SetHistoryOptions задаёт правила выбора сохранённых изображений без повторов
+func (op *OperMngr) SetHistoryOptions(options HistoryOptions) error {
+	return op.history.setOptions(options)
+}
 
+// SetSelectionOptions задаёт политику выбора провайдера для автоматических запросов
+func (op *OperMngr) SetSelectionOptions(options SelectionOptions) error {
+	return op.selector.setOptions(options)
 }
 
-func (op *OperMngr) chooseImageProvider() *ImageProvider {
-	// Перебираем провайдеров которые могут принять задание
-	var readyProviders []int
-	for idx, pr := range op.imageProviders {
-		if (*pr).IsReadyForRequest() {
-			readyProviders = append(readyProviders, idx)
-		}
+// GetLastSelectionDecision возвращает последнее решение о выборе провайдера (nil, если выбора ещё не было)
+func (op *OperMngr) GetLastSelectionDecision() *SelectionDecision {
+	return op.selector.getLastDecision()
+}
+
+// SetHealthOptions задаёт параметры выключения сбоящих провайдеров
+func (op *OperMngr) SetHealthOptions(options HealthOptions) error {
+	return op.health.setOptions(options)
+}
+
+// GetProvidersHealth возвращает состояние провайдеров в порядке их добавления
+func (op *OperMngr) GetProvidersHealth() []ProviderHealth {
+	codes := make([]string, 0, len(op.imageProviders))
+	for _, provider := range op.imageProviders {
+		codes = append(codes, (*provider).GetImageProviderCode())
 	}
+	return op.health.snapshot(codes)
+}
 
-	if len(readyProviders) == 0 {
+func (op *OperMngr) chooseImageProvider(dev *device) *ImageProvider {
+	// Перебираем провайдеров которые могут принять задание. Выключенные сбоящие провайдеры пропускаем
+	providers := op.deviceProviders(dev, op.imageProviders)
+	idx := op.selector.choose(providers, op.providerUnavailableReason)
+	if idx < 0 {
 		return nil
-	} else if len(readyProviders) == 1 {
-		op.logger.Debug("Choose provider", "provider", (*op.imageProviders[readyProviders[0]]).GetImageProviderForImageServerName())
-		return op.imageProviders[readyProviders[0]]
 	}
 
-	idx := rand.Intn(len(readyProviders))
-	op.logger.Debug("Choose provider", "provider", (*op.imageProviders[readyProviders[idx]]).GetImageProviderForImageServerName())
+	provider := providers[idx]
+	op.health.acquire((*provider).GetImageProviderCode())
+	op.logger.Debug("Choose provider", "provider", (*provider).GetImageProviderForImageServerName())
+	return provider
+}
 
-	return op.imageProviders[idx]
+func (op *OperMngr) providerUnavailableReason(code string) string {
+	if reason := op.budget.exhaustedReason(code); reason != "" {
+		return reason
+	}
+	return op.health.unavailableReason(code)
 }
 
-func (op *OperMngr) startAutoOperation() (string, error) {
-	op.logger.Info("Start auto operation")
+func (op *OperMngr) providerSucceeded(provider *ImageProvider) {
+	health := op.health.recordSuccess((*provider).GetImageProviderCode())
+	op.updateHealthMetrics(health)
+}
+
+func (op *OperMngr) providerFailed(provider *ImageProvider, err error) {
+	health, tripped := op.health.recordFailure((*provider).GetImageProviderCode(), err)
+	if tripped {
+		op.logger.Warn("Provider circuit is open", "provider", health.Code, "failures", health.ConsecutiveFailures, "until", health.OpenUntil)
+	}
+	op.updateHealthMetrics(health)
+}
+
+func (op *OperMngr) updateHealthMetrics(health ProviderHealth) {
+	op.metrics.UpdateGauge(METRIC_TEMPLATE_PROVIDER_STATE+health.Code, health.State.metricValue())
+	op.metrics.UpdateGauge(METRIC_TEMPLATE_PROVIDER_FAILURES+health.Code, int64(health.ConsecutiveFailures))
+	op.metrics.UpdateGauge(METRIC_TEMPLATE_PROVIDER_TRIPS+health.Code, int64(health.Trips))
+}
+
+func (op *OperMngr) startAutoOperation(dev *device) (string, error) {
+	op.logger.Info("Start auto operation", "device", dev.id)
 	now := time.Now()
 
-	st := op.checkSleepTime(now)
+	st := op.checkSleepTime(dev, now)
 
 	// Сейчас период сна. Посмотрим что надо сделать.
+	if st != nil && st.BlackImageMode {
+		return op.startBlackPictureOperation(dev)
+	}
+
+	// Изображение, которое попросили показать следующим, отдаётся без оглядки на порог провайдера
+	if originalFile := op.nextShowNextFile(dev); originalFile != "" {
+		op.logger.Info("Start show next operation", "device", dev.id, "file", originalFile)
+		return op.startStoredPictureOperation(dev, originalFile)
+	}
+
 	if st != nil {
-		if st.BlackImageMode {
-			return op.startBlackPictureOperation()
-		} else {
-			return op.startOldPictureOperation()
-		}
+		return op.startOldPictureOperation(dev)
 	}
 
-	if op.actioner.ThresholdOut(now) {
+	if dev.actioner.ThresholdOut(now) {
 		op.logger.Debug("Threshold")
+		// Свежее изображение уже готово. Отдаём его сразу, а очередь пополняем в фоне.
+		// Очередь готовится под размер рамки по умолчанию
+		if dev == op.defaultDevice {
+			if operation, ok := op.startPrefetchedOperation(); ok {
+				dev.actioner.SetLastCallTime(now)
+				go op.RefillPrefetchQueue()
+				return operation, nil
+			}
+		}
+
 		// Внешний провайдер давно не вызывался
-		provider := op.chooseImageProvider()
+		provider := op.chooseImageProvider(dev)
 
 		if provider == nil {
 			op.logger.Debug("Ready provider is nil")
 			// Вызываем менеджер старых изображений
-			return op.startOldPictureOperation()
+			return op.startOldPictureOperation(dev)
 		}
-		operation, err := op.startProviderOperation(provider, "", false)
+		operation, err := op.startProviderOperation(dev, provider, "", "", false, false)
 		if err != nil {
 			return "", err
 		}
 		// Обновляем время последнего вызова
-		op.actioner.SetLastCallTime(now)
+		dev.actioner.SetLastCallTime(now)
 		return operation, nil
 	} else {
 		// Вызываем менеджер старых изображений
-		return op.startOldPictureOperation()
+		return op.startOldPictureOperation(dev)
 	}
 }
 
-func (op *OperMngr) startBlackPictureOperation() (string, error) {
+func (op *OperMngr) startBlackPictureOperation(dev *device) (string, error) {
 	op.logger.Info("Start black picture operation")
-	return op.startGetOldPictureFromLocalStorageOperation(true)
+	return op.startGetOldPictureFromLocalStorageOperation(dev, true)
 }
-func (op *OperMngr) startOldPictureOperation() (string, error) {
+func (op *OperMngr) startOldPictureOperation(dev *device) (string, error) {
 	op.logger.Info("Start old picture operation")
-	return op.startGetOldPictureFromLocalStorageOperation(false)
+	return op.startGetOldPictureFromLocalStorageOperation(dev, false)
 }
 
-func (op *OperMngr) startGetOldPictureFromLocalStorageOperation(getBlackPicture bool) (string, error) {
-	id := op.generateId()
-	var file string
-	if getBlackPicture {
-		file = BLACK_FILE_NAME
-	} else {
-		originalFile := op.dirManager.GetRandomFile()
-		imgBytes, err := os.ReadFile(originalFile)
+func (op *OperMngr) startGetOldPictureFromLocalStorageOperation(dev *device, getBlackPicture bool) (string, error) {
+	if !getBlackPicture {
+		return op.startStoredPictureOperation(dev, op.history.choose(dev.id, op.dirManager.GetFiles()))
+	}
+	return op.completeLocalOperation(dev, op.generateId(), dev.blackFileName)
+}
 
-		if err != nil {
-			return id, fmt.Errorf("error when read file %v", err)
-		}
+// startStoredPictureOperation отдаёт рамке оригинал из каталога
+func (op *OperMngr) startStoredPictureOperation(dev *device, originalFile string) (string, error) {
+	id := op.generateId()
+	imgBytes, err := os.ReadFile(originalFile)
 
-		file, err = op.saveFiles(id, imgBytes, false)
-		if err != nil {
-			return id, err
-		}
+	if err != nil {
+		return id, fmt.Errorf("error when read file %v", err)
+	}
+	op.imageShown(dev, originalFile)
 
+	file, _, err := op.saveFiles(dev, &Operation{Id: id}, imgBytes, false)
+	if err != nil {
+		return id, err
 	}
+	return op.completeLocalOperation(dev, id, file)
+}
 
+// completeLocalOperation создаёт завершённую операцию с готовым файлом для рамки
+func (op *OperMngr) completeLocalOperation(dev *device, id string, file string) (string, error) {
 	op.logger.Info("Start old picture operation", "file", file)
 	operation := Operation{
 		Id:         id,
 		ExternalId: "dirManagerOperation",
 		Type:       OldPicture,
 		FileName:   file,
+		DeviceId:   dev.id,
+		CreatedAt:  time.Now(),
 		status: &OperStatus{
 			Status: StatusDone,
 			Error:  "",
 		},
 	}
 	op.completeOperations.SetDefault(operation.Id, &operation)
+	op.saveOperation(&operation)
 	op.logger.Info("Start old picture operation", "operationId", operation.Id, "file", operation.FileName)
 	return operation.Id, nil
 
 }
 
-func (op *OperMngr) startProviderOperation(provider *ImageProvider, prompt string, isDirectCall bool) (string, error) {
-	op.logger.Info("Start provider operation", "isDirectCall", isDirectCall)
+func (op *OperMngr) startProviderOperation(dev *device, provider *ImageProvider, prompt string, negative string, isDirectCall bool, prefetch bool) (string, error) {
+	op.logger.Info("Start provider operation", "device", dev.id, "isDirectCall", isDirectCall, "prefetch", prefetch)
 
 	providerMetric := op.metrics.GetRequestTypeMetricsSafe(METRIC_TEMPLATE_OPERATION_START + (*provider).GetImageProviderCode())
 
+	err := op.budget.reserve((*provider).GetImageProviderCode())
+	if err != nil {
+		op.logger.Warn("Can not start operation", "error", err)
+		return "", err
+	}
+
 	var externalId string
-	var err error
+	promptIdx := 0
+
+	// У рамки свой набор промптов
+	if prompt == "" && dev.prompts != nil && (*provider).GetProperties().IsCanWorkWithPrompt {
+		promptValue, err := dev.prompts.GetRandomPrompt()
+		if err != nil {
+			op.logger.Error("Can not get device prompt", "device", dev.id, "error", err)
+			op.budget.release((*provider).GetImageProviderCode())
+			return "", err
+		}
+		prompt = promptValue.Prompt
+		promptIdx = promptValue.Idx
+		negative = ""
+		if promptValue.Negative != nil {
+			negative = *promptValue.Negative
+		}
+	}
 
 	if prompt != "" {
 		op.logger.Debug("Start provider operation with prompt")
-		externalId, err = (*provider).GenerateWithPrompt(strings.Trim(prompt, " "), isDirectCall)
+		externalId, err = (*provider).GenerateWithPrompt(strings.Trim(prompt, " "), strings.Trim(negative, " "), isDirectCall)
 	} else {
 		externalId, err = (*provider).Generate(isDirectCall)
 	}
@@ -320,6 +542,8 @@ func (op *OperMngr) startProviderOperation(provider *ImageProvider, prompt strin
 		resultError := fmt.Errorf("error provider generate %v", err)
 		op.logger.Error("Can not start operation", "error", resultError)
 		providerMetric.IncrementErrorRequest()
+		op.providerFailed(provider, err)
+		op.budget.release((*provider).GetImageProviderCode())
 
 		return "", resultError
 	}
@@ -327,17 +551,31 @@ func (op *OperMngr) startProviderOperation(provider *ImageProvider, prompt strin
 	providerMetric.IncrementSuccessRequest()
 	op.metrics.IncrementDaily(METRIC_TEMPLATE_OPERATION_START + (*provider).GetImageProviderCode())
 
+	// Если провайдер не рассказал о параметрах генерации, промпт известен хотя бы когда его выбрали здесь
+	info := getGenerationInfo(provider, externalId)
+	if info == nil && prompt != "" {
+		info = &GenerationInfo{Prompt: strings.Trim(prompt, " "), Negative: strings.Trim(negative, " ")}
+	}
+	if info != nil && promptIdx > 0 {
+		info.PromptIdx = promptIdx
+	}
+
 	operation := Operation{
 		Id:         op.generateId(),
 		Provider:   provider,
 		ExternalId: externalId,
 		Type:       YandexArt,
+		CreatedAt:  time.Now(),
+		Info:       info,
+		Prefetch:   prefetch,
+		DeviceId:   dev.id,
 		status: &OperStatus{
 			Status: StatusPending,
 			Error:  "",
 		},
 	}
 	op.pendingOperations.SetDefault(operation.Id, &operation)
+	op.saveOperation(&operation)
 	return operation.Id, nil
 }
 
@@ -364,16 +602,37 @@ func (op *OperMngr) GetOperationStatus(id string) (*OperStatus, error) {
 
 	ydOperationResult, imageData, err := (*provider).GetImageSlice(operation.(*Operation).ExternalId)
 	if err != nil {
-		return nil, err
+		// Сбой опроса не значит, что генерация не удалась: операция может завершиться при следующей проверке
+		if !ydOperationResult {
+			return nil, err
+		}
+
+		// Провайдер завершил операцию с ошибкой. Опрашивать его дальше бесполезно
+		op.providerFailed(provider, err)
+		op.logger.Error("Operation failed", "id", id, "error", err)
+		failedOperation := operation.(*Operation)
+		failedOperation.status = &OperStatus{Status: StatusError, Error: err.Error()}
+		op.completeOperations.SetDefault(id, failedOperation)
+		op.pendingOperations.Delete(id)
+		op.saveOperation(failedOperation)
+		return failedOperation.status, nil
 	}
 
 	if ydOperationResult {
 		op.logger.Debug("Operation completed", "id", operation.(*Operation).Id)
 		operStatus := &OperStatus{Status: StatusDone, Error: ""}
 
-		fileName, err := op.saveFiles(id, imageData, isNeedSaveLocalFiles)
+		dev := op.findDevice(operation.(*Operation).DeviceId)
+		fileName, originalFileName, err := op.saveFiles(dev, operation.(*Operation), imageData, isNeedSaveLocalFiles)
+		// Свежее изображение рамка покажет сейчас, повторять его в ближайшее время не нужно
+		if originalFileName != "" && !operation.(*Operation).Prefetch {
+			op.imageShown(dev, originalFileName)
+		}
 		if err != nil {
 			operStatus = &OperStatus{Status: StatusError, Error: err.Error()}
+			op.providerFailed(provider, err)
+		} else {
+			op.providerSucceeded(provider)
 		}
 
 		op.logger.Debug("Operation completed", "id", operation.(*Operation).Id, "fileName", fileName)
@@ -383,38 +642,68 @@ func (op *OperMngr) GetOperationStatus(id string) (*OperStatus, error) {
 		completeOperation.FileName = fileName
 		op.completeOperations.SetDefault(id, completeOperation)
 		op.pendingOperations.Delete(id)
-
+		op.saveOperation(completeOperation)
+		if completeOperation.Prefetch && err == nil {
+			op.addPrefetchedImage(completeOperation, fileName, originalFileName)
+		}
 	}
 
 	return &OperStatus{Status: StatusPending}, nil
 }
 
-func (op *OperMngr) saveFiles(id string, imageData []byte, isNeedSaveLocalFiles bool) (string, error) {
+// saveFiles сохраняет изображение под размер рамки и, если нужно, оригинал с метаданными. Оригиналы общие для всех рамок.
+// Изображение для очереди предзагрузки кладётся в отдельный каталог, чтобы его не удалила очистка временных файлов.
+// Возвращает имя файла для рамки и имя оригинала (пустое, если оригинал не сохранялся)
+func (op *OperMngr) saveFiles(dev *device, operation *Operation, imageData []byte, isNeedSaveLocalFiles bool) (string, string, error) {
+	id := operation.Id
+	prefetch := operation.Prefetch
+	var fileNameOrig string
 	if isNeedSaveLocalFiles {
-		fileNameOrig := op.generateFileName(id)
+		fileNameOrig = op.generateFileName(id)
 		err := writeFile(fileNameOrig, imageData)
 		if err != nil {
 			op.logger.Error("Can not save local original file", "error", err)
+			fileNameOrig = ""
+		} else {
+			op.saveImageRecord(operation, fileNameOrig, imageData)
+			op.dirManager.AddFile(fileNameOrig)
 		}
-		op.dirManager.AddFile(fileNameOrig)
 	}
 
 	// Сконвертируем изображение к целевому размеру
 
 	fileName := op.generateTemporaryFileName(id)
+	if prefetch {
+		fileName = op.generatePrefetchFileName()
+	}
 
-	fit, _, err := op.ipr.ProcessImageFromSLice(imageData, op.imageParameters.Weight, op.imageParameters.Height, false)
+	fit, _, err := dev.ipr.ProcessImageFromSLice(imageData, dev.imageParameters.Weight, dev.imageParameters.Height, false)
 	if err != nil {
-		return "", err
+		return "", fileNameOrig, err
 	}
 
 	err = writeFile(fileName, fit)
 	if err != nil {
-		return "", err
+		return "", fileNameOrig, err
+	}
+	if !prefetch {
+		op.dirManagerTemp.AddFile(fileName)
 	}
-	op.dirManagerTemp.AddFile(fileName)
 
-	return fileName, nil
+	return fileName, fileNameOrig, nil
+}
+
+// imageShown учитывает показ оригинала на рамке
+func (op *OperMngr) imageShown(dev *device, fileName string) {
+	op.history.recordShown(dev.id, fileName)
+	op.incrementDisplayCount(fileName)
+}
+
+func getGenerationInfo(provider *ImageProvider, externalId string) *GenerationInfo {
+	if infoProvider, ok := (*provider).(GenerationInfoProvider); ok {
+		return infoProvider.GetGenerationInfo(externalId)
+	}
+	return nil
 }
 
 func (op *OperMngr) GetFileName(id string) (string, error) {
@@ -449,9 +738,11 @@ func (op *OperMngr) CheckPendingOperations() {
 	}
 }
 
-func (op *OperMngr) checkSleepTime(now time.Time) *SleepTime {
+func (op *OperMngr) checkSleepTime(dev *device, now time.Time) *SleepTime {
+	op.settingsMutex.RLock()
+	defer op.settingsMutex.RUnlock()
 
-	for _, st := range op.sleepTimes {
+	for _, st := range dev.sleepTimes {
 		inclusive, err := st.TimeRange.IsWithinRangeInclusive(now)
 		if err != nil {
 			op.logger.Error("Get time range error", "error", err)
@@ -530,21 +821,20 @@ func (op *OperMngr) fillBlack24Bit(rgba *image.RGBA) {
 	op.logger.Debug("Fill pixels completed")
 }
 
+// generateId Идентификатор операции. Уникален даже для запросов, пришедших в одну секунду
 func (op *OperMngr) generateId() string {
-	unixSeconds := time.Now().Unix()
-	return "i" + strconv.Itoa(int(unixSeconds))
+	return "i" + utils.NewUlid()
 }
 
+// generateFileName Имя файла оригинала. Старые файлы вида f<unix>-orig.jpeg продолжают читаться DirManager
 func (op *OperMngr) generateFileName(id string) string {
-	unixSeconds := time.Now().Unix()
-	orig := "f" + strconv.Itoa(int(unixSeconds)) + "-orig.jpeg"
+	orig := "f" + utils.NewUlid() + "-orig.jpeg"
 
 	return filepath.Join(op.dirManager.GetDirectoryPath(), orig)
 }
 
 func (op *OperMngr) generateTemporaryFileName(id string) string {
-	unixSeconds := time.Now().Unix()
-	small := "f" + strconv.Itoa(int(unixSeconds)) + ".jpeg"
+	small := "f" + utils.NewUlid() + ".jpeg"
 
 	return filepath.Join(op.dirManagerTemp.GetDirectoryPath(), small)
 }
@@ -558,3 +848,7 @@ func writeFile(filePath string, src []byte) error {
 	}
 	return nil
 }
+
+func (op *OperMngr) generatePrefetchFileName() string {
+	return filepath.Join(PREFETCH_IMAGE_DIR, "f"+utils.NewUlid()+".jpeg")
+}
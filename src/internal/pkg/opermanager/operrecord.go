@@ -0,0 +1,134 @@
+package opermanager
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const OPERATIONS_BUCKET = "operations"
+
+// operationRecord представление операции в хранилище
+type operationRecord struct {
+	Id           string          `json:"id"`
+	ProviderCode string          `json:"provider_code,omitempty"`
+	ExternalId   string          `json:"external_id"`
+	FileName     string          `json:"file_name,omitempty"`
+	Type         generatorType   `json:"type"`
+	Status       Status          `json:"status"`
+	Error        string          `json:"error,omitempty"`
+	Info         *GenerationInfo `json:"info,omitempty"`
+	Prefetch     bool            `json:"prefetch,omitempty"`
+	DeviceId     string          `json:"device_id,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+}
+
+func newOperationRecord(operation *Operation) operationRecord {
+	record := operationRecord{
+		Id:         operation.Id,
+		ExternalId: operation.ExternalId,
+		FileName:   operation.FileName,
+		Type:       operation.Type,
+		Info:       operation.Info,
+		Prefetch:   operation.Prefetch,
+		DeviceId:   operation.DeviceId,
+		Status:     StatusUnknown,
+		CreatedAt:  operation.CreatedAt,
+		UpdatedAt:  operation.UpdatedAt,
+	}
+	if operation.Provider != nil {
+		record.ProviderCode = (*operation.Provider).GetImageProviderCode()
+	}
+	if operation.status != nil {
+		record.Status = operation.status.Status
+		record.Error = operation.status.Error
+	}
+	return record
+}
+
+// saveOperation сохраняет операцию в хранилище. Ошибка только логируется:
+// отказ хранилища не должен ломать выдачу изображения
+func (op *OperMngr) saveOperation(operation *Operation) {
+	if op.storage == nil {
+		return
+	}
+	operation.UpdatedAt = time.Now()
+	err := op.storage.Put(OPERATIONS_BUCKET, operation.Id, newOperationRecord(operation))
+	if err != nil {
+		op.logger.Error("Can not save operation", "operationId", operation.Id, "error", err)
+	}
+}
+
+func (op *OperMngr) deleteOperation(id string) {
+	if op.storage == nil {
+		return
+	}
+	err := op.storage.Delete(OPERATIONS_BUCKET, id)
+	if err != nil {
+		op.logger.Error("Can not delete operation", "operationId", id, "error", err)
+	}
+}
+
+// restoreOperations загружает сохранённые операции в кэши.
+// Возвращает количество восстановленных незавершённых операций
+func (op *OperMngr) restoreOperations() (int, error) {
+	if op.storage == nil {
+		return 0, nil
+	}
+
+	now := time.Now()
+	records := make([]operationRecord, 0)
+	err := op.storage.ForEach(OPERATIONS_BUCKET, func(key string, data []byte) error {
+		var record operationRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			op.logger.Warn("Skip broken operation record", "operationId", key, "error", err)
+			return nil
+		}
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, record := range records {
+		ttl := OPERATION_TTL - now.Sub(record.UpdatedAt)
+		if ttl <= 0 {
+			op.logger.Debug("Drop expired operation", "operationId", record.Id)
+			op.deleteOperation(record.Id)
+			continue
+		}
+
+		operation := &Operation{
+			Id:         record.Id,
+			ExternalId: record.ExternalId,
+			FileName:   record.FileName,
+			Type:       record.Type,
+			Info:       record.Info,
+			Prefetch:   record.Prefetch,
+			DeviceId:   record.DeviceId,
+			CreatedAt:  record.CreatedAt,
+			UpdatedAt:  record.UpdatedAt,
+			status:     &OperStatus{Status: record.Status, Error: record.Error},
+		}
+
+		if record.Status == StatusPending {
+			provider := op.findImageProvider(record.ProviderCode)
+			if provider == nil {
+				op.logger.Warn("Provider for pending operation not found", "operationId", record.Id, "provider", record.ProviderCode)
+				op.deleteOperation(record.Id)
+				continue
+			}
+			operation.Provider = provider
+			op.pendingOperations.Set(operation.Id, operation, ttl)
+			pending++
+		} else {
+			op.completeOperations.Set(operation.Id, operation, ttl)
+		}
+		op.logger.Debug("Restore operation", "operationId", record.Id, "status", record.Status, "provider", record.ProviderCode)
+	}
+
+	op.logger.Info("Operations restored", "total", len(records), "pending", pending)
+	return pending, nil
+}
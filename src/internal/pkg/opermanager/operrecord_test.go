@@ -0,0 +1,81 @@
+package opermanager
+
+import (
+	"imgserver/internal/pkg/storage"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOperations_RestoreAfterRestart(t *testing.T) {
+	t.Chdir(t.TempDir())
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	st, err := storage.NewStorage("test.db", logger)
+	require.NoError(t, err)
+	defer st.Close()
+
+	provider := &imageFakeProvider{fakeProvider: fakeProvider{code: "Fake", ready: true}, image: newTestJpeg(t)}
+	op := newTestOperMngr(t, st, nil, provider)
+
+	completeId, err := op.StartOperation("ydart", "", "")
+	require.NoError(t, err)
+	// Первый опрос забирает изображение, второй возвращает итоговый статус
+	_, err = op.GetOperationStatus(completeId)
+	require.NoError(t, err)
+	status, err := op.GetOperationStatus(completeId)
+	require.NoError(t, err)
+	require.Equal(t, StatusDone, status.Status)
+	completeFile, err := op.GetFileName(completeId)
+	require.NoError(t, err)
+
+	// Операция осталась незавершённой к моменту остановки сервера
+	pendingId, err := op.StartOperation("ydart", "", "")
+	require.NoError(t, err)
+
+	now := time.Now()
+	require.NoError(t, st.Put(OPERATIONS_BUCKET, "expired", operationRecord{
+		Id: "expired", ProviderCode: "Fake", ExternalId: "ext0", Status: StatusPending,
+		CreatedAt: now.Add(-2 * OPERATION_TTL), UpdatedAt: now.Add(-OPERATION_TTL - time.Minute),
+	}))
+	require.NoError(t, st.Put(OPERATIONS_BUCKET, "unknown", operationRecord{
+		Id: "unknown", ProviderCode: "Gone", ExternalId: "ext0", Status: StatusPending,
+		CreatedAt: now, UpdatedAt: now,
+	}))
+
+	// Перезапуск: новый менеджер поверх того же хранилища
+	restarted := &imageFakeProvider{fakeProvider: fakeProvider{code: "Fake", ready: true}, image: newTestJpeg(t)}
+	op = newTestOperMngr(t, st, nil, restarted)
+
+	status, err = op.GetOperationStatus(completeId)
+	require.NoError(t, err)
+	assert.Equal(t, StatusDone, status.Status)
+	fileName, err := op.GetFileName(completeId)
+	require.NoError(t, err)
+	assert.Equal(t, completeFile, fileName)
+
+	// Опрос незавершённой операции возобновляется сразу после запуска
+	require.Eventually(t, func() bool {
+		_, ok := op.completeOperations.Get(pendingId)
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+	status, err = op.GetOperationStatus(pendingId)
+	require.NoError(t, err)
+	assert.Equal(t, StatusDone, status.Status)
+	fileName, err = op.GetFileName(pendingId)
+	require.NoError(t, err)
+	assert.NotEmpty(t, fileName)
+
+	// Просроченные операции и операции неизвестных провайдеров удаляются
+	for _, id := range []string{"expired", "unknown"} {
+		_, err = op.GetOperationStatus(id)
+		assert.Error(t, err, id)
+		var record operationRecord
+		found, err := st.Get(OPERATIONS_BUCKET, id, &record)
+		require.NoError(t, err)
+		assert.False(t, found, id)
+	}
+}
@@ -0,0 +1,229 @@
+package opermanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const PREFETCH_BUCKET = "prefetch"
+const PREFETCH_IMAGE_DIR = "prefetch_images"
+
+// PrefetchOptions настройки очереди заранее сгенерированных изображений
+type PrefetchOptions struct {
+	// QueueSize сколько свежих изображений держать наготове. 0 - очередь выключена
+	QueueSize int `yaml:"queue_size"`
+	// RefillCron как часто пополнять очередь
+	RefillCron string `yaml:"refill_cron"`
+}
+
+// prefetchedImage готовое изображение размера рамки, которое ещё не показывалось
+type prefetchedImage struct {
+	Id       string `json:"id"`
+	FileName string `json:"file_name"`
+	// OriginalFileName оригинал изображения. Пустой, если провайдер не сохраняет оригиналы
+	OriginalFileName string          `json:"original_file_name,omitempty"`
+	ProviderCode     string          `json:"provider_code,omitempty"`
+	Info             *GenerationInfo `json:"info,omitempty"`
+	CreatedAt        time.Time       `json:"created_at"`
+}
+
+type prefetchQueue struct {
+	size        int
+	items       []*prefetchedImage
+	mutex       sync.Mutex
+	refillMutex sync.Mutex
+}
+
+func newPrefetchQueue() *prefetchQueue {
+	return &prefetchQueue{}
+}
+
+func (pq *prefetchQueue) push(image *prefetchedImage) {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	pq.items = append(pq.items, image)
+}
+
+// pop отдаёт самое старое изображение
+func (pq *prefetchQueue) pop() *prefetchedImage {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	if len(pq.items) == 0 {
+		return nil
+	}
+	image := pq.items[0]
+	pq.items = pq.items[1:]
+	return image
+}
+
+func (pq *prefetchQueue) length() int {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	return len(pq.items)
+}
+
+func (pq *prefetchQueue) getSize() int {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	return pq.size
+}
+
+// SetPrefetchOptions задаёт размер очереди заранее сгенерированных изображений
+func (op *OperMngr) SetPrefetchOptions(options PrefetchOptions) error {
+	if options.QueueSize < 0 {
+		return fmt.Errorf("negative prefetch queue size: %d", options.QueueSize)
+	}
+	op.prefetch.mutex.Lock()
+	defer op.prefetch.mutex.Unlock()
+	op.prefetch.size = options.QueueSize
+	return nil
+}
+
+// GetPrefetchQueueLength количество готовых к показу свежих изображений
+func (op *OperMngr) GetPrefetchQueueLength() int {
+	return op.prefetch.length()
+}
+
+// RefillPrefetchQueue запускает генерации, которых не хватает до размера очереди.
+// Учитываются периоды сна и готовность провайдеров (их пороги, состояние и бюджеты)
+func (op *OperMngr) RefillPrefetchQueue() {
+	size := op.prefetch.getSize()
+	if size == 0 {
+		return
+	}
+	// Пополнение уже идёт
+	if !op.prefetch.refillMutex.TryLock() {
+		return
+	}
+	defer op.prefetch.refillMutex.Unlock()
+
+	if op.checkSleepTime(op.defaultDevice, time.Now()) != nil {
+		op.logger.Debug("Skip prefetch in sleep time")
+		return
+	}
+
+	need := size - op.prefetch.length() - op.pendingPrefetchCount()
+	for i := 0; i < need; i++ {
+		provider := op.chooseImageProvider(op.defaultDevice)
+		if provider == nil {
+			op.logger.Debug("No ready provider for prefetch", "need", need-i)
+			return
+		}
+		id, err := op.startProviderOperation(op.defaultDevice, provider, "", "", false, true)
+		if err != nil {
+			op.logger.Error("Can not start prefetch operation", "error", err)
+			return
+		}
+		op.logger.Info("Start prefetch operation", "operationId", id, "provider", (*provider).GetImageProviderCode())
+	}
+}
+
+func (op *OperMngr) pendingPrefetchCount() int {
+	count := 0
+	for _, item := range op.pendingOperations.Items() {
+		if item.Object.(*Operation).Prefetch {
+			count++
+		}
+	}
+	return count
+}
+
+// addPrefetchedImage кладёт результат завершённой фоновой генерации в очередь
+func (op *OperMngr) addPrefetchedImage(operation *Operation, fileName string, originalFileName string) {
+	image := &prefetchedImage{
+		Id:               operation.Id,
+		FileName:         fileName,
+		OriginalFileName: originalFileName,
+		Info:             operation.Info,
+		CreatedAt:        time.Now(),
+	}
+	if operation.Provider != nil {
+		image.ProviderCode = (*operation.Provider).GetImageProviderCode()
+	}
+
+	op.prefetch.push(image)
+	if op.storage != nil {
+		if err := op.storage.Put(PREFETCH_BUCKET, image.Id, image); err != nil {
+			op.logger.Error("Can not save prefetched image", "operationId", image.Id, "error", err)
+		}
+	}
+	op.logger.Info("Prefetched image is ready", "operationId", image.Id, "queue", op.prefetch.length())
+}
+
+// startPrefetchedOperation отдаёт изображение из очереди как уже завершённую операцию
+func (op *OperMngr) startPrefetchedOperation() (string, bool) {
+	for {
+		image := op.prefetch.pop()
+		if image == nil {
+			return "", false
+		}
+		if op.storage != nil {
+			if err := op.storage.Delete(PREFETCH_BUCKET, image.Id); err != nil {
+				op.logger.Error("Can not delete prefetched image", "operationId", image.Id, "error", err)
+			}
+		}
+
+		id := op.generateId()
+		// Файл переезжает во временный каталог и дальше живёт как обычное изображение для рамки
+		fileName := op.generateTemporaryFileName(id)
+		if err := os.Rename(image.FileName, fileName); err != nil {
+			op.logger.Error("Can not move prefetched image", "file", image.FileName, "error", err)
+			continue
+		}
+		op.dirManagerTemp.AddFile(fileName)
+
+		operation := Operation{
+			Id:         id,
+			Provider:   op.findImageProvider(image.ProviderCode),
+			ExternalId: image.Id,
+			Type:       YandexArt,
+			FileName:   fileName,
+			Info:       image.Info,
+			CreatedAt:  time.Now(),
+			status:     &OperStatus{Status: StatusDone},
+		}
+		op.completeOperations.SetDefault(operation.Id, &operation)
+		op.saveOperation(&operation)
+		// Показ учитывается сейчас, а не при генерации: изображение могло долго ждать в очереди
+		op.imageShown(op.defaultDevice, image.OriginalFileName)
+		op.logger.Info("Serve prefetched image", "operationId", id, "file", fileName, "queue", op.prefetch.length())
+		return id, true
+	}
+}
+
+// restorePrefetchQueue загружает очередь из хранилища. Записи без файла удаляются
+func (op *OperMngr) restorePrefetchQueue() error {
+	if op.storage == nil {
+		return nil
+	}
+
+	var images []*prefetchedImage
+	err := op.storage.ForEach(PREFETCH_BUCKET, func(key string, data []byte) error {
+		var image prefetchedImage
+		if err := json.Unmarshal(data, &image); err != nil {
+			op.logger.Warn("Skip broken prefetched image record", "operationId", key, "error", err)
+			return nil
+		}
+		images = append(images, &image)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(images, func(i, j int) bool { return images[i].CreatedAt.Before(images[j].CreatedAt) })
+	for _, image := range images {
+		if _, err := os.Stat(image.FileName); err != nil {
+			op.logger.Warn("Prefetched image file not found", "file", image.FileName)
+			op.storage.Delete(PREFETCH_BUCKET, image.Id)
+			continue
+		}
+		op.prefetch.push(image)
+	}
+	op.logger.Info("Prefetch queue restored", "queue", op.prefetch.length())
+	return nil
+}
@@ -0,0 +1,173 @@
+package opermanager
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"imgserver/internal/pkg/dirmanager"
+	"imgserver/internal/pkg/imageprocessor"
+	"imgserver/internal/pkg/metrics"
+	"imgserver/internal/pkg/storage"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// imageFakeProvider сразу возвращает готовое изображение
+type imageFakeProvider struct {
+	fakeProvider
+	image      []byte
+	withPrompt bool
+	saveLocal  bool
+	lastPrompt string
+	generated  atomic.Int32
+}
+
+func (ifp *imageFakeProvider) Generate(bool) (string, error) {
+	return fmt.Sprintf("ext%d", ifp.generated.Add(1)), nil
+}
+
+func (ifp *imageFakeProvider) GenerateWithPrompt(prompt string, _ string, _ bool) (string, error) {
+	ifp.lastPrompt = prompt
+	return ifp.Generate(true)
+}
+
+func (ifp *imageFakeProvider) GetProperties() *ProviderProperties {
+	return &ProviderProperties{IsCanWorkWithPrompt: ifp.withPrompt, IsNeedSaveLocalFiles: ifp.saveLocal}
+}
+
+func (ifp *imageFakeProvider) GetImageSlice(string) (bool, []byte, error) {
+	return true, ifp.image, nil
+}
+
+func newTestJpeg(t *testing.T) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 80, 60)), nil))
+	return buf.Bytes()
+}
+
+// newTestOperMngr configure вызывается перед запуском, например чтобы добавить рамки
+func newTestOperMngr(t *testing.T, st *storage.Storage, configure func(op *OperMngr), providers ...ImageProvider) *OperMngr {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dm, err := dirmanager.NewDirManagerWithoutCleanup("images", logger)
+	require.NoError(t, err)
+	require.NoError(t, dm.Start())
+
+	params := imageprocessor.ImageParameters{ImageWeight: 40, ImageHeight: 30}
+	op, err := NewOperMngr(0, params, nil, dm, metrics.NewAppMetrics(), st, logger)
+	require.NoError(t, err)
+	for _, provider := range providers {
+		op.AddImageProvider(&provider)
+	}
+	if configure != nil {
+		configure(op)
+	}
+	require.NoError(t, op.Start())
+	return op
+}
+
+func TestPrefetch_ServeAndRefill(t *testing.T) {
+	t.Chdir(t.TempDir())
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	st, err := storage.NewStorage("test.db", logger)
+	require.NoError(t, err)
+	defer st.Close()
+
+	provider := &imageFakeProvider{fakeProvider: fakeProvider{code: "Fake", ready: true}, image: newTestJpeg(t)}
+	op := newTestOperMngr(t, st, nil, provider)
+	require.NoError(t, op.SetPrefetchOptions(PrefetchOptions{QueueSize: 2}))
+
+	op.RefillPrefetchQueue()
+	assert.Equal(t, 2, op.pendingPrefetchCount())
+	// Запущенные генерации не дублируются
+	op.RefillPrefetchQueue()
+	assert.EqualValues(t, 2, provider.generated.Load())
+
+	op.CheckPendingOperations()
+	require.Equal(t, 2, op.GetPrefetchQueueLength())
+
+	id, err := op.StartOperation("", "", "")
+	require.NoError(t, err)
+	status, err := op.GetOperationStatus(id)
+	require.NoError(t, err)
+	assert.Equal(t, StatusDone, status.Status)
+	fileName, err := op.GetFileName(id)
+	require.NoError(t, err)
+	assert.FileExists(t, fileName)
+	assert.Equal(t, 1, op.GetPrefetchQueueLength())
+
+	// Очередь пополняется в фоне
+	assert.Eventually(t, func() bool { return provider.generated.Load() == 3 }, time.Second, 10*time.Millisecond)
+}
+
+func TestPrefetch_RestoreQueue(t *testing.T) {
+	t.Chdir(t.TempDir())
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	st, err := storage.NewStorage("test.db", logger)
+	require.NoError(t, err)
+	defer st.Close()
+
+	provider := &imageFakeProvider{fakeProvider: fakeProvider{code: "Fake", ready: true}, image: newTestJpeg(t)}
+	op := newTestOperMngr(t, st, nil, provider)
+	require.NoError(t, op.SetPrefetchOptions(PrefetchOptions{QueueSize: 2}))
+	op.RefillPrefetchQueue()
+	op.CheckPendingOperations()
+	require.Equal(t, 2, op.GetPrefetchQueueLength())
+
+	// Файл одного из изображений пропал, пока сервер был остановлен
+	require.NoError(t, os.Remove(op.prefetch.items[0].FileName))
+
+	restored := newTestOperMngr(t, st, nil, provider)
+	assert.Equal(t, 1, restored.GetPrefetchQueueLength())
+}
+
+func TestPrefetch_Disabled(t *testing.T) {
+	t.Chdir(t.TempDir())
+	provider := &imageFakeProvider{fakeProvider: fakeProvider{code: "Fake", ready: true}, image: newTestJpeg(t)}
+	op := newTestOperMngr(t, nil, nil, provider)
+
+	op.RefillPrefetchQueue()
+	assert.EqualValues(t, 0, provider.generated.Load())
+	assert.Error(t, op.SetPrefetchOptions(PrefetchOptions{QueueSize: -1}))
+}
+
+func TestPrefetch_ServeCountsShown(t *testing.T) {
+	t.Chdir(t.TempDir())
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	st, err := storage.NewStorage("test.db", logger)
+	require.NoError(t, err)
+	defer st.Close()
+
+	provider := &imageFakeProvider{fakeProvider: fakeProvider{code: "Fake", ready: true}, image: newTestJpeg(t), saveLocal: true}
+	op := newTestOperMngr(t, st, nil, provider)
+	require.NoError(t, op.SetPrefetchOptions(PrefetchOptions{QueueSize: 1}))
+	op.RefillPrefetchQueue()
+	op.CheckPendingOperations()
+	require.Equal(t, 1, op.GetPrefetchQueueLength())
+	original := op.prefetch.items[0].OriginalFileName
+	require.NotEmpty(t, original)
+
+	// Пока изображение ждёт в очереди, показом оно не считается
+	metadata, err := op.GetImageMetadata(filepath.Base(original))
+	require.NoError(t, err)
+	assert.Equal(t, 0, metadata.DisplayCount)
+
+	_, err = op.StartOperation("", "", "")
+	require.NoError(t, err)
+	metadata, err = op.GetImageMetadata(filepath.Base(original))
+	require.NoError(t, err)
+	assert.Equal(t, 1, metadata.DisplayCount)
+
+	op.history.mutex.Lock()
+	defer op.history.mutex.Unlock()
+	shown, ok := op.history.getDevice("").images[filepath.Base(original)]
+	require.True(t, ok)
+	assert.Equal(t, 1, shown.Count)
+}
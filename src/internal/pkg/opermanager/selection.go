@@ -0,0 +1,228 @@
+package opermanager
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	PolicyRandom     = "random"
+	PolicyWeighted   = "weighted"
+	PolicyPriority   = "priority"
+	PolicyRoundRobin = "round_robin"
+)
+
+// SelectionOptions настройки выбора провайдера для автоматических запросов
+type SelectionOptions struct {
+	Policy string `yaml:"policy"`
+	// Weights вес провайдера по коду. Для weighted: не указан - 1, 0 - провайдер не выбирается
+	Weights map[string]int `yaml:"weights"`
+	// Priority коды провайдеров в порядке убывания приоритета. Не указанные идут последними
+	Priority []string `yaml:"priority"`
+}
+
+// SelectionCandidate состояние провайдера в момент выбора
+type SelectionCandidate struct {
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+	Ready    bool   `json:"ready"`
+	Weight   int    `json:"weight"`
+	Priority int    `json:"priority"`
+	Eligible bool   `json:"eligible"`
+	Chosen   bool   `json:"chosen"`
+	Reason   string `json:"reason"`
+}
+
+// SelectionDecision последнее решение о выборе провайдера
+type SelectionDecision struct {
+	Time       time.Time            `json:"time"`
+	Policy     string               `json:"policy"`
+	Chosen     string               `json:"chosen,omitempty"`
+	Candidates []SelectionCandidate `json:"candidates"`
+}
+
+type providerSelector struct {
+	options      SelectionOptions
+	priorities   map[string]int
+	lastCode     string
+	lastDecision *SelectionDecision
+	mutex        sync.Mutex
+}
+
+func newProviderSelector() *providerSelector {
+	return &providerSelector{options: SelectionOptions{Policy: PolicyRandom}}
+}
+
+func (ps *providerSelector) setOptions(options SelectionOptions) error {
+	if options.Policy == "" {
+		options.Policy = PolicyRandom
+	}
+	switch options.Policy {
+	case PolicyRandom, PolicyWeighted, PolicyPriority, PolicyRoundRobin:
+	default:
+		return fmt.Errorf("unknown provider selection policy: %s", options.Policy)
+	}
+	for code, weight := range options.Weights {
+		if weight < 0 {
+			return fmt.Errorf("negative weight for provider %s", code)
+		}
+	}
+
+	priorities := make(map[string]int, len(options.Priority))
+	for idx, code := range options.Priority {
+		priorities[code] = idx + 1
+	}
+
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	ps.options = options
+	ps.priorities = priorities
+	return nil
+}
+
+func (ps *providerSelector) weight(code string) int {
+	if weight, ok := ps.options.Weights[code]; ok {
+		return weight
+	}
+	return 1
+}
+
+// priority чем меньше, тем выше. Не указанные в списке получают наименьший приоритет
+func (ps *providerSelector) priority(code string, total int) int {
+	if priority, ok := ps.priorities[code]; ok {
+		return priority
+	}
+	return len(ps.priorities) + total
+}
+
+// choose выбирает провайдера среди готовых. unavailable возвращает причину, по которой провайдер
+// выбирать нельзя, или пустую строку. Возвращает индекс в providers или -1
+func (ps *providerSelector) choose(providers []*ImageProvider, unavailable func(code string) string) int {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	decision := &SelectionDecision{Time: time.Now(), Policy: ps.options.Policy}
+	eligible := make([]int, 0, len(providers))
+
+	for idx, pr := range providers {
+		candidate := SelectionCandidate{
+			Code:     (*pr).GetImageProviderCode(),
+			Name:     (*pr).GetImageProviderForImageServerName(),
+			Ready:    (*pr).IsReadyForRequest(),
+			Priority: ps.priority((*pr).GetImageProviderCode(), len(providers)),
+			Weight:   ps.weight((*pr).GetImageProviderCode()),
+		}
+
+		if candidate.Ready {
+			if reason := unavailable(candidate.Code); reason != "" {
+				candidate.Ready = false
+				candidate.Reason = reason
+			}
+		}
+
+		switch {
+		case candidate.Reason != "":
+		case !candidate.Ready:
+			candidate.Reason = "provider is not ready"
+		case ps.options.Policy == PolicyWeighted && candidate.Weight == 0:
+			candidate.Reason = "weight is zero"
+		default:
+			candidate.Eligible = true
+			eligible = append(eligible, idx)
+		}
+		decision.Candidates = append(decision.Candidates, candidate)
+	}
+
+	chosen := -1
+	if len(eligible) > 0 {
+		switch ps.options.Policy {
+		case PolicyWeighted:
+			chosen = ps.chooseWeighted(eligible, decision.Candidates)
+		case PolicyPriority:
+			chosen = eligible[0]
+			for _, idx := range eligible[1:] {
+				if decision.Candidates[idx].Priority < decision.Candidates[chosen].Priority {
+					chosen = idx
+				}
+			}
+		case PolicyRoundRobin:
+			// Списки провайдеров у рамок разные, поэтому очередь отсчитывается от кода, а не от индекса
+			last := -1
+			for idx, candidate := range decision.Candidates {
+				if candidate.Code == ps.lastCode {
+					last = idx
+					break
+				}
+			}
+			chosen = eligible[0]
+			for _, idx := range eligible {
+				if idx > last {
+					chosen = idx
+					break
+				}
+			}
+		default:
+			chosen = eligible[rand.Intn(len(eligible))]
+		}
+	}
+
+	for idx := range decision.Candidates {
+		candidate := &decision.Candidates[idx]
+		if idx == chosen {
+			candidate.Chosen = true
+			candidate.Reason = "chosen"
+			decision.Chosen = candidate.Code
+		} else if candidate.Eligible {
+			candidate.Reason = ps.notChosenReason(decision.Candidates, chosen)
+		}
+	}
+
+	if chosen >= 0 {
+		ps.lastCode = decision.Candidates[chosen].Code
+	}
+	ps.lastDecision = decision
+	return chosen
+}
+
+func (ps *providerSelector) chooseWeighted(eligible []int, candidates []SelectionCandidate) int {
+	total := 0
+	for _, idx := range eligible {
+		total += candidates[idx].Weight
+	}
+
+	point := rand.Intn(total)
+	for _, idx := range eligible {
+		point -= candidates[idx].Weight
+		if point < 0 {
+			return idx
+		}
+	}
+	return eligible[len(eligible)-1]
+}
+
+func (ps *providerSelector) notChosenReason(candidates []SelectionCandidate, chosen int) string {
+	switch ps.options.Policy {
+	case PolicyPriority:
+		return fmt.Sprintf("lower priority than %s", candidates[chosen].Code)
+	case PolicyRoundRobin:
+		return fmt.Sprintf("round robin turn of %s", candidates[chosen].Code)
+	case PolicyWeighted:
+		return "not drawn by weight"
+	default:
+		return "not drawn"
+	}
+}
+
+func (ps *providerSelector) getLastDecision() *SelectionDecision {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	if ps.lastDecision == nil {
+		return nil
+	}
+	result := *ps.lastDecision
+	result.Candidates = append([]SelectionCandidate(nil), ps.lastDecision.Candidates...)
+	return &result
+}
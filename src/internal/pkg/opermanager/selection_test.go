@@ -0,0 +1,150 @@
+package opermanager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProvider struct {
+	code  string
+	ready bool
+}
+
+func (fp *fakeProvider) Start() error                               { return nil }
+func (fp *fakeProvider) GetImageProviderForImageServerName() string { return fp.code }
+func (fp *fakeProvider) GetImageProviderCode() string               { return fp.code }
+func (fp *fakeProvider) Generate(bool) (string, error)              { return "", nil }
+func (fp *fakeProvider) GenerateWithPrompt(string, string, bool) (string, error) {
+	return "", nil
+}
+func (fp *fakeProvider) GetImageSlice(string) (bool, []byte, error) { return false, nil, nil }
+func (fp *fakeProvider) IsReadyForRequest() bool                    { return fp.ready }
+func (fp *fakeProvider) SetImageParameters(*ImageParameters) error  { return nil }
+func (fp *fakeProvider) GetProperties() *ProviderProperties         { return &ProviderProperties{} }
+
+func newFakeProviders(providers ...*fakeProvider) []*ImageProvider {
+	result := make([]*ImageProvider, 0, len(providers))
+	for _, fp := range providers {
+		ip := (ImageProvider)(fp)
+		result = append(result, &ip)
+	}
+	return result
+}
+
+func alwaysAvailable(string) string { return "" }
+
+func TestProviderSelector_RandomSkipsNotReady(t *testing.T) {
+	providers := newFakeProviders(&fakeProvider{code: "A"}, &fakeProvider{code: "B"}, &fakeProvider{code: "C", ready: true})
+	ps := newProviderSelector()
+
+	for i := 0; i < 50; i++ {
+		require.Equal(t, 2, ps.choose(providers, alwaysAvailable))
+	}
+
+	decision := ps.getLastDecision()
+	assert.Equal(t, "C", decision.Chosen)
+	assert.False(t, decision.Candidates[0].Eligible)
+	assert.Equal(t, "provider is not ready", decision.Candidates[0].Reason)
+}
+
+func TestProviderSelector_NoReady(t *testing.T) {
+	ps := newProviderSelector()
+
+	assert.Equal(t, -1, ps.choose(newFakeProviders(&fakeProvider{code: "A"}), alwaysAvailable))
+	assert.Empty(t, ps.getLastDecision().Chosen)
+}
+
+func TestProviderSelector_Weighted(t *testing.T) {
+	providers := newFakeProviders(&fakeProvider{code: "A", ready: true}, &fakeProvider{code: "B", ready: true}, &fakeProvider{code: "C", ready: true})
+	ps := newProviderSelector()
+	require.NoError(t, ps.setOptions(SelectionOptions{Policy: PolicyWeighted, Weights: map[string]int{"A": 3, "C": 0}}))
+
+	counts := make(map[int]int)
+	for i := 0; i < 4000; i++ {
+		counts[ps.choose(providers, alwaysAvailable)]++
+	}
+	assert.Zero(t, counts[2], "provider with zero weight was chosen")
+	assert.Greater(t, counts[0], 2*counts[1], "weights are not respected")
+	assert.Equal(t, "weight is zero", ps.getLastDecision().Candidates[2].Reason)
+}
+
+func TestProviderSelector_PriorityFallback(t *testing.T) {
+	a := &fakeProvider{code: "A", ready: true}
+	b := &fakeProvider{code: "B", ready: true}
+	c := &fakeProvider{code: "C", ready: true}
+	providers := newFakeProviders(a, b, c)
+	ps := newProviderSelector()
+	require.NoError(t, ps.setOptions(SelectionOptions{Policy: PolicyPriority, Priority: []string{"B", "A"}}))
+
+	assert.Equal(t, 1, ps.choose(providers, alwaysAvailable))
+	assert.Equal(t, "lower priority than B", ps.getLastDecision().Candidates[0].Reason)
+
+	b.ready = false
+	assert.Equal(t, 0, ps.choose(providers, alwaysAvailable))
+
+	a.ready = false
+	assert.Equal(t, 2, ps.choose(providers, alwaysAvailable))
+}
+
+func TestProviderSelector_RoundRobin(t *testing.T) {
+	b := &fakeProvider{code: "B", ready: true}
+	providers := newFakeProviders(&fakeProvider{code: "A", ready: true}, b, &fakeProvider{code: "C", ready: true})
+	ps := newProviderSelector()
+	require.NoError(t, ps.setOptions(SelectionOptions{Policy: PolicyRoundRobin}))
+
+	var got []int
+	for i := 0; i < 4; i++ {
+		got = append(got, ps.choose(providers, alwaysAvailable))
+	}
+	assert.Equal(t, []int{0, 1, 2, 0}, got)
+
+	// B не готов - после A идёт C
+	b.ready = false
+	assert.Equal(t, 2, ps.choose(providers, alwaysAvailable))
+}
+
+func TestProviderSelector_RoundRobinDeviceProviders(t *testing.T) {
+	a := &fakeProvider{code: "A", ready: true}
+	b := &fakeProvider{code: "B", ready: true}
+	c := &fakeProvider{code: "C", ready: true}
+	ps := newProviderSelector()
+	require.NoError(t, ps.setOptions(SelectionOptions{Policy: PolicyRoundRobin}))
+
+	all := newFakeProviders(a, b, c)
+	require.Equal(t, 0, ps.choose(all, alwaysAvailable))
+	require.Equal(t, 1, ps.choose(all, alwaysAvailable))
+
+	// У рамки без A очередь продолжается после B, а не после второго элемента её списка
+	device := newFakeProviders(b, c)
+	assert.Equal(t, 1, ps.choose(device, alwaysAvailable))
+	assert.Equal(t, 0, ps.choose(all, alwaysAvailable))
+}
+
+func TestProviderSelector_Unavailable(t *testing.T) {
+	providers := newFakeProviders(&fakeProvider{code: "A", ready: true}, &fakeProvider{code: "B", ready: true})
+	ps := newProviderSelector()
+
+	unavailable := func(code string) string {
+		if code == "A" {
+			return "circuit is open"
+		}
+		return ""
+	}
+	for i := 0; i < 20; i++ {
+		require.Equal(t, 1, ps.choose(providers, unavailable))
+	}
+
+	candidate := ps.getLastDecision().Candidates[0]
+	assert.False(t, candidate.Ready)
+	assert.False(t, candidate.Eligible)
+	assert.Equal(t, "circuit is open", candidate.Reason)
+}
+
+func TestProviderSelector_InvalidOptions(t *testing.T) {
+	ps := newProviderSelector()
+
+	assert.Error(t, ps.setOptions(SelectionOptions{Policy: "unknown"}))
+	assert.Error(t, ps.setOptions(SelectionOptions{Policy: PolicyWeighted, Weights: map[string]int{"A": -1}}))
+}
@@ -0,0 +1,188 @@
+package opermanager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const SHOW_NEXT_BUCKET = "show_next"
+
+// showNextKey все очереди хранятся одной записью: их мало и они короткие
+const showNextKey = "queues"
+
+// MAX_SHOW_REPEAT сколько раз подряд можно показать одно изображение из очереди
+const MAX_SHOW_REPEAT = 100
+
+var ErrInvalidShowNext = errors.New("invalid show next request")
+
+// ShowNextItem изображение, которое рамка покажет вне очереди
+type ShowNextItem struct {
+	FileName string `json:"file_name"`
+	// Remaining сколько ещё запросов подряд показывать изображение
+	Remaining int `json:"remaining"`
+}
+
+// showNextQueue очереди "показать следующим" по рамкам. Ключ - идентификатор рамки, пустой у рамки по умолчанию
+type showNextQueue struct {
+	items map[string][]*ShowNextItem
+	mutex sync.Mutex
+}
+
+func newShowNextQueue() *showNextQueue {
+	return &showNextQueue{items: make(map[string][]*ShowNextItem)}
+}
+
+// copyItems копия очереди рамки для выдачи наружу
+func (sq *showNextQueue) copyItems(deviceId string) []ShowNextItem {
+	result := make([]ShowNextItem, 0, len(sq.items[deviceId]))
+	for _, item := range sq.items[deviceId] {
+		result = append(result, *item)
+	}
+	return result
+}
+
+// EnqueueShowNext ставит изображения из каталога оригиналов в очередь рамки.
+// Каждое изображение будет показано repeat запросов подряд. Возвращает очередь рамки
+func (op *OperMngr) EnqueueShowNext(deviceId string, fileNames []string, repeat int) ([]ShowNextItem, error) {
+	err := op.ValidateShowNext(deviceId, repeat)
+	if err != nil {
+		return nil, err
+	}
+	dev, err := op.getDevice(deviceId)
+	if err != nil {
+		return nil, err
+	}
+	if len(fileNames) == 0 {
+		return nil, fmt.Errorf("%w: no images", ErrInvalidShowNext)
+	}
+	for _, fileName := range fileNames {
+		if _, err := op.GetImagePath(fileName); err != nil {
+			return nil, err
+		}
+	}
+
+	op.showNext.mutex.Lock()
+	defer op.showNext.mutex.Unlock()
+	for _, fileName := range fileNames {
+		op.showNext.items[dev.id] = append(op.showNext.items[dev.id], &ShowNextItem{FileName: fileName, Remaining: repeat})
+	}
+	op.saveShowNextQueues()
+	op.logger.Info("Images queued to show next", "device", dev.id, "images", len(fileNames), "repeat", repeat)
+	return op.showNext.copyItems(dev.id), nil
+}
+
+// ValidateShowNext проверяет рамку и количество повторов. Позволяет отклонить запрос до загрузки изображений
+func (op *OperMngr) ValidateShowNext(deviceId string, repeat int) error {
+	if _, err := op.getDevice(deviceId); err != nil {
+		return err
+	}
+	if repeat <= 0 || repeat > MAX_SHOW_REPEAT {
+		return fmt.Errorf("%w: repeat must be from 1 to %d", ErrInvalidShowNext, MAX_SHOW_REPEAT)
+	}
+	return nil
+}
+
+// GetShowNextQueue очередь "показать следующим" рамки
+func (op *OperMngr) GetShowNextQueue(deviceId string) ([]ShowNextItem, error) {
+	dev, err := op.getDevice(deviceId)
+	if err != nil {
+		return nil, err
+	}
+	op.showNext.mutex.Lock()
+	defer op.showNext.mutex.Unlock()
+	return op.showNext.copyItems(dev.id), nil
+}
+
+// ClearShowNextQueue очищает очередь рамки
+func (op *OperMngr) ClearShowNextQueue(deviceId string) error {
+	dev, err := op.getDevice(deviceId)
+	if err != nil {
+		return err
+	}
+	op.showNext.mutex.Lock()
+	defer op.showNext.mutex.Unlock()
+	delete(op.showNext.items, dev.id)
+	op.saveShowNextQueues()
+	op.logger.Info("Show next queue cleared", "device", dev.id)
+	return nil
+}
+
+// nextShowNextFile следующий файл очереди рамки. Пустая строка - очередь пуста.
+// Изображения, удалённые из каталога, пропускаются
+func (op *OperMngr) nextShowNextFile(dev *device) string {
+	op.showNext.mutex.Lock()
+	defer op.showNext.mutex.Unlock()
+
+	items := op.showNext.items[dev.id]
+	if len(items) == 0 {
+		return ""
+	}
+	defer op.saveShowNextQueues()
+
+	for len(items) > 0 {
+		item := items[0]
+		path := filepath.Join(op.dirManager.GetDirectoryPath(), item.FileName)
+		_, err := os.Stat(path)
+
+		item.Remaining--
+		if item.Remaining <= 0 || err != nil {
+			items = items[1:]
+		}
+		if err != nil {
+			op.logger.Warn("Skip missing image from show next queue", "device", dev.id, "file", item.FileName, "error", err)
+			continue
+		}
+
+		op.setShowNextItems(dev.id, items)
+		return path
+	}
+	op.setShowNextItems(dev.id, items)
+	return ""
+}
+
+func (op *OperMngr) setShowNextItems(deviceId string, items []*ShowNextItem) {
+	if len(items) == 0 {
+		delete(op.showNext.items, deviceId)
+		return
+	}
+	op.showNext.items[deviceId] = items
+}
+
+// saveShowNextQueues вызывается под блокировкой очереди
+func (op *OperMngr) saveShowNextQueues() {
+	if op.storage == nil {
+		return
+	}
+	err := op.storage.Put(SHOW_NEXT_BUCKET, showNextKey, op.showNext.items)
+	if err != nil {
+		op.logger.Error("Can not save show next queues", "error", err)
+	}
+}
+
+// restoreShowNextQueues загружает очереди из хранилища. Очереди удалённых из настроек рамок отбрасываются
+func (op *OperMngr) restoreShowNextQueues() error {
+	if op.storage == nil {
+		return nil
+	}
+
+	items := make(map[string][]*ShowNextItem)
+	_, err := op.storage.Get(SHOW_NEXT_BUCKET, showNextKey, &items)
+	if err != nil {
+		return err
+	}
+
+	op.showNext.mutex.Lock()
+	defer op.showNext.mutex.Unlock()
+	for deviceId, deviceItems := range items {
+		if _, err := op.getDevice(deviceId); err != nil {
+			op.logger.Warn("Drop show next queue of unknown device", "device", deviceId)
+			continue
+		}
+		op.showNext.items[deviceId] = deviceItems
+	}
+	op.logger.Info("Show next queues restored", "devices", len(op.showNext.items))
+	return nil
+}
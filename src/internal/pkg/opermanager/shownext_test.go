@@ -0,0 +1,120 @@
+package opermanager
+
+import (
+	"imgserver/internal/pkg/imageprocessor"
+	"imgserver/internal/pkg/storage"
+	"imgserver/internal/pkg/timerange"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShowNext_ServedBeforeProvider(t *testing.T) {
+	t.Chdir(t.TempDir())
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	st, err := storage.NewStorage("test.db", logger)
+	require.NoError(t, err)
+	defer st.Close()
+
+	provider := &imageFakeProvider{fakeProvider: fakeProvider{code: "Fake", ready: true}, image: newTestJpeg(t)}
+	wholeDay := func(black bool) []*SleepTime {
+		return []*SleepTime{{TimeRange: &timerange.TimeRange{Start: "00:00:00", End: "23:59:59"}, BlackImageMode: black}}
+	}
+	op := newTestOperMngr(t, st, func(op *OperMngr) {
+		size := imageprocessor.ImageParameters{ImageWeight: 40, ImageHeight: 30}
+		require.NoError(t, op.AddDevice("bedroom", DeviceParameters{ImageParameters: size, SleepTimes: wholeDay(true)}))
+		require.NoError(t, op.AddDevice("hall", DeviceParameters{ImageParameters: size, SleepTimes: wholeDay(false)}))
+	}, provider)
+
+	names := []string{"f1-orig.jpeg", "f2-orig.jpeg"}
+	for _, name := range names {
+		require.NoError(t, os.WriteFile(filepath.Join("images", name), provider.image, 0644))
+	}
+	require.NoError(t, op.dirManager.ReadFiles())
+
+	_, err = op.EnqueueShowNext("", names[:1], 2)
+	require.NoError(t, err)
+	queue, err := op.EnqueueShowNext("", names[1:], 1)
+	require.NoError(t, err)
+	assert.Equal(t, []ShowNextItem{{FileName: names[0], Remaining: 2}, {FileName: names[1], Remaining: 1}}, queue)
+
+	// Первое изображение показывается два запроса подряд, второе - один. Провайдер не вызывается
+	for i := 0; i < 3; i++ {
+		_, err = op.StartOperation("auto", "", "")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(0), provider.generated.Load())
+	first, err := op.GetImageMetadata(names[0])
+	require.NoError(t, err)
+	assert.Equal(t, 2, first.DisplayCount)
+	second, err := op.GetImageMetadata(names[1])
+	require.NoError(t, err)
+	assert.Equal(t, 1, second.DisplayCount)
+
+	// Очередь пуста, дальше как обычно
+	queue, err = op.GetShowNextQueue("")
+	require.NoError(t, err)
+	assert.Empty(t, queue)
+	_, err = op.StartOperation("auto", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), provider.generated.Load())
+
+	// Чёрный режим сна важнее очереди
+	_, err = op.EnqueueShowNext("bedroom", names[:1], 1)
+	require.NoError(t, err)
+	_, err = op.StartDeviceOperation("bedroom", "auto", "", "")
+	require.NoError(t, err)
+	queue, err = op.GetShowNextQueue("bedroom")
+	require.NoError(t, err)
+	assert.Len(t, queue, 1)
+
+	// Обычный сон очередь не останавливает
+	_, err = op.EnqueueShowNext("hall", names[1:], 1)
+	require.NoError(t, err)
+	_, err = op.StartDeviceOperation("hall", "auto", "", "")
+	require.NoError(t, err)
+	second, err = op.GetImageMetadata(names[1])
+	require.NoError(t, err)
+	assert.Equal(t, 2, second.DisplayCount)
+
+	// Очередь переживает рестарт
+	restored := newTestOperMngr(t, st, func(op *OperMngr) {
+		size := imageprocessor.ImageParameters{ImageWeight: 40, ImageHeight: 30}
+		require.NoError(t, op.AddDevice("bedroom", DeviceParameters{ImageParameters: size}))
+	}, provider)
+	queue, err = restored.GetShowNextQueue("bedroom")
+	require.NoError(t, err)
+	assert.Equal(t, []ShowNextItem{{FileName: names[0], Remaining: 1}}, queue)
+	require.NoError(t, restored.ClearShowNextQueue("bedroom"))
+	queue, err = restored.GetShowNextQueue("bedroom")
+	require.NoError(t, err)
+	assert.Empty(t, queue)
+}
+
+func TestShowNext_InvalidRequest(t *testing.T) {
+	t.Chdir(t.TempDir())
+	provider := &imageFakeProvider{fakeProvider: fakeProvider{code: "Fake", ready: true}, image: newTestJpeg(t)}
+	op := newTestOperMngr(t, nil, nil, provider)
+
+	require.NoError(t, os.WriteFile(filepath.Join("images", "f1-orig.jpeg"), provider.image, 0644))
+	require.NoError(t, op.dirManager.ReadFiles())
+
+	_, err := op.EnqueueShowNext("", nil, 1)
+	assert.ErrorIs(t, err, ErrInvalidShowNext)
+	_, err = op.EnqueueShowNext("", []string{"f1-orig.jpeg"}, 0)
+	assert.ErrorIs(t, err, ErrInvalidShowNext)
+	_, err = op.EnqueueShowNext("", []string{"f1-orig.jpeg"}, MAX_SHOW_REPEAT+1)
+	assert.ErrorIs(t, err, ErrInvalidShowNext)
+	_, err = op.EnqueueShowNext("", []string{"unknown.jpeg"}, 1)
+	assert.ErrorIs(t, err, ErrImageNotFound)
+	_, err = op.EnqueueShowNext("kitchen", []string{"f1-orig.jpeg"}, 1)
+	assert.ErrorIs(t, err, ErrUnknownDevice)
+
+	assert.NoError(t, op.ValidateShowNext("", 1))
+	assert.ErrorIs(t, op.ValidateShowNext("", 0), ErrInvalidShowNext)
+	assert.ErrorIs(t, op.ValidateShowNext("kitchen", 1), ErrUnknownDevice)
+}
@@ -0,0 +1,89 @@
+package opermanager
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// MAX_UPLOAD_SIDE больше этого размера загруженные изображения уменьшаются, чтобы не хранить снимки телефона в полном разрешении
+const MAX_UPLOAD_SIDE = 4096
+
+// UploadError ошибка одного изображения из пачки загрузки. Index - номер изображения в пачке, с нуля
+type UploadError struct {
+	Index int
+	Err   error
+}
+
+func (e *UploadError) Error() string {
+	return fmt.Sprintf("image %d: %v", e.Index+1, e.Err)
+}
+
+func (e *UploadError) Unwrap() error {
+	return e.Err
+}
+
+// AddUploadedImage сохраняет загруженное пользователем изображение в каталог оригиналов.
+// Поддерживаются JPEG, PNG, WebP и GIF (первый кадр)
+func (op *OperMngr) AddUploadedImage(imageData []byte) (*ImageMetadata, error) {
+	records, err := op.AddUploadedImages([][]byte{imageData})
+	if err != nil {
+		return nil, errors.Unwrap(err)
+	}
+	return &records[0], nil
+}
+
+// AddUploadedImages сохраняет пачку загруженных изображений. Сначала все изображения декодируются,
+// и только потом записываются. Пачка сохраняется целиком или не сохраняется: при ошибке уже
+// записанные файлы удаляются. Ошибка - *UploadError с номером изображения
+func (op *OperMngr) AddUploadedImages(images [][]byte) ([]ImageMetadata, error) {
+	normalized := make([][]byte, 0, len(images))
+	for idx, imageData := range images {
+		data, err := op.defaultDevice.ipr.NormalizeImage(imageData, MAX_UPLOAD_SIDE)
+		if err != nil {
+			return nil, &UploadError{Index: idx, Err: err}
+		}
+		normalized = append(normalized, data)
+	}
+
+	records := make([]ImageMetadata, 0, len(normalized))
+	for idx, data := range normalized {
+		record, err := op.storeUploadedImage(data)
+		if err != nil {
+			for _, stored := range records {
+				if err := op.DeleteImage(stored.FileName); err != nil {
+					op.logger.Warn("Can not remove uploaded image", "file", stored.FileName, "error", err)
+				}
+			}
+			return nil, &UploadError{Index: idx, Err: err}
+		}
+		records = append(records, *record)
+	}
+	return records, nil
+}
+
+func (op *OperMngr) storeUploadedImage(normalized []byte) (*ImageMetadata, error) {
+	fileName := op.generateFileName(op.generateId())
+	err := writeFile(fileName, normalized)
+	if err != nil {
+		return nil, err
+	}
+
+	record := ImageMetadata{
+		FileName:  filepath.Base(fileName),
+		Source:    ImageSourceUploaded,
+		Size:      int64(len(normalized)),
+		CreatedAt: time.Now(),
+	}
+	op.describeImage(&record, normalized)
+	if op.storage != nil {
+		op.index.mutex.Lock()
+		op.putImageRecord(&record)
+		op.index.mutex.Unlock()
+	}
+	op.dirManager.AddFile(fileName)
+
+	op.logger.Info("Image uploaded", "file", record.FileName, "width", record.Width, "height", record.Height)
+	return &record, nil
+}
@@ -0,0 +1,472 @@
+package pluginprovider
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"imgserver/internal/pkg/actioner"
+	"imgserver/internal/pkg/imageprocessor"
+	"imgserver/internal/pkg/opermanager"
+	"imgserver/internal/pkg/promptmanager"
+	"imgserver/internal/pkg/timerange"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeout     = 30
+	restartDelayMin    = 1 * time.Second
+	restartDelayMax    = 1 * time.Minute
+	stableRunDuration  = 1 * time.Minute
+	readyProbeInterval = 5 * time.Second
+)
+
+var _ opermanager.ImageProvider = (*PluginProvider)(nil)
+
+type PluginSleepTime struct {
+	TimeRange *timerange.TimeRange `yaml:"time_range"`
+}
+
+type PluginOptions struct {
+	Code                   string            `yaml:"code"`
+	Name                   string            `yaml:"name"`
+	Command                string            `yaml:"command"`
+	Args                   []string          `yaml:"args"`
+	Env                    map[string]string `yaml:"env"`
+	TimeoutSeconds         int               `yaml:"timeout_seconds"`
+	ImageGenerateThreshold int               `yaml:"image_generate_threshold"`
+	SleepTimes             []PluginSleepTime `yaml:"sleep_time"`
+}
+
+// process запущенный экземпляр плагина
+type process struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	responses chan response
+	startTime time.Time
+}
+
+type PluginProvider struct {
+	logger          *slog.Logger
+	options         *PluginOptions
+	imageParameters *opermanager.ImageParameters
+	promptManager   *promptmanager.PromptManager
+	actioner        *actioner.Actioner
+	ipr             *imageprocessor.Ipr
+	properties      *opermanager.ProviderProperties
+	timeout         time.Duration
+
+	// callMutex допускает только один незавершённый запрос к плагину
+	callMutex sync.Mutex
+	nextId    int64
+
+	mutex   sync.Mutex
+	proc    *process
+	started bool
+	stopped bool
+
+	// Готовность плагина проверяется в фоне: выбор провайдера не должен ждать ответа плагина
+	ready        bool
+	readyChecked time.Time
+	probing      bool
+}
+
+// NewPluginProvider запускает процесс плагина и запрашивает его свойства
+func NewPluginProvider(imageParameters imageprocessor.ImageParameters, promptManager *promptmanager.PromptManager, logger *slog.Logger, options *PluginOptions) (*PluginProvider, error) {
+	if options.Code == "" {
+		return nil, fmt.Errorf("plugin code is empty")
+	}
+	if options.Command == "" {
+		return nil, fmt.Errorf("plugin %s: command is empty", options.Code)
+	}
+	if options.TimeoutSeconds <= 0 {
+		options.TimeoutSeconds = defaultTimeout
+	}
+
+	pp := &PluginProvider{
+		logger:        logger.With("plugin", options.Code),
+		options:       options,
+		promptManager: promptManager,
+		actioner:      actioner.NewActioner(options.ImageGenerateThreshold, time.Minute),
+		ipr:           imageprocessor.NewIpr(imageParameters, logger),
+		timeout:       time.Duration(options.TimeoutSeconds) * time.Second,
+	}
+
+	proc, err := pp.launch()
+	if err != nil {
+		return nil, err
+	}
+	go pp.supervise(proc)
+
+	var properties PropertiesResult
+	if err := pp.call(MethodProperties, nil, &properties); err != nil {
+		pp.Close()
+		return nil, fmt.Errorf("plugin %s: can not get properties: %w", options.Code, err)
+	}
+	pp.properties = &opermanager.ProviderProperties{
+		IsCanWorkWithPrompt:  properties.CanWorkWithPrompt,
+		IsNeedSaveLocalFiles: properties.NeedSaveLocalFiles,
+	}
+
+	return pp, nil
+}
+
+func (pp *PluginProvider) Start() error {
+	if err := pp.sendStart(); err != nil {
+		return fmt.Errorf("plugin %s: start error: %w", pp.options.Code, err)
+	}
+
+	pp.mutex.Lock()
+	pp.started = true
+	pp.probing = true
+	pp.mutex.Unlock()
+	pp.probeReady()
+	return nil
+}
+
+// Close останавливает плагин без перезапуска
+func (pp *PluginProvider) Close() {
+	pp.mutex.Lock()
+	pp.stopped = true
+	proc := pp.proc
+	pp.mutex.Unlock()
+
+	if proc != nil {
+		_ = proc.stdin.Close()
+		_ = proc.cmd.Process.Kill()
+	}
+}
+
+func (pp *PluginProvider) SetImageParameters(parameters *opermanager.ImageParameters) error {
+	pp.imageParameters = parameters
+	return nil
+}
+
+func (pp *PluginProvider) GetImageProviderForImageServerName() string {
+	if pp.options.Name != "" {
+		return pp.options.Name
+	}
+	return pp.options.Code
+}
+
+func (pp *PluginProvider) GetImageProviderCode() string {
+	return pp.options.Code
+}
+
+func (pp *PluginProvider) GetProperties() *opermanager.ProviderProperties {
+	return pp.properties
+}
+
+func (pp *PluginProvider) Generate(isDirectCall bool) (string, error) {
+	params := GenerateParams{IsDirectCall: isDirectCall}
+	if pp.promptManager != nil {
+		prompt, err := pp.promptManager.GetRandomPrompt()
+		if err != nil {
+			pp.logger.Warn("Error when get prompt", "error", err.Error())
+		} else {
+			params.Prompt = prompt.Prompt
+			if prompt.Negative != nil {
+				params.Negative = *prompt.Negative
+			}
+		}
+	}
+	return pp.generate(MethodGenerate, params)
+}
+
+func (pp *PluginProvider) GenerateWithPrompt(prompt string, negative string, isDirectCall bool) (string, error) {
+	if prompt == "" {
+		return "", fmt.Errorf("prompt is empty")
+	}
+	return pp.generate(MethodGenerateWithPrompt, GenerateParams{Prompt: prompt, Negative: negative, IsDirectCall: isDirectCall})
+}
+
+func (pp *PluginProvider) GetImageSlice(operationId string) (bool, []byte, error) {
+	var result PollResult
+	if err := pp.call(MethodPoll, PollParams{Id: operationId}, &result); err != nil {
+		return false, nil, fmt.Errorf("error when poll plugin: %v", err)
+	}
+
+	if !result.Done {
+		return false, nil, nil
+	}
+	if result.Error != "" {
+		return true, nil, fmt.Errorf("plugin return error: %s", result.Error)
+	}
+	if result.Image == "" {
+		return true, nil, fmt.Errorf("plugin return empty image")
+	}
+
+	jpg, err := pp.ipr.ConvertBase64ToJpg(result.Image)
+	if err != nil {
+		return true, nil, fmt.Errorf("error image processing: %v", err)
+	}
+	return true, jpg, nil
+}
+
+func (pp *PluginProvider) IsReadyForRequest() bool {
+	if !pp.actioner.ThresholdOut(time.Now()) {
+		// Провайдер вызывался недавно. Он не готов к новому вызову.
+		return false
+	}
+
+	now := time.Now()
+	for _, st := range pp.options.SleepTimes {
+		inclusive, err := st.TimeRange.IsWithinRangeInclusive(now)
+		if err != nil {
+			pp.logger.Error("Get time range error", "error", err)
+		}
+		if inclusive {
+			return false
+		}
+	}
+
+	pp.mutex.Lock()
+	defer pp.mutex.Unlock()
+	if !pp.probing && time.Since(pp.readyChecked) >= readyProbeInterval {
+		pp.probing = true
+		go pp.probeReady()
+	}
+	return pp.ready
+}
+
+// probeReady запрашивает готовность у плагина и запоминает ответ. Вызывающий выставляет probing
+func (pp *PluginProvider) probeReady() {
+	var result IsReadyResult
+	err := pp.call(MethodIsReady, nil, &result)
+	if err != nil {
+		pp.logger.Warn("Plugin is_ready error", "error", err)
+	}
+
+	pp.mutex.Lock()
+	defer pp.mutex.Unlock()
+	pp.ready = err == nil && result.Ready
+	pp.readyChecked = time.Now()
+	pp.probing = false
+}
+
+// markNotReady плагин перестал отвечать. Готовность будет проверена заново при следующем выборе провайдера
+func (pp *PluginProvider) markNotReady() {
+	pp.mutex.Lock()
+	defer pp.mutex.Unlock()
+	pp.ready = false
+	pp.readyChecked = time.Time{}
+}
+
+func (pp *PluginProvider) generate(method string, params GenerateParams) (string, error) {
+	var result GenerateResult
+	if err := pp.call(method, params, &result); err != nil {
+		resultError := fmt.Errorf("error generate image: %v", err)
+		pp.logger.Error(resultError.Error())
+		return "", resultError
+	}
+	if result.Id == "" {
+		return "", fmt.Errorf("plugin return empty operation id")
+	}
+
+	if !params.IsDirectCall {
+		pp.actioner.SetLastCallTime(time.Now())
+	}
+	return result.Id, nil
+}
+
+func (pp *PluginProvider) sendStart() error {
+	params := StartParams{Code: pp.options.Code}
+	if pp.imageParameters != nil {
+		params.Width = pp.imageParameters.Weight
+		params.Height = pp.imageParameters.Height
+	}
+	return pp.call(MethodStart, params, nil)
+}
+
+// call отправляет запрос и ждёт ответ с тем же id. По таймауту процесс убивается,
+// так как протокол рассинхронизирован, и супервизор его перезапустит
+func (pp *PluginProvider) call(method string, params interface{}, result interface{}) error {
+	pp.callMutex.Lock()
+	defer pp.callMutex.Unlock()
+
+	pp.mutex.Lock()
+	proc := pp.proc
+	pp.mutex.Unlock()
+	if proc == nil {
+		pp.markNotReady()
+		return fmt.Errorf("plugin is not running")
+	}
+
+	pp.nextId++
+	id := pp.nextId
+	data, err := json.Marshal(request{Id: id, Method: method, Params: params})
+	if err != nil {
+		return fmt.Errorf("error when data marshalling: %v", err)
+	}
+
+	timer := time.NewTimer(pp.timeout)
+	defer timer.Stop()
+
+	// Плагин может перестать читать stdin, тогда запись заблокируется до его остановки
+	written := make(chan error, 1)
+	go func() {
+		_, err := proc.stdin.Write(append(data, '\n'))
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			pp.markNotReady()
+			return fmt.Errorf("error when write to plugin: %v", err)
+		}
+	case <-timer.C:
+		pp.logger.Error("Plugin write timeout. Kill process", "method", method, "timeout", pp.timeout)
+		pp.markNotReady()
+		_ = proc.cmd.Process.Kill()
+		return fmt.Errorf("plugin call %s timeout", method)
+	}
+
+	for {
+		select {
+		case resp, ok := <-proc.responses:
+			if !ok {
+				pp.markNotReady()
+				return fmt.Errorf("plugin exited")
+			}
+			if resp.Id != id {
+				pp.logger.Warn("Skip stale plugin response", "id", resp.Id, "expected", id)
+				continue
+			}
+			if resp.Error != "" {
+				return fmt.Errorf("%s", resp.Error)
+			}
+			if result != nil && len(resp.Result) > 0 {
+				if err := json.Unmarshal(resp.Result, result); err != nil {
+					return fmt.Errorf("error when parse plugin result: %v", err)
+				}
+			}
+			return nil
+		case <-timer.C:
+			pp.logger.Error("Plugin call timeout. Kill process", "method", method, "timeout", pp.timeout)
+			pp.markNotReady()
+			_ = proc.cmd.Process.Kill()
+			return fmt.Errorf("plugin call %s timeout", method)
+		}
+	}
+}
+
+func (pp *PluginProvider) launch() (*process, error) {
+	cmd := exec.Command(pp.options.Command, pp.options.Args...)
+	cmd.Env = os.Environ()
+	for key, value := range pp.options.Env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("can not open plugin stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("can not open plugin stdout: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("can not open plugin stderr: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("can not start plugin '%s': %w", pp.options.Command, err)
+	}
+	pp.logger.Info("Plugin process started", "pid", cmd.Process.Pid)
+
+	proc := &process{cmd: cmd, stdin: stdin, responses: make(chan response, 16), startTime: time.Now()}
+	go pp.readResponses(stdout, proc.responses)
+	go pp.readStderr(stderr)
+
+	pp.mutex.Lock()
+	pp.proc = proc
+	pp.mutex.Unlock()
+	return proc, nil
+}
+
+// supervise ждёт завершения процесса и перезапускает его с растущей задержкой
+func (pp *PluginProvider) supervise(proc *process) {
+	delay := restartDelayMin
+	for {
+		err := proc.cmd.Wait()
+
+		pp.mutex.Lock()
+		pp.proc = nil
+		stopped := pp.stopped
+		pp.mutex.Unlock()
+		if stopped {
+			pp.logger.Info("Plugin process stopped")
+			return
+		}
+		pp.logger.Error("Plugin process exited", "error", err)
+
+		if time.Since(proc.startTime) > stableRunDuration {
+			delay = restartDelayMin
+		}
+
+		for {
+			time.Sleep(delay)
+			delay = min(delay*2, restartDelayMax)
+
+			pp.mutex.Lock()
+			stopped = pp.stopped
+			pp.mutex.Unlock()
+			if stopped {
+				return
+			}
+
+			newProc, err := pp.launch()
+			if err != nil {
+				pp.logger.Error("Can not restart plugin", "error", err)
+				continue
+			}
+			proc = newProc
+			break
+		}
+
+		pp.mutex.Lock()
+		started := pp.started
+		pp.mutex.Unlock()
+		if started {
+			go func() {
+				if err := pp.sendStart(); err != nil {
+					pp.logger.Error("Plugin start after restart error", "error", err)
+				}
+				pp.mutex.Lock()
+				probing := pp.probing
+				pp.probing = true
+				pp.mutex.Unlock()
+				if !probing {
+					pp.probeReady()
+				}
+			}()
+		}
+	}
+}
+
+func (pp *PluginProvider) readResponses(stdout io.Reader, responses chan<- response) {
+	defer close(responses)
+	scanner := bufio.NewScanner(stdout)
+	// Изображение в base64 может быть большим
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var resp response
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			pp.logger.Warn("Skip invalid plugin output", "line", scanner.Text(), "error", err)
+			continue
+		}
+		responses <- resp
+	}
+}
+
+func (pp *PluginProvider) readStderr(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		pp.logger.Info("Plugin stderr", "line", scanner.Text())
+	}
+}
@@ -0,0 +1,194 @@
+package pluginprovider
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"imgserver/internal/pkg/imageprocessor"
+	"imgserver/internal/pkg/opermanager"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const helperEnv = "IMGSERVER_TEST_PLUGIN"
+
+// TestMain Тестовый бинарник сам выступает плагином, если задана переменная окружения
+func TestMain(m *testing.M) {
+	if os.Getenv(helperEnv) == "1" {
+		runFakePlugin()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func runFakePlugin() {
+	buf := new(bytes.Buffer)
+	_ = png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	imageBase64 := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	fmt.Fprintln(os.Stderr, "fake plugin started")
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	writer := json.NewEncoder(os.Stdout)
+	hangReady := false
+	for scanner.Scan() {
+		var req struct {
+			Id     int64           `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		_ = json.Unmarshal(scanner.Bytes(), &req)
+
+		var result interface{}
+		switch req.Method {
+		case MethodProperties:
+			result = PropertiesResult{CanWorkWithPrompt: true, NeedSaveLocalFiles: true}
+		case MethodIsReady:
+			if hangReady {
+				time.Sleep(time.Hour)
+			}
+			result = IsReadyResult{Ready: true}
+		case MethodStart:
+			result = IsReadyResult{Ready: true}
+		case MethodGenerate, MethodGenerateWithPrompt:
+			var params GenerateParams
+			_ = json.Unmarshal(req.Params, &params)
+			switch params.Prompt {
+			case "crash":
+				os.Exit(3)
+			case "hang":
+				time.Sleep(time.Hour)
+			case "hang_ready":
+				hangReady = true
+			}
+			result = GenerateResult{Id: "op-" + params.Prompt + "-" + params.Negative}
+		case MethodPoll:
+			result = PollResult{Done: true, Image: imageBase64}
+		default:
+			_ = writer.Encode(map[string]interface{}{"id": req.Id, "error": "unknown method"})
+			continue
+		}
+		data, _ := json.Marshal(result)
+		_ = writer.Encode(map[string]interface{}{"id": req.Id, "result": json.RawMessage(data)})
+		if bytes.Contains(req.Params, []byte(`"stop_reading"`)) {
+			// Плагин отвечает и перестаёт читать stdin
+			time.Sleep(time.Hour)
+		}
+	}
+}
+
+func newTestPlugin(t *testing.T, timeoutSeconds int) *PluginProvider {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	executable, err := os.Executable()
+	require.NoError(t, err)
+
+	pp, err := NewPluginProvider(imageprocessor.ImageParameters{}, nil, logger, &PluginOptions{
+		Code:           "fake",
+		Command:        executable,
+		Args:           []string{"-test.run=^$"},
+		Env:            map[string]string{helperEnv: "1"},
+		TimeoutSeconds: timeoutSeconds,
+	})
+	require.NoError(t, err)
+	t.Cleanup(pp.Close)
+
+	_ = pp.SetImageParameters(&opermanager.ImageParameters{Weight: 320, Height: 480})
+	require.NoError(t, pp.Start())
+	return pp
+}
+
+func waitRunning(t *testing.T, pp *PluginProvider) {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if pp.IsReadyForRequest() {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("plugin was not restarted")
+}
+
+func TestPluginProvider_Generate(t *testing.T) {
+	pp := newTestPlugin(t, 5)
+
+	assert.True(t, pp.GetProperties().IsCanWorkWithPrompt)
+	assert.True(t, pp.IsReadyForRequest())
+
+	id, err := pp.GenerateWithPrompt("cat", "dog", true)
+	require.NoError(t, err)
+	assert.Equal(t, "op-cat-dog", id)
+
+	done, data, err := pp.GetImageSlice(id)
+	require.NoError(t, err)
+	assert.True(t, done)
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+}
+
+func TestPluginProvider_RestartAfterCrash(t *testing.T) {
+	pp := newTestPlugin(t, 5)
+
+	_, err := pp.GenerateWithPrompt("crash", "", true)
+	require.Error(t, err)
+	assert.False(t, pp.IsReadyForRequest())
+
+	waitRunning(t, pp)
+	id, err := pp.GenerateWithPrompt("cat", "", true)
+	require.NoError(t, err)
+	assert.Equal(t, "op-cat-", id)
+}
+
+func TestPluginProvider_Timeout(t *testing.T) {
+	pp := newTestPlugin(t, 1)
+
+	_, err := pp.GenerateWithPrompt("hang", "", true)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "timeout")
+
+	waitRunning(t, pp)
+}
+
+func TestPluginProvider_HungIsReadyDoesNotBlock(t *testing.T) {
+	pp := newTestPlugin(t, 5)
+
+	_, err := pp.GenerateWithPrompt("hang_ready", "", true)
+	require.NoError(t, err)
+	pp.mutex.Lock()
+	pp.readyChecked = time.Time{}
+	pp.mutex.Unlock()
+
+	// Проверка готовности зависла в фоне, а выбор провайдера получает последний известный ответ
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		assert.True(t, pp.IsReadyForRequest())
+		assert.Less(t, time.Since(start), time.Second)
+	}
+}
+
+func TestPluginProvider_WriteTimeout(t *testing.T) {
+	pp := newTestPlugin(t, 1)
+
+	_, err := pp.GenerateWithPrompt("stop_reading", "", true)
+	require.NoError(t, err)
+
+	// Запрос больше буфера канала: запись не завершится, пока плагин не читает
+	start := time.Now()
+	_, err = pp.GenerateWithPrompt(strings.Repeat("x", 1024*1024), "", true)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "timeout")
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.False(t, pp.IsReadyForRequest())
+
+	waitRunning(t, pp)
+}
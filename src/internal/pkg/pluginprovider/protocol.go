@@ -0,0 +1,66 @@
+package pluginprovider
+
+import "encoding/json"
+
+// Протокол: по одному JSON-объекту в строке. Сервер пишет запросы в stdin плагина,
+// плагин отвечает в stdout ответом с тем же id. stderr плагина пишется в лог сервера
+
+const (
+	MethodStart              = "start"
+	MethodGenerate           = "generate"
+	MethodGenerateWithPrompt = "generate_with_prompt"
+	MethodPoll               = "poll"
+	MethodIsReady            = "is_ready"
+	MethodProperties         = "properties"
+)
+
+type request struct {
+	Id     int64       `json:"id"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params,omitempty"`
+}
+
+type response struct {
+	Id     int64           `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// StartParams параметры метода start
+type StartParams struct {
+	Code   string `json:"code"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// GenerateParams параметры методов generate и generate_with_prompt.
+// Для generate сервер передаёт случайный промпт из своего списка, плагин может его проигнорировать
+type GenerateParams struct {
+	Prompt       string `json:"prompt,omitempty"`
+	Negative     string `json:"negative,omitempty"`
+	IsDirectCall bool   `json:"is_direct_call"`
+}
+
+type GenerateResult struct {
+	Id string `json:"id"`
+}
+
+type PollParams struct {
+	Id string `json:"id"`
+}
+
+// PollResult image - изображение в base64 (JPEG, PNG или GIF)
+type PollResult struct {
+	Done  bool   `json:"done"`
+	Image string `json:"image,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type IsReadyResult struct {
+	Ready bool `json:"ready"`
+}
+
+type PropertiesResult struct {
+	CanWorkWithPrompt  bool `json:"can_work_with_prompt"`
+	NeedSaveLocalFiles bool `json:"need_save_local_files"`
+}
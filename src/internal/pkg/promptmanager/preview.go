@@ -0,0 +1,94 @@
+package promptmanager
+
+import (
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+const (
+	DEFAULT_PREVIEW_SAMPLES = 5
+	MAX_PREVIEW_SAMPLES     = 100
+)
+
+// PromptPreview пробное раскрытие шаблона без обращения к провайдерам
+type PromptPreview struct {
+	Samples []string `json:"samples"`
+	// Placeholders плейсхолдеры шаблона в порядке первого появления
+	Placeholders []string `json:"placeholders"`
+	// Missing плейсхолдеры без значений. В примерах они остаются как есть
+	Missing []string `json:"missing"`
+	// Combinations количество вариантов раскрытия. Каждое вхождение плейсхолдера выбирает значение независимо
+	Combinations *big.Int `json:"combinations"`
+}
+
+// PreviewPrompt раскрывает сохранённый промпт count раз
+func (pm *PromptManager) PreviewPrompt(idx int, count int) (*PromptPreview, error) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	prompt, ok := pm.prompts[idx]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrPromptNotFound, idx)
+	}
+	return pm.preview(prompt, count)
+}
+
+// PreviewTemplate раскрывает шаблон, которого нет в списке промптов. Его плейсхолдеры перекрывают общие
+func (pm *PromptManager) PreviewTemplate(prompt Prompt, count int) (*PromptPreview, error) {
+	if strings.TrimSpace(prompt.Prompt) == "" {
+		return nil, fmt.Errorf("%w: empty prompt", ErrInvalidPrompt)
+	}
+	for name, values := range prompt.Placeholders {
+		if err := pm.checkPlaceholder(name, values); err != nil {
+			return nil, err
+		}
+	}
+
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	return pm.preview(prompt, count)
+}
+
+// preview вызывается под блокировкой pm.mutex
+func (pm *PromptManager) preview(prompt Prompt, count int) (*PromptPreview, error) {
+	if count <= 0 || count > MAX_PREVIEW_SAMPLES {
+		return nil, fmt.Errorf("%w: count must be from 1 to %d", ErrInvalidPrompt, MAX_PREVIEW_SAMPLES)
+	}
+
+	// Значения так же, как при раскрытии: свои плейсхолдеры промпта, а если их нет - общие
+	values := copyPlaceholders(pm.globalPlaceholders)
+	for name, own := range prompt.Placeholders {
+		if len(own) > 0 {
+			values[name] = own
+		}
+	}
+
+	occurrences := pm.templater.ExtractPlaceholders(prompt.Prompt)
+	_, missing := pm.templater.ValidatePlaceholders(prompt.Prompt, values)
+	result := &PromptPreview{
+		Samples:      make([]string, 0, count),
+		Placeholders: uniqueStrings(occurrences),
+		Missing:      uniqueStrings(missing),
+		Combinations: big.NewInt(1),
+	}
+	for _, name := range occurrences {
+		result.Combinations.Mul(result.Combinations, big.NewInt(int64(len(values[name]))))
+	}
+
+	for i := 0; i < count; i++ {
+		result.Samples = append(result.Samples, pm.convertToPromptValue(prompt).Prompt)
+	}
+	return result, nil
+}
+
+func uniqueStrings(values []string) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if !slices.Contains(result, value) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
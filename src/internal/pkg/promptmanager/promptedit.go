@@ -0,0 +1,280 @@
+package promptmanager
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrPromptNotFound      = errors.New("prompt not found")
+	ErrPromptExists        = errors.New("prompt already exists")
+	ErrInvalidPrompt       = errors.New("invalid prompt")
+	ErrPlaceholderNotFound = errors.New("placeholder not found")
+)
+
+// GetPrompts промпты в порядке номеров
+func (pm *PromptManager) GetPrompts() []Prompt {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	return convertMapToPrompts(pm.prompts)
+}
+
+// GetPrompt промпт по номеру
+func (pm *PromptManager) GetPrompt(idx int) (Prompt, error) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	prompt, ok := pm.prompts[idx]
+	if !ok {
+		return Prompt{}, fmt.Errorf("%w: %d", ErrPromptNotFound, idx)
+	}
+	prompt.Idx = idx
+	return prompt, nil
+}
+
+// CreatePrompt добавляет промпт в конец списка. Если промптов уже maxKeys, самый старый удаляется
+func (pm *PromptManager) CreatePrompt(newPrompt Prompt) (Prompt, error) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	if pm.existsPromptValue(newPrompt, 0) {
+		pm.logger.Debug("New prompt already exists", "prompt", newPrompt)
+		return Prompt{}, ErrPromptExists
+	}
+	if err := pm.checkPrompt(newPrompt, pm.globalPlaceholders); err != nil {
+		return Prompt{}, err
+	}
+
+	prompts := copyPromptMap(pm.prompts)
+	keys := slices.Sorted(maps.Keys(prompts))
+	nextKey := 1
+	if len(keys) > 0 {
+		nextKey = keys[len(keys)-1] + 1
+	}
+	if pm.maxKeys > 0 && len(keys) >= pm.maxKeys {
+		delete(prompts, keys[0])
+	}
+	prompts[nextKey] = newPrompt
+
+	if err := pm.save(prompts, pm.globalPlaceholders); err != nil {
+		return Prompt{}, err
+	}
+	pm.logger.Debug("Prompts count", "count", len(pm.prompts))
+	created := pm.prompts[len(pm.prompts)]
+	return created, nil
+}
+
+// UpdatePrompt заменяет текст, негативный промпт и плейсхолдеры промпта
+func (pm *PromptManager) UpdatePrompt(idx int, prompt Prompt) (Prompt, error) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	if _, ok := pm.prompts[idx]; !ok {
+		return Prompt{}, fmt.Errorf("%w: %d", ErrPromptNotFound, idx)
+	}
+	if pm.existsPromptValue(prompt, idx) {
+		return Prompt{}, ErrPromptExists
+	}
+	if err := pm.checkPrompt(prompt, pm.globalPlaceholders); err != nil {
+		return Prompt{}, err
+	}
+
+	prompts := copyPromptMap(pm.prompts)
+	prompts[idx] = prompt
+	if err := pm.save(prompts, pm.globalPlaceholders); err != nil {
+		return Prompt{}, err
+	}
+	pm.logger.Info("Prompt updated", "idx", idx)
+	return pm.prompts[idx], nil
+}
+
+// DeletePrompt удаляет промпт. Номера следующих промптов сдвигаются, чтобы нумерация шла подряд с 1
+func (pm *PromptManager) DeletePrompt(idx int) error {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	if _, ok := pm.prompts[idx]; !ok {
+		return fmt.Errorf("%w: %d", ErrPromptNotFound, idx)
+	}
+
+	prompts := copyPromptMap(pm.prompts)
+	delete(prompts, idx)
+	if err := pm.save(prompts, pm.globalPlaceholders); err != nil {
+		return err
+	}
+	pm.logger.Info("Prompt deleted", "idx", idx, "count", len(pm.prompts))
+	return nil
+}
+
+// SetPromptPlaceholder задаёт значения плейсхолдера промпта
+func (pm *PromptManager) SetPromptPlaceholder(idx int, name string, values []string) (Prompt, error) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	prompt, ok := pm.prompts[idx]
+	if !ok {
+		return Prompt{}, fmt.Errorf("%w: %d", ErrPromptNotFound, idx)
+	}
+	if err := pm.checkPlaceholder(name, values); err != nil {
+		return Prompt{}, err
+	}
+
+	prompt.Placeholders = copyPlaceholders(prompt.Placeholders)
+	prompt.Placeholders[name] = slices.Clone(values)
+
+	prompts := copyPromptMap(pm.prompts)
+	prompts[idx] = prompt
+	if err := pm.save(prompts, pm.globalPlaceholders); err != nil {
+		return Prompt{}, err
+	}
+	pm.logger.Info("Prompt placeholder updated", "idx", idx, "placeholder", name)
+	return pm.prompts[idx], nil
+}
+
+// DeletePromptPlaceholder удаляет плейсхолдер промпта. Нельзя удалить плейсхолдер, без которого шаблон станет неполным
+func (pm *PromptManager) DeletePromptPlaceholder(idx int, name string) (Prompt, error) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	prompt, ok := pm.prompts[idx]
+	if !ok {
+		return Prompt{}, fmt.Errorf("%w: %d", ErrPromptNotFound, idx)
+	}
+	if _, ok := prompt.Placeholders[name]; !ok {
+		return Prompt{}, fmt.Errorf("%w: %s", ErrPlaceholderNotFound, name)
+	}
+
+	prompt.Placeholders = copyPlaceholders(prompt.Placeholders)
+	delete(prompt.Placeholders, name)
+	if len(prompt.Placeholders) == 0 {
+		prompt.Placeholders = nil
+	}
+	if err := pm.checkPrompt(prompt, pm.globalPlaceholders); err != nil {
+		return Prompt{}, err
+	}
+
+	prompts := copyPromptMap(pm.prompts)
+	prompts[idx] = prompt
+	if err := pm.save(prompts, pm.globalPlaceholders); err != nil {
+		return Prompt{}, err
+	}
+	pm.logger.Info("Prompt placeholder deleted", "idx", idx, "placeholder", name)
+	return pm.prompts[idx], nil
+}
+
+// GetGlobalPlaceholders плейсхолдеры, общие для всех промптов
+func (pm *PromptManager) GetGlobalPlaceholders() map[string][]string {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	return copyPlaceholders(pm.globalPlaceholders)
+}
+
+// SetGlobalPlaceholder задаёт значения общего плейсхолдера
+func (pm *PromptManager) SetGlobalPlaceholder(name string, values []string) error {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	if err := pm.checkPlaceholder(name, values); err != nil {
+		return err
+	}
+
+	global := copyPlaceholders(pm.globalPlaceholders)
+	global[name] = slices.Clone(values)
+	if err := pm.save(pm.prompts, global); err != nil {
+		return err
+	}
+	pm.logger.Info("Global placeholder updated", "placeholder", name)
+	return nil
+}
+
+// DeleteGlobalPlaceholder удаляет общий плейсхолдер. Нельзя удалить плейсхолдер, который нужен какому-то промпту
+func (pm *PromptManager) DeleteGlobalPlaceholder(name string) error {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	if _, ok := pm.globalPlaceholders[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrPlaceholderNotFound, name)
+	}
+
+	global := copyPlaceholders(pm.globalPlaceholders)
+	delete(global, name)
+	for idx, prompt := range pm.prompts {
+		// Промпты, которые были неполными и до удаления, не мешают
+		if pm.checkPrompt(prompt, pm.globalPlaceholders) != nil {
+			continue
+		}
+		if err := pm.checkPrompt(prompt, global); err != nil {
+			return fmt.Errorf("prompt %d: %w", idx, err)
+		}
+	}
+	if len(global) == 0 {
+		global = nil
+	}
+
+	if err := pm.save(pm.prompts, global); err != nil {
+		return err
+	}
+	pm.logger.Info("Global placeholder deleted", "placeholder", name)
+	return nil
+}
+
+// checkPrompt проверяет, что для всех плейсхолдеров шаблона есть значения
+func (pm *PromptManager) checkPrompt(prompt Prompt, global map[string][]string) error {
+	if strings.TrimSpace(prompt.Prompt) == "" {
+		return fmt.Errorf("%w: empty prompt", ErrInvalidPrompt)
+	}
+	for name, values := range prompt.Placeholders {
+		if err := pm.checkPlaceholder(name, values); err != nil {
+			return err
+		}
+	}
+
+	placeholders := unionMaps(global, prompt.Placeholders)
+	valid, missing := pm.templater.ValidatePlaceholders(prompt.Prompt, placeholders)
+	if !valid {
+		return fmt.Errorf("%w: no values for placeholders %s", ErrInvalidPrompt, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (pm *PromptManager) checkPlaceholder(name string, values []string) error {
+	if !pm.templater.IsValidPlaceholderName(name) {
+		return fmt.Errorf("%w: invalid placeholder name %q", ErrInvalidPrompt, name)
+	}
+	if len(values) == 0 {
+		return fmt.Errorf("%w: placeholder %s has no values", ErrInvalidPrompt, name)
+	}
+	return nil
+}
+
+// save нумерует промпты подряд с 1 и записывает файл. Состояние меняется только после успешной записи
+func (pm *PromptManager) save(prompts PromptMap, global map[string][]string) error {
+	reindexed := reindexPrompts(prompts)
+	err := pm.writeYaml(pm.filePath, &PromptsData{Prompts: convertMapToPrompts(reindexed), GlobalPlaceholders: global})
+	if err != nil {
+		pm.logger.Error("can not save prompts into file", "error", err)
+		return err
+	}
+	pm.prompts = reindexed
+	pm.globalPlaceholders = global
+	return nil
+}
+
+// reindexPrompts сохраняет порядок промптов, но убирает пропуски в нумерации
+func reindexPrompts(prompts PromptMap) PromptMap {
+	keys := slices.Sorted(maps.Keys(prompts))
+	result := make(PromptMap, len(prompts))
+	for i, key := range keys {
+		prompt := prompts[key]
+		prompt.Idx = i + 1
+		result[i+1] = prompt
+	}
+	return result
+}
+
+func copyPlaceholders(placeholders map[string][]string) map[string][]string {
+	return unionMaps(placeholders, nil)
+}
@@ -5,13 +5,18 @@ import (
 	"gopkg.in/yaml.v3"
 	"imgserver/internal/pkg/templater"
 	"log/slog"
+	"maps"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 )
 
 type PromptValue struct {
+	// Idx номер промпта в файле
+	Idx      int
 	Prompt   string
 	Negative *string
 }
@@ -31,15 +36,16 @@ type PromptManager struct {
 	globalPlaceholders map[string][]string
 	templater          *templater.TemplateProcessor
 	maxKeys            int
+	filePath           string
 	logger             *slog.Logger
 	mutex              sync.Mutex
 }
 
 type Prompt struct {
-	Idx          int                 `yaml:"idx"`
-	Prompt       string              `yaml:"prompt"`
-	Negative     *string             `yaml:"negative,omitempty"` // Обратите внимание на указатель и `omitempty`
-	Placeholders map[string][]string `yaml:"placeholders,omitempty"`
+	Idx          int                 `yaml:"idx" json:"idx"`
+	Prompt       string              `yaml:"prompt" json:"prompt"`
+	Negative     *string             `yaml:"negative,omitempty" json:"negative,omitempty"` // Обратите внимание на указатель и `omitempty`
+	Placeholders map[string][]string `yaml:"placeholders,omitempty" json:"placeholders,omitempty"`
 }
 
 type PromptsData struct {
@@ -54,19 +60,41 @@ const (
 
 func NewPromptManager(maxKeys int, logger *slog.Logger) (*PromptManager, error) {
 
-	pm := &PromptManager{logger: logger, maxKeys: maxKeys, templater: templater.NewTemplateProcessor()}
+	pm := &PromptManager{logger: logger, maxKeys: maxKeys, filePath: FILE_PATH_OPTIONS, templater: templater.NewTemplateProcessor()}
 
 	// Создать файл с примером
 	pm.writeYaml(FILE_PATH_EXAMPLE_OPTIONS, createExamplePrompts())
 
+	if err := pm.load(); err != nil {
+		return nil, err
+	}
+	return pm, nil
+}
+
+// NewPromptManagerWithFile набор промптов из отдельного файла, например для одной из рамок.
+// В отличие от основного файла, отсутствующий файл считается ошибкой
+func NewPromptManagerWithFile(filePath string, maxKeys int, logger *slog.Logger) (*PromptManager, error) {
+	if _, err := os.Stat(filePath); err != nil {
+		return nil, fmt.Errorf("can not read prompts file '%s': %w", filePath, err)
+	}
+
+	pm := &PromptManager{logger: logger, maxKeys: maxKeys, filePath: filePath, templater: templater.NewTemplateProcessor()}
+	if err := pm.load(); err != nil {
+		return nil, err
+	}
+	return pm, nil
+}
+
+func (pm *PromptManager) load() error {
 	// Прочитать данные промптов
 	promptsData, err := pm.readYaml()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
+	// Выбор случайного промпта рассчитывает на нумерацию подряд с 1
 	promptsToMap := pm.convertPromptsToMap(promptsData.Prompts)
-	pm.prompts = promptsToMap
+	pm.prompts = reindexPrompts(promptsToMap)
 	pm.globalPlaceholders = promptsData.GlobalPlaceholders
 
 	if !pm.validatePrompts(promptsData.Prompts) {
@@ -75,8 +103,8 @@ func NewPromptManager(maxKeys int, logger *slog.Logger) (*PromptManager, error)
 		pm.logger.Info("Prompts successfully validated")
 	}
 
-	pm.logger.Debug("Read saved prompts", "count", len(pm.prompts))
-	return pm, nil
+	pm.logger.Debug("Read saved prompts", "count", len(pm.prompts), "file", pm.filePath)
+	return nil
 }
 
 func (pm *PromptManager) GetRandomPrompt() (PromptValue, error) {
@@ -110,88 +138,29 @@ func (pm *PromptManager) GetRandomPrompt() (PromptValue, error) {
 
 func (pm *PromptManager) convertToPromptValue(prompt Prompt) PromptValue {
 	if !pm.templater.IsContainPlaceholders(prompt.Prompt) {
-		return PromptValue{Prompt: prompt.Prompt, Negative: prompt.Negative}
+		return PromptValue{Idx: prompt.Idx, Prompt: prompt.Prompt, Negative: prompt.Negative}
 	}
 
 	positive1 := pm.templater.ReplacePlaceholders(prompt.Prompt, prompt.Placeholders)
 	positive := pm.templater.ReplacePlaceholders(positive1, pm.globalPlaceholders)
-	return PromptValue{Prompt: positive, Negative: prompt.Negative}
+	return PromptValue{Idx: prompt.Idx, Prompt: positive, Negative: prompt.Negative}
 }
 
 func (pm *PromptManager) AddNewPrompt(newPrompt Prompt) error {
-	pm.mutex.Lock()
-	defer pm.mutex.Unlock()
-
-	if pm.existsPromptValue(newPrompt) {
-		pm.logger.Debug("New prompt already exists", "prompt", newPrompt)
-		return fmt.Errorf("new prompt already exists")
-	}
-
-	// Создаем копию оригинальной карты
-	transformedMap := make(PromptMap, len(pm.prompts))
-	for key, value := range pm.prompts {
-		transformedMap[key] = value
-	}
-
-	currentKeys := len(transformedMap)
-
-	if currentKeys == pm.maxKeys {
-		// Находим максимальный ключ
-		maxKey := 0
-		for key := range transformedMap {
-			if key > maxKey {
-				maxKey = key
-			}
-		}
-
-		// Перемещаем ключи на одну позицию вниз
-		newMap := make(PromptMap)
-		for key, value := range transformedMap {
-			newKey := key - 1
-			if newKey > 0 {
-				newMap[newKey] = value
-			}
-		}
-
-		// Добавляем новый PromptValue под новым ключом
-		newMap[maxKey] = newPrompt
-
-		pm.prompts = newMap
-	} else {
-		// Находим следующий ключ после максимального
-		maxKey := 0
-		for key := range transformedMap {
-			if key > maxKey {
-				maxKey = key
-			}
-		}
-
-		// Добавляем новый элемент с новым ключом
-		transformedMap[maxKey+1] = newPrompt
-
-		pm.prompts = transformedMap
-	}
-
-	pm.logger.Debug("Prompts count", "count", len(pm.prompts))
-	prompts := convertMapToPrompts(pm.prompts)
-	err := pm.writeYaml(FILE_PATH_OPTIONS, &PromptsData{Prompts: prompts, GlobalPlaceholders: pm.globalPlaceholders})
-	if err != nil {
-		pm.logger.Error("can not save new prompts into file", err.Error())
-		return err
-	}
-	return nil
+	_, err := pm.CreatePrompt(newPrompt)
+	return err
 }
 
 func (pm *PromptManager) readYaml() (*PromptsData, error) {
 	// Проверяем, существует ли файл
-	if _, err := os.Stat(FILE_PATH_OPTIONS); os.IsNotExist(err) {
+	if _, err := os.Stat(pm.filePath); os.IsNotExist(err) {
 		// Если файл не существует, вариант по умолчанию
 		promptData := createDefaultPrompts()
-		pm.writeYaml(FILE_PATH_OPTIONS, promptData)
+		pm.writeYaml(pm.filePath, promptData)
 		return promptData, nil
 	}
 
-	plan, _ := os.ReadFile(FILE_PATH_OPTIONS)
+	plan, _ := os.ReadFile(pm.filePath)
 	var d PromptsData
 	err := yaml.Unmarshal(plan, &d)
 	if err != nil {
@@ -203,7 +172,7 @@ func (pm *PromptManager) readYaml() (*PromptsData, error) {
 func (pm *PromptManager) writeYaml(filename string, d *PromptsData) error {
 	jsonData, err := yaml.Marshal(d)
 	if err != nil {
-		pm.logger.Error("Can not marshal", err)
+		pm.logger.Error("Can not marshal", "error", err)
 		return fmt.Errorf("can not marshal: %w", err)
 	}
 
@@ -213,25 +182,31 @@ func (pm *PromptManager) writeYaml(filename string, d *PromptsData) error {
 		fileExists = false
 	}
 
-	// Открываем файл для записи (создаем, если не существует)
-	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	// Пишем во временный файл рядом и переименовываем, чтобы при сбое не остался обрезанный файл промптов
+	file, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp-*")
 	if err != nil {
-		pm.logger.Error("Can not open prompts file", err, "filename", filename)
+		pm.logger.Error("Can not open prompts file", "error", err, "filename", filename)
 		return fmt.Errorf("can not open prompts file '%s': %w", filename, err)
 	}
-	defer file.Close()
+	tmpName := file.Name()
+	defer os.Remove(tmpName)
 
-	// Записываем JSON в файл
-	_, err = file.Write(jsonData)
-	if err != nil {
-		pm.logger.Error("Can not write file", err, "filename", filename)
-		return fmt.Errorf("can not write file '%s': %w", filename, err)
+	// Записываем YAML и символ новой строки в конец файла
+	_, err = file.Write(append(jsonData, '\n'))
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpName, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, filename)
 	}
-
-	// Добавляем символ новой строки в конец файла
-	_, err = file.WriteString("\n")
 	if err != nil {
-		pm.logger.Error("Can not write file", err, "filename", filename)
+		pm.logger.Error("Can not write file", "error", err, "filename", filename)
 		return fmt.Errorf("can not write file '%s': %w", filename, err)
 	}
 
@@ -258,8 +233,12 @@ func (pm *PromptManager) convertPromptsToMap(prompts []Prompt) PromptMap {
 	return promptMap
 }
 
-func (pm *PromptManager) existsPromptValue(prompt Prompt) bool {
-	for _, value := range pm.prompts {
+// existsPromptValue есть ли такой же промпт под другим номером. exceptIdx 0 - проверять все
+func (pm *PromptManager) existsPromptValue(prompt Prompt, exceptIdx int) bool {
+	for idx, value := range pm.prompts {
+		if idx == exceptIdx {
+			continue
+		}
 		// Сравниваем Prompt и Negative
 		if value.Prompt == prompt.Prompt {
 			if (value.Negative == nil && prompt.Negative == nil) ||
@@ -298,9 +277,11 @@ func (pm *PromptManager) validatePrompt(prompt Prompt) bool {
 	return true
 }
 
+// convertMapToPrompts список промптов в порядке номеров
 func convertMapToPrompts(promptMap PromptMap) []Prompt {
 	prompts := make([]Prompt, 0, len(promptMap))
-	for idx, promptValue := range promptMap {
+	for _, idx := range slices.Sorted(maps.Keys(promptMap)) {
+		promptValue := promptMap[idx]
 		prompts = append(prompts, Prompt{
 			Idx:          idx,
 			Prompt:       promptValue.Prompt,
@@ -0,0 +1,218 @@
+package promptmanager
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPrompts = `prompts:
+  - idx: 1
+    prompt: "first [[Fruits]]"
+  - idx: 3
+    prompt: "second [[Color]]"
+    placeholders:
+      Color: ["red"]
+  - idx: 7
+    prompt: "third"
+global_placeholders:
+  Fruits: ["apple", "orange"]
+`
+
+func newTestPromptManager(t *testing.T, maxKeys int) (*PromptManager, string) {
+	filePath := filepath.Join(t.TempDir(), "prompts.yaml")
+	require.NoError(t, os.WriteFile(filePath, []byte(testPrompts), 0644))
+	pm, err := NewPromptManagerWithFile(filePath, maxKeys, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	require.NoError(t, err)
+	return pm, filePath
+}
+
+func reload(t *testing.T, filePath string) *PromptManager {
+	pm, err := NewPromptManagerWithFile(filePath, 10, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	require.NoError(t, err)
+	return pm
+}
+
+func TestPromptManager_LoadReindex(t *testing.T) {
+	pm, _ := newTestPromptManager(t, 10)
+
+	prompts := pm.GetPrompts()
+	require.Len(t, prompts, 3)
+	for i, prompt := range prompts {
+		assert.Equal(t, i+1, prompt.Idx)
+	}
+	assert.Equal(t, "third", prompts[2].Prompt)
+
+	// Пропуски в нумерации файла не мешают случайному выбору
+	for i := 0; i < 20; i++ {
+		_, err := pm.GetRandomPrompt()
+		require.NoError(t, err)
+	}
+}
+
+func TestPromptManager_CRUD(t *testing.T) {
+	pm, filePath := newTestPromptManager(t, 10)
+
+	negative := "blur"
+	created, err := pm.CreatePrompt(Prompt{Prompt: "fourth [[Fruits]]", Negative: &negative})
+	require.NoError(t, err)
+	assert.Equal(t, 4, created.Idx)
+	_, err = pm.CreatePrompt(Prompt{Prompt: "fourth [[Fruits]]", Negative: &negative})
+	assert.ErrorIs(t, err, ErrPromptExists)
+	_, err = pm.CreatePrompt(Prompt{Prompt: "fifth [[Unknown]]"})
+	assert.ErrorIs(t, err, ErrInvalidPrompt)
+
+	updated, err := pm.UpdatePrompt(2, Prompt{Prompt: "second [[Size]]", Placeholders: map[string][]string{"Size": {"big"}}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, updated.Idx)
+	_, err = pm.UpdatePrompt(2, Prompt{Prompt: "third"})
+	assert.ErrorIs(t, err, ErrPromptExists)
+	_, err = pm.UpdatePrompt(9, Prompt{Prompt: "ninth"})
+	assert.ErrorIs(t, err, ErrPromptNotFound)
+
+	// После удаления номера идут подряд
+	require.NoError(t, pm.DeletePrompt(1))
+	prompts := pm.GetPrompts()
+	require.Len(t, prompts, 3)
+	assert.Equal(t, "second [[Size]]", prompts[0].Prompt)
+	assert.Equal(t, 3, prompts[2].Idx)
+	assert.ErrorIs(t, pm.DeletePrompt(4), ErrPromptNotFound)
+
+	// Файл перезаписан и читается заново так же
+	assert.Equal(t, prompts, reload(t, filePath).GetPrompts())
+	matches, err := filepath.Glob(filePath + ".tmp-*")
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+}
+
+func TestPromptManager_MaxKeys(t *testing.T) {
+	pm, _ := newTestPromptManager(t, 3)
+
+	created, err := pm.CreatePrompt(Prompt{Prompt: "fourth"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, created.Idx)
+
+	prompts := pm.GetPrompts()
+	require.Len(t, prompts, 3)
+	assert.Equal(t, "second [[Color]]", prompts[0].Prompt)
+	assert.Equal(t, "fourth", prompts[2].Prompt)
+}
+
+func TestPromptManager_Placeholders(t *testing.T) {
+	pm, filePath := newTestPromptManager(t, 10)
+
+	// Общий плейсхолдер нельзя удалить, пока он нужен промпту
+	assert.ErrorIs(t, pm.DeleteGlobalPlaceholder("Fruits"), ErrInvalidPrompt)
+	assert.ErrorIs(t, pm.DeleteGlobalPlaceholder("Unknown"), ErrPlaceholderNotFound)
+	assert.ErrorIs(t, pm.SetGlobalPlaceholder("bad name", []string{"x"}), ErrInvalidPrompt)
+	assert.ErrorIs(t, pm.SetGlobalPlaceholder("Empty", nil), ErrInvalidPrompt)
+
+	require.NoError(t, pm.SetGlobalPlaceholder("Animals", []string{"cat"}))
+	assert.Equal(t, []string{"cat"}, pm.GetGlobalPlaceholders()["Animals"])
+	require.NoError(t, pm.DeleteGlobalPlaceholder("Animals"))
+	assert.NotContains(t, pm.GetGlobalPlaceholders(), "Animals")
+
+	// Плейсхолдер промпта перекрывает общий
+	prompt, err := pm.SetPromptPlaceholder(1, "Fruits", []string{"pear"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"pear"}, prompt.Placeholders["Fruits"])
+	value, err := pm.GetPrompt(1)
+	require.NoError(t, err)
+	assert.Equal(t, "first pear", pm.convertToPromptValue(value).Prompt)
+
+	// Удаление своего плейсхолдера возвращает общий
+	prompt, err = pm.DeletePromptPlaceholder(1, "Fruits")
+	require.NoError(t, err)
+	assert.Nil(t, prompt.Placeholders)
+
+	// Без своего плейсхолдера шаблон станет неполным
+	_, err = pm.DeletePromptPlaceholder(2, "Color")
+	assert.ErrorIs(t, err, ErrInvalidPrompt)
+	_, err = pm.DeletePromptPlaceholder(2, "Size")
+	assert.ErrorIs(t, err, ErrPlaceholderNotFound)
+	_, err = pm.SetPromptPlaceholder(5, "Size", []string{"big"})
+	assert.ErrorIs(t, err, ErrPromptNotFound)
+
+	assert.Equal(t, pm.GetGlobalPlaceholders(), reload(t, filePath).GetGlobalPlaceholders())
+}
+
+func TestPromptManager_Reload(t *testing.T) {
+	pm, filePath := newTestPromptManager(t, 10)
+
+	// Файл с ошибками отклоняется, промпты остаются прежними
+	broken := `prompts:
+  - idx: 1
+    prompt: "first [[Unknown]]"
+  - idx: 1
+    prompt: "second"
+global_placeholders:
+  "bad name": ["x"]
+`
+	require.NoError(t, os.WriteFile(filePath, []byte(broken), 0644))
+	err := pm.Reload()
+	require.ErrorIs(t, err, ErrInvalidPrompt)
+	assert.Contains(t, err.Error(), "duplicate idx")
+	assert.Contains(t, err.Error(), "Unknown")
+	assert.Contains(t, err.Error(), "bad name")
+	assert.Len(t, pm.GetPrompts(), 3)
+
+	require.NoError(t, os.WriteFile(filePath, []byte("prompts: [\n"), 0644))
+	assert.ErrorIs(t, pm.Reload(), ErrInvalidPrompt)
+	assert.Len(t, pm.GetPrompts(), 3)
+
+	fixed := `prompts:
+  - idx: 5
+    prompt: "only [[Fruits]]"
+global_placeholders:
+  Fruits: ["plum"]
+`
+	require.NoError(t, os.WriteFile(filePath, []byte(fixed), 0644))
+	require.NoError(t, pm.Reload())
+	prompts := pm.GetPrompts()
+	require.Len(t, prompts, 1)
+	assert.Equal(t, 1, prompts[0].Idx)
+	value, err := pm.GetRandomPrompt()
+	require.NoError(t, err)
+	assert.Equal(t, "only plum", value.Prompt)
+}
+
+func TestPromptManager_Preview(t *testing.T) {
+	pm, _ := newTestPromptManager(t, 10)
+
+	preview, err := pm.PreviewPrompt(1, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Fruits"}, preview.Placeholders)
+	assert.Empty(t, preview.Missing)
+	assert.EqualValues(t, 2, preview.Combinations.Int64())
+	require.Len(t, preview.Samples, 3)
+	for _, sample := range preview.Samples {
+		assert.Contains(t, []string{"first apple", "first orange"}, sample)
+	}
+
+	// Свои значения перекрывают общие, каждое вхождение выбирается независимо
+	preview, err = pm.PreviewTemplate(Prompt{
+		Prompt:       "[[Fruits]] and [[Fruits]] in [[Box]] with [[Unknown]]",
+		Placeholders: map[string][]string{"Fruits": {"pear", "plum", "kiwi"}, "Box": {"bag"}},
+	}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Fruits", "Box", "Unknown"}, preview.Placeholders)
+	assert.Equal(t, []string{"Unknown"}, preview.Missing)
+	assert.EqualValues(t, 0, preview.Combinations.Int64())
+	assert.Contains(t, preview.Samples[0], "in bag with [[Unknown]]")
+	assert.NotContains(t, preview.Samples[0], "apple")
+
+	preview, err = pm.PreviewTemplate(Prompt{Prompt: "[[Fruits]] [[Fruits]] [[Fruits]]"}, 1)
+	require.NoError(t, err)
+	assert.EqualValues(t, 8, preview.Combinations.Int64())
+
+	_, err = pm.PreviewPrompt(9, 1)
+	assert.ErrorIs(t, err, ErrPromptNotFound)
+	_, err = pm.PreviewPrompt(1, MAX_PREVIEW_SAMPLES+1)
+	assert.ErrorIs(t, err, ErrInvalidPrompt)
+	_, err = pm.PreviewTemplate(Prompt{Prompt: " "}, 1)
+	assert.ErrorIs(t, err, ErrInvalidPrompt)
+}
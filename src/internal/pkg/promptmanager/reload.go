@@ -0,0 +1,116 @@
+package promptmanager
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"maps"
+	"os"
+	"slices"
+)
+
+// GetFilePath файл, из которого читаются промпты
+func (pm *PromptManager) GetFilePath() string {
+	return pm.filePath
+}
+
+// Reload перечитывает файл промптов после правки вручную. Файл с ошибками отклоняется целиком,
+// в лог пишется каждая ошибка, а текущие промпты остаются без изменений
+func (pm *PromptManager) Reload() error {
+	plan, err := os.ReadFile(pm.filePath)
+	if err != nil {
+		return fmt.Errorf("can not read prompts file '%s': %w", pm.filePath, err)
+	}
+	var data PromptsData
+	err = yaml.Unmarshal(plan, &data)
+	if err != nil {
+		pm.logger.Error("Prompts file rejected", "file", pm.filePath, "error", err)
+		return fmt.Errorf("%w: can not parse prompts file '%s': %v", ErrInvalidPrompt, pm.filePath, err)
+	}
+
+	errs := pm.checkPromptsData(&data)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			pm.logger.Error("Prompts file rejected", "file", pm.filePath, "error", err)
+		}
+		return fmt.Errorf("prompts file '%s' rejected: %w", pm.filePath, errors.Join(errs...))
+	}
+	prompts := reindexPrompts(pm.convertPromptsToMap(data.Prompts))
+
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	added, removed, changed := diffPrompts(pm.prompts, prompts)
+	globalChanged := !maps.EqualFunc(pm.globalPlaceholders, data.GlobalPlaceholders, slices.Equal)
+	if added == 0 && removed == 0 && changed == 0 && !globalChanged && len(pm.prompts) == len(prompts) {
+		// Например, файл только что записан самим сервером
+		pm.logger.Debug("Prompts file not changed", "file", pm.filePath)
+		return nil
+	}
+
+	pm.prompts = prompts
+	pm.globalPlaceholders = data.GlobalPlaceholders
+	pm.logger.Info("Prompts reloaded", "file", pm.filePath, "count", len(prompts),
+		"added", added, "removed", removed, "changed", changed, "global_placeholders_changed", globalChanged)
+	return nil
+}
+
+// checkPromptsData все ошибки файла промптов, чтобы их можно было исправить за один раз
+func (pm *PromptManager) checkPromptsData(data *PromptsData) []error {
+	var errs []error
+	for name, values := range data.GlobalPlaceholders {
+		if err := pm.checkPlaceholder(name, values); err != nil {
+			errs = append(errs, fmt.Errorf("global placeholders: %w", err))
+		}
+	}
+
+	indexes := make(map[int]struct{}, len(data.Prompts))
+	for _, prompt := range data.Prompts {
+		if _, exists := indexes[prompt.Idx]; exists {
+			errs = append(errs, fmt.Errorf("prompt %d: %w: duplicate idx", prompt.Idx, ErrInvalidPrompt))
+			continue
+		}
+		indexes[prompt.Idx] = struct{}{}
+		if err := pm.checkPrompt(prompt, data.GlobalPlaceholders); err != nil {
+			errs = append(errs, fmt.Errorf("prompt %d: %w", prompt.Idx, err))
+		}
+	}
+	return errs
+}
+
+// diffPrompts промпты сравниваются по тексту, потому что номера сдвигаются при удалении
+func diffPrompts(oldPrompts PromptMap, newPrompts PromptMap) (added int, removed int, changed int) {
+	oldByText := make(map[string]Prompt, len(oldPrompts))
+	for _, prompt := range oldPrompts {
+		oldByText[prompt.Prompt] = prompt
+	}
+	newByText := make(map[string]Prompt, len(newPrompts))
+	for _, prompt := range newPrompts {
+		newByText[prompt.Prompt] = prompt
+	}
+
+	for text, prompt := range newByText {
+		old, ok := oldByText[text]
+		if !ok {
+			added++
+		} else if !samePrompt(old, prompt) {
+			changed++
+		}
+	}
+	for text := range oldByText {
+		if _, ok := newByText[text]; !ok {
+			removed++
+		}
+	}
+	return added, removed, changed
+}
+
+func samePrompt(a Prompt, b Prompt) bool {
+	if a.Idx != b.Idx || (a.Negative == nil) != (b.Negative == nil) {
+		return false
+	}
+	if a.Negative != nil && *a.Negative != *b.Negative {
+		return false
+	}
+	return maps.EqualFunc(a.Placeholders, b.Placeholders, slices.Equal)
+}
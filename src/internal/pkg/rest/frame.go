@@ -0,0 +1,124 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"imgserver/internal/pkg/opermanager"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+const METRIC_FRAME_NEXT = "FRAME_NEXT"
+
+// handleEnqueueShowNext ставит изображения в очередь рамки. Принимает JSON со списком имён файлов
+// или multipart-форму с загружаемыми изображениями, которые сначала сохраняются как оригиналы
+func (rest *Rest) handleEnqueueShowNext(w http.ResponseWriter, r *http.Request) {
+	var showReq ShowNextRequest
+
+	deviceId := deviceIdFromRequest(r)
+	var uploaded []string
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if !rest.parseUploadForm(w, r, METRIC_FRAME_NEXT) {
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		// Запрос проверяется до сохранения файлов, чтобы ошибка не оставила в галерее лишних изображений
+		if value := r.FormValue("repeat"); value != "" {
+			repeat, err := strconv.Atoi(value)
+			if err != nil {
+				rest.sendShowNextError(w, err, http.StatusBadRequest, "BadRequest", "Invalid repeat")
+				return
+			}
+			showReq.Repeat = repeat
+		}
+		if showReq.Repeat == 0 {
+			showReq.Repeat = 1
+		}
+		if err := rest.operMng.ValidateShowNext(deviceId, showReq.Repeat); err != nil {
+			rest.sendShowNextOperError(w, err, "Can not queue images")
+			return
+		}
+
+		images, ok := rest.uploadImages(w, r, METRIC_FRAME_NEXT)
+		if !ok {
+			return
+		}
+		for _, image := range images {
+			uploaded = append(uploaded, image.FileName)
+		}
+		showReq.Images = uploaded
+	} else {
+		err := json.NewDecoder(r.Body).Decode(&showReq)
+		if err != nil {
+			rest.sendShowNextError(w, err, http.StatusBadRequest, "BadRequest", "Error parsing JSON request")
+			return
+		}
+	}
+	if showReq.Repeat == 0 {
+		showReq.Repeat = 1
+	}
+
+	queue, err := rest.operMng.EnqueueShowNext(deviceId, showReq.Images, showReq.Repeat)
+	if err != nil {
+		// Загруженные для очереди изображения без неё не нужны
+		for _, fileName := range uploaded {
+			if err := rest.operMng.DeleteImage(fileName); err != nil {
+				rest.logger.Warn("Can not remove uploaded image", "file", fileName, "error", err)
+			}
+		}
+		rest.sendShowNextOperError(w, err, "Can not queue images")
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, ShowNextResponse{Queue: queue})
+	rest.incrRequestMetric(METRIC_FRAME_NEXT, false)
+}
+
+// handleGetShowNext очередь рамки
+func (rest *Rest) handleGetShowNext(w http.ResponseWriter, r *http.Request) {
+	queue, err := rest.operMng.GetShowNextQueue(deviceIdFromRequest(r))
+	if err != nil {
+		rest.sendShowNextOperError(w, err, "Can not get queue")
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, ShowNextResponse{Queue: queue})
+	rest.incrRequestMetric(METRIC_FRAME_NEXT, false)
+}
+
+// handleClearShowNext очищает очередь рамки
+func (rest *Rest) handleClearShowNext(w http.ResponseWriter, r *http.Request) {
+	err := rest.operMng.ClearShowNextQueue(deviceIdFromRequest(r))
+	if err != nil {
+		rest.sendShowNextOperError(w, err, "Can not clear queue")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	rest.incrRequestMetric(METRIC_FRAME_NEXT, false)
+}
+
+// sendShowNextOperError переводит ошибку очереди в код ответа
+func (rest *Rest) sendShowNextOperError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, opermanager.ErrImageNotFound):
+		rest.sendShowNextError(w, err, http.StatusNotFound, "NotFound", message)
+	case errors.Is(err, opermanager.ErrUnknownDevice):
+		rest.sendShowNextError(w, err, http.StatusBadRequest, "UnknownDevice", message)
+	case errors.Is(err, opermanager.ErrInvalidShowNext):
+		rest.sendShowNextError(w, err, http.StatusBadRequest, "BadRequest", message)
+	default:
+		rest.logger.Error(message, slog.String("error", err.Error()))
+		rest.sendShowNextError(w, err, http.StatusInternalServerError, "InternalError", message)
+	}
+}
+
+func (rest *Rest) sendShowNextError(w http.ResponseWriter, err error, status int, code string, message string) {
+	sendJSONResponse(w, status, ErrorResponse{ErrorAttributes{Code: code, Message: message, DevMessage: err.Error()}})
+	rest.incrRequestMetric(METRIC_FRAME_NEXT, true)
+}
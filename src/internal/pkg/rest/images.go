@@ -0,0 +1,346 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"imgserver/internal/pkg/imageprocessor"
+	"imgserver/internal/pkg/opermanager"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const METRIC_IMAGES = "IMAGES"
+
+const (
+	DEFAULT_IMAGES_LIMIT = 50
+	MAX_IMAGES_LIMIT     = 500
+	// MAX_UPLOAD_SIZE ограничение размера запроса загрузки изображений
+	MAX_UPLOAD_SIZE = 64 << 20
+	// UPLOAD_FIELD имя поля формы с файлами изображений
+	UPLOAD_FIELD = "image"
+)
+
+// handleListImages список изображений галереи с фильтрами и постраничной выдачей
+func (rest *Rest) handleListImages(w http.ResponseWriter, r *http.Request) {
+	filter, offset, limit, err := parseImageListQuery(r)
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, ErrorResponse{ErrorAttributes{
+			Code:       "BadRequest",
+			Message:    "Invalid query parameters",
+			DevMessage: err.Error(),
+		}})
+		rest.incrRequestMetric(METRIC_IMAGES, true)
+		return
+	}
+
+	images, total, err := rest.operMng.ListImages(filter, offset, limit)
+	if err != nil {
+		rest.sendImageError(w, err, "Can not list images")
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, ImageListResponse{Total: total, Offset: offset, Limit: limit, Images: images})
+	rest.incrRequestMetric(METRIC_IMAGES, false)
+}
+
+// handleUploadImages принимает изображения из multipart-формы и сохраняет их как оригиналы
+func (rest *Rest) handleUploadImages(w http.ResponseWriter, r *http.Request) {
+	uploaded, ok := rest.uploadImages(w, r, METRIC_IMAGES)
+	if !ok {
+		return
+	}
+
+	sendJSONResponse(w, http.StatusCreated, ImageUploadResponse{Images: uploaded})
+	rest.incrRequestMetric(METRIC_IMAGES, false)
+}
+
+// uploadImages сохраняет изображения из поля формы image. Если хоть одно изображение не подошло,
+// не сохраняется ни одно. При ошибке ответ уже отправлен
+func (rest *Rest) uploadImages(w http.ResponseWriter, r *http.Request, metric string) ([]opermanager.ImageMetadata, bool) {
+	if !rest.parseUploadForm(w, r, metric) {
+		return nil, false
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := r.MultipartForm.File[UPLOAD_FIELD]
+	if len(files) == 0 {
+		sendJSONResponse(w, http.StatusBadRequest, ErrorResponse{ErrorAttributes{
+			Code:    "BadRequest",
+			Message: "No files in field " + UPLOAD_FIELD,
+		}})
+		rest.incrRequestMetric(metric, true)
+		return nil, false
+	}
+
+	images := make([][]byte, 0, len(files))
+	for _, header := range files {
+		data, err := readUploadedFile(header)
+		if err != nil {
+			rest.sendUploadError(w, err, metric)
+			return nil, false
+		}
+		images = append(images, data)
+	}
+
+	// Пачка сохраняется целиком: испорченный файл не оставляет на диске часть загрузки
+	uploaded, err := rest.operMng.AddUploadedImages(images)
+	if err != nil {
+		message := "Can not upload images"
+		var uploadErr *opermanager.UploadError
+		if errors.As(err, &uploadErr) {
+			message = "Can not upload image " + files[uploadErr.Index].Filename
+		}
+		rest.logger.Warn(message, "error", err)
+		rest.sendMetricImageError(w, err, message, metric)
+		return nil, false
+	}
+	return uploaded, true
+}
+
+// parseUploadForm разбирает multipart-форму с ограничением размера. Повторный вызов ничего не делает.
+// При ошибке ответ уже отправлен
+func (rest *Rest) parseUploadForm(w http.ResponseWriter, r *http.Request, metric string) bool {
+	if r.MultipartForm != nil {
+		return true
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, MAX_UPLOAD_SIZE)
+	err := r.ParseMultipartForm(MAX_UPLOAD_SIZE)
+	if err != nil {
+		rest.sendUploadError(w, err, metric)
+		return false
+	}
+	return true
+}
+
+func readUploadedFile(header *multipart.FileHeader) ([]byte, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+func (rest *Rest) sendUploadError(w http.ResponseWriter, err error, metric string) {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		sendJSONResponse(w, http.StatusRequestEntityTooLarge, ErrorResponse{ErrorAttributes{
+			Code:       "TooLarge",
+			Message:    "Upload is too large",
+			DevMessage: err.Error(),
+		}})
+	} else {
+		sendJSONResponse(w, http.StatusBadRequest, ErrorResponse{ErrorAttributes{
+			Code:       "BadRequest",
+			Message:    "Error parsing multipart form",
+			DevMessage: err.Error(),
+		}})
+	}
+	rest.logger.Warn("Can not read upload", "error", err)
+	rest.incrRequestMetric(metric, true)
+}
+
+// handleGetImageMetadata отдаёт метаданные оригинала из индекса
+func (rest *Rest) handleGetImageMetadata(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	metadata, err := rest.operMng.GetImageMetadata(name)
+	if err != nil {
+		rest.sendImageError(w, err, "Can not get image metadata")
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, metadata)
+	rest.incrRequestMetric(METRIC_IMAGES, false)
+}
+
+// handleGetImageOriginal отдаёт оригинал изображения
+func (rest *Rest) handleGetImageOriginal(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	path, err := rest.operMng.GetImagePath(name)
+	if err != nil {
+		rest.sendImageError(w, err, "Can not get image")
+		return
+	}
+
+	if !rest.serveJpegFile(w, r, path) {
+		rest.incrRequestMetric(METRIC_IMAGES, true)
+		return
+	}
+	rest.incrRequestMetric(METRIC_IMAGES, false)
+}
+
+// handleGetImageFrame отдаёт изображение, подготовленное под размер рамки
+func (rest *Rest) handleGetImageFrame(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	data, err := rest.operMng.RenderImage(deviceIdFromRequest(r), name, 0, 0)
+	if err != nil {
+		rest.sendImageError(w, err, "Can not render image")
+		return
+	}
+	rest.sendJpegData(w, data)
+}
+
+// handleRenderImage отдаёт изображение, подготовленное под заданное разрешение
+func (rest *Rest) handleRenderImage(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	width, errWidth := strconv.Atoi(r.URL.Query().Get("width"))
+	height, errHeight := strconv.Atoi(r.URL.Query().Get("height"))
+	if errWidth != nil || errHeight != nil {
+		sendJSONResponse(w, http.StatusBadRequest, ErrorResponse{ErrorAttributes{
+			Code:    "BadRequest",
+			Message: "width and height are required",
+		}})
+		rest.incrRequestMetric(METRIC_IMAGES, true)
+		return
+	}
+
+	data, err := rest.operMng.RenderImage(deviceIdFromRequest(r), name, width, height)
+	if err != nil {
+		rest.sendImageError(w, err, "Can not render image")
+		return
+	}
+	rest.sendJpegData(w, data)
+}
+
+// handleUpdateImage отмечает изображение избранным или закреплённым
+func (rest *Rest) handleUpdateImage(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	var updateReq ImageUpdateRequest
+	err := json.NewDecoder(r.Body).Decode(&updateReq)
+	if err != nil {
+		sendJSONResponse(w, http.StatusBadRequest, ErrorResponse{ErrorAttributes{
+			Code:       "BadRequest",
+			Message:    "Error parsing JSON request",
+			DevMessage: err.Error(),
+		}})
+		rest.incrRequestMetric(METRIC_IMAGES, true)
+		return
+	}
+
+	metadata, err := rest.operMng.UpdateImage(name, opermanager.ImageUpdate{Favorite: updateReq.Favorite, Pinned: updateReq.Pinned})
+	if err != nil {
+		rest.sendImageError(w, err, "Can not update image")
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, metadata)
+	rest.incrRequestMetric(METRIC_IMAGES, false)
+}
+
+// handleDeleteImage удаляет оригинал из каталога
+func (rest *Rest) handleDeleteImage(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	err := rest.operMng.DeleteImage(name)
+	if err != nil {
+		rest.sendImageError(w, err, "Can not delete image")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	rest.incrRequestMetric(METRIC_IMAGES, false)
+}
+
+func (rest *Rest) sendJpegData(w http.ResponseWriter, data []byte) {
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+	rest.incrRequestMetric(METRIC_IMAGES, false)
+}
+
+// sendImageError переводит ошибку галереи в код ответа
+func (rest *Rest) sendImageError(w http.ResponseWriter, err error, message string) {
+	rest.sendMetricImageError(w, err, message, METRIC_IMAGES)
+}
+
+func (rest *Rest) sendMetricImageError(w http.ResponseWriter, err error, message string, metric string) {
+	errorAttrs := ErrorAttributes{Message: message, DevMessage: err.Error()}
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, opermanager.ErrImageNotFound):
+		status = http.StatusNotFound
+		errorAttrs.Code = "NotFound"
+	case errors.Is(err, opermanager.ErrUnknownDevice):
+		status = http.StatusBadRequest
+		errorAttrs.Code = "UnknownDevice"
+	case errors.Is(err, opermanager.ErrInvalidImageSize):
+		status = http.StatusBadRequest
+		errorAttrs.Code = "BadRequest"
+	case errors.Is(err, imageprocessor.ErrUnsupportedImage):
+		status = http.StatusUnsupportedMediaType
+		errorAttrs.Code = "UnsupportedImage"
+	default:
+		errorAttrs.Code = "InternalError"
+		rest.logger.Error(errorAttrs.Message, slog.String("error", errorAttrs.DevMessage))
+	}
+	sendJSONResponse(w, status, ErrorResponse{errorAttrs})
+	rest.incrRequestMetric(metric, true)
+}
+
+// parseImageListQuery разбирает параметры offset, limit, provider, from, to и prompt
+func parseImageListQuery(r *http.Request) (opermanager.ImageFilter, int, int, error) {
+	query := r.URL.Query()
+	filter := opermanager.ImageFilter{
+		ProviderCode: query.Get("provider"),
+		Prompt:       query.Get("prompt"),
+	}
+
+	offset := 0
+	if value := query.Get("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return filter, 0, 0, fmt.Errorf("invalid offset: %s", value)
+		}
+		offset = parsed
+	}
+
+	limit := DEFAULT_IMAGES_LIMIT
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return filter, 0, 0, fmt.Errorf("invalid limit: %s", value)
+		}
+		limit = min(parsed, MAX_IMAGES_LIMIT)
+	}
+
+	var err error
+	if value := query.Get("from"); value != "" {
+		if filter.From, err = parseQueryTime(value, false); err != nil {
+			return filter, 0, 0, err
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if filter.To, err = parseQueryTime(value, true); err != nil {
+			return filter, 0, 0, err
+		}
+	}
+	return filter, offset, limit, nil
+}
+
+// parseQueryTime время в формате RFC3339 или дата 2006-01-02.
+// Дата в конце периода включает весь день
+func parseQueryTime(value string, endOfPeriod bool) (time.Time, error) {
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, nil
+	}
+	parsed, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time: %s", value)
+	}
+	if endOfPeriod {
+		parsed = parsed.AddDate(0, 0, 1)
+	}
+	return parsed, nil
+}
@@ -1,6 +1,9 @@
 package rest
 
-import "imgserver/internal/pkg/opermanager"
+import (
+	"imgserver/internal/pkg/opermanager"
+	"imgserver/internal/pkg/promptmanager"
+)
 
 type ImageResultResponse struct {
 	Image string `json:"image"`
@@ -33,6 +36,9 @@ type StatusResponse struct {
 
 	YandexToday     int64 `json:"yandex_today"`
 	YandexYesterday int64 `json:"yandex_yesterday"`
+
+	Providers     []opermanager.ProviderHealth `json:"providers"`
+	PrefetchQueue int                          `json:"prefetch_queue"`
 }
 
 // Error структура для ошибок
@@ -76,3 +82,66 @@ type OperationStatusResponse struct {
 	Status opermanager.Status `json:"status"`
 	Error  ErrorAttributes    `json:"error,omitempty"`
 }
+
+// ImageListResponse страница списка изображений галереи
+type ImageListResponse struct {
+	Total  int                         `json:"total"`
+	Offset int                         `json:"offset"`
+	Limit  int                         `json:"limit"`
+	Images []opermanager.ImageMetadata `json:"images"`
+}
+
+// ImageUpdateRequest отметки изображения. Не переданные поля не меняются
+type ImageUpdateRequest struct {
+	Favorite *bool `json:"favorite,omitempty"`
+	Pinned   *bool `json:"pinned,omitempty"`
+}
+
+// ImageUploadResponse метаданные сохранённых изображений
+type ImageUploadResponse struct {
+	Images []opermanager.ImageMetadata `json:"images"`
+}
+
+// ShowNextRequest изображения, которые рамка покажет следующими
+type ShowNextRequest struct {
+	Images []string `json:"images"`
+	// Repeat сколько запросов подряд показывать каждое изображение. По умолчанию 1
+	Repeat int `json:"repeat,omitempty"`
+}
+
+// ShowNextResponse очередь рамки
+type ShowNextResponse struct {
+	Queue []opermanager.ShowNextItem `json:"queue"`
+}
+
+// PromptRequest промпт для создания или замены. Номер назначает сервер
+type PromptRequest struct {
+	Prompt       string              `json:"prompt"`
+	Negative     *string             `json:"negative,omitempty"`
+	Placeholders map[string][]string `json:"placeholders,omitempty"`
+}
+
+// PromptListResponse все промпты и общие плейсхолдеры
+type PromptListResponse struct {
+	Prompts            []promptmanager.Prompt `json:"prompts"`
+	GlobalPlaceholders map[string][]string    `json:"global_placeholders"`
+}
+
+// PlaceholderRequest значения плейсхолдера
+type PlaceholderRequest struct {
+	Values []string `json:"values"`
+}
+
+// PlaceholdersResponse общие плейсхолдеры
+type PlaceholdersResponse struct {
+	Placeholders map[string][]string `json:"placeholders"`
+}
+
+// PromptPreviewRequest сохранённый промпт по номеру или шаблон, который нужно раскрыть
+type PromptPreviewRequest struct {
+	Idx          int                 `json:"idx,omitempty"`
+	Prompt       string              `json:"prompt,omitempty"`
+	Placeholders map[string][]string `json:"placeholders,omitempty"`
+	// Count сколько примеров раскрытия вернуть. По умолчанию 5
+	Count int `json:"count,omitempty"`
+}
@@ -0,0 +1,247 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"imgserver/internal/pkg/promptmanager"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+const METRIC_PROMPTS = "PROMPTS"
+
+// handleListPrompts все промпты и общие плейсхолдеры
+func (rest *Rest) handleListPrompts(w http.ResponseWriter, r *http.Request) {
+	sendJSONResponse(w, http.StatusOK, PromptListResponse{
+		Prompts:            rest.promptManager.GetPrompts(),
+		GlobalPlaceholders: rest.promptManager.GetGlobalPlaceholders(),
+	})
+	rest.incrRequestMetric(METRIC_PROMPTS, false)
+}
+
+// handleCreatePrompt добавляет промпт в конец списка
+func (rest *Rest) handleCreatePrompt(w http.ResponseWriter, r *http.Request) {
+	prompt, ok := rest.decodePromptRequest(w, r)
+	if !ok {
+		return
+	}
+
+	created, err := rest.promptManager.CreatePrompt(prompt)
+	if err != nil {
+		rest.sendPromptError(w, err, "Can not create prompt")
+		return
+	}
+
+	sendJSONResponse(w, http.StatusCreated, created)
+	rest.incrRequestMetric(METRIC_PROMPTS, false)
+}
+
+// handleGetPrompt промпт по номеру
+func (rest *Rest) handleGetPrompt(w http.ResponseWriter, r *http.Request) {
+	idx, _ := strconv.Atoi(mux.Vars(r)["idx"])
+
+	prompt, err := rest.promptManager.GetPrompt(idx)
+	if err != nil {
+		rest.sendPromptError(w, err, "Can not get prompt")
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, prompt)
+	rest.incrRequestMetric(METRIC_PROMPTS, false)
+}
+
+// handleUpdatePrompt заменяет промпт целиком
+func (rest *Rest) handleUpdatePrompt(w http.ResponseWriter, r *http.Request) {
+	idx, _ := strconv.Atoi(mux.Vars(r)["idx"])
+	prompt, ok := rest.decodePromptRequest(w, r)
+	if !ok {
+		return
+	}
+
+	updated, err := rest.promptManager.UpdatePrompt(idx, prompt)
+	if err != nil {
+		rest.sendPromptError(w, err, "Can not update prompt")
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, updated)
+	rest.incrRequestMetric(METRIC_PROMPTS, false)
+}
+
+// handleDeletePrompt удаляет промпт, номера следующих промптов сдвигаются
+func (rest *Rest) handleDeletePrompt(w http.ResponseWriter, r *http.Request) {
+	idx, _ := strconv.Atoi(mux.Vars(r)["idx"])
+
+	err := rest.promptManager.DeletePrompt(idx)
+	if err != nil {
+		rest.sendPromptError(w, err, "Can not delete prompt")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	rest.incrRequestMetric(METRIC_PROMPTS, false)
+}
+
+// handleSetPromptPlaceholder задаёт значения плейсхолдера промпта
+func (rest *Rest) handleSetPromptPlaceholder(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idx, _ := strconv.Atoi(vars["idx"])
+	values, ok := rest.decodePlaceholderRequest(w, r)
+	if !ok {
+		return
+	}
+
+	prompt, err := rest.promptManager.SetPromptPlaceholder(idx, vars["name"], values)
+	if err != nil {
+		rest.sendPromptError(w, err, "Can not set prompt placeholder")
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, prompt)
+	rest.incrRequestMetric(METRIC_PROMPTS, false)
+}
+
+// handleDeletePromptPlaceholder удаляет плейсхолдер промпта
+func (rest *Rest) handleDeletePromptPlaceholder(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	idx, _ := strconv.Atoi(vars["idx"])
+
+	prompt, err := rest.promptManager.DeletePromptPlaceholder(idx, vars["name"])
+	if err != nil {
+		rest.sendPromptError(w, err, "Can not delete prompt placeholder")
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, prompt)
+	rest.incrRequestMetric(METRIC_PROMPTS, false)
+}
+
+// handleListGlobalPlaceholders общие плейсхолдеры
+func (rest *Rest) handleListGlobalPlaceholders(w http.ResponseWriter, r *http.Request) {
+	sendJSONResponse(w, http.StatusOK, PlaceholdersResponse{Placeholders: rest.promptManager.GetGlobalPlaceholders()})
+	rest.incrRequestMetric(METRIC_PROMPTS, false)
+}
+
+// handleSetGlobalPlaceholder задаёт значения общего плейсхолдера
+func (rest *Rest) handleSetGlobalPlaceholder(w http.ResponseWriter, r *http.Request) {
+	values, ok := rest.decodePlaceholderRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := rest.promptManager.SetGlobalPlaceholder(mux.Vars(r)["name"], values)
+	if err != nil {
+		rest.sendPromptError(w, err, "Can not set placeholder")
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, PlaceholdersResponse{Placeholders: rest.promptManager.GetGlobalPlaceholders()})
+	rest.incrRequestMetric(METRIC_PROMPTS, false)
+}
+
+// handleDeleteGlobalPlaceholder удаляет общий плейсхолдер, если он не нужен промптам
+func (rest *Rest) handleDeleteGlobalPlaceholder(w http.ResponseWriter, r *http.Request) {
+	err := rest.promptManager.DeleteGlobalPlaceholder(mux.Vars(r)["name"])
+	if err != nil {
+		rest.sendPromptError(w, err, "Can not delete placeholder")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	rest.incrRequestMetric(METRIC_PROMPTS, false)
+}
+
+// handlePreviewPrompt раскрывает сохранённый промпт или переданный шаблон несколько раз, не обращаясь к провайдерам
+func (rest *Rest) handlePreviewPrompt(w http.ResponseWriter, r *http.Request) {
+	var previewReq PromptPreviewRequest
+	err := json.NewDecoder(r.Body).Decode(&previewReq)
+	if err != nil {
+		rest.sendPromptBadRequest(w, err)
+		return
+	}
+	if (previewReq.Idx > 0) == (previewReq.Prompt != "") {
+		sendJSONResponse(w, http.StatusBadRequest, ErrorResponse{ErrorAttributes{
+			Code:    "BadRequest",
+			Message: "Either idx or prompt is required",
+		}})
+		rest.incrRequestMetric(METRIC_PROMPTS, true)
+		return
+	}
+	if previewReq.Count == 0 {
+		previewReq.Count = promptmanager.DEFAULT_PREVIEW_SAMPLES
+	}
+
+	var preview *promptmanager.PromptPreview
+	if previewReq.Idx > 0 {
+		preview, err = rest.promptManager.PreviewPrompt(previewReq.Idx, previewReq.Count)
+	} else {
+		preview, err = rest.promptManager.PreviewTemplate(promptmanager.Prompt{
+			Prompt:       previewReq.Prompt,
+			Placeholders: previewReq.Placeholders,
+		}, previewReq.Count)
+	}
+	if err != nil {
+		rest.sendPromptError(w, err, "Can not preview prompt")
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, preview)
+	rest.incrRequestMetric(METRIC_PROMPTS, false)
+}
+
+func (rest *Rest) decodePromptRequest(w http.ResponseWriter, r *http.Request) (promptmanager.Prompt, bool) {
+	var promptReq PromptRequest
+	err := json.NewDecoder(r.Body).Decode(&promptReq)
+	if err != nil {
+		rest.sendPromptBadRequest(w, err)
+		return promptmanager.Prompt{}, false
+	}
+	return promptmanager.Prompt{
+		Prompt:       promptReq.Prompt,
+		Negative:     promptReq.Negative,
+		Placeholders: promptReq.Placeholders,
+	}, true
+}
+
+func (rest *Rest) decodePlaceholderRequest(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	var placeholderReq PlaceholderRequest
+	err := json.NewDecoder(r.Body).Decode(&placeholderReq)
+	if err != nil {
+		rest.sendPromptBadRequest(w, err)
+		return nil, false
+	}
+	return placeholderReq.Values, true
+}
+
+func (rest *Rest) sendPromptBadRequest(w http.ResponseWriter, err error) {
+	sendJSONResponse(w, http.StatusBadRequest, ErrorResponse{ErrorAttributes{
+		Code:       "BadRequest",
+		Message:    "Error parsing JSON request",
+		DevMessage: err.Error(),
+	}})
+	rest.incrRequestMetric(METRIC_PROMPTS, true)
+}
+
+// sendPromptError переводит ошибку менеджера промптов в код ответа
+func (rest *Rest) sendPromptError(w http.ResponseWriter, err error, message string) {
+	errorAttrs := ErrorAttributes{Message: message, DevMessage: err.Error()}
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, promptmanager.ErrPromptNotFound), errors.Is(err, promptmanager.ErrPlaceholderNotFound):
+		status = http.StatusNotFound
+		errorAttrs.Code = "NotFound"
+	case errors.Is(err, promptmanager.ErrPromptExists):
+		status = http.StatusConflict
+		errorAttrs.Code = "PromptExists"
+	case errors.Is(err, promptmanager.ErrInvalidPrompt):
+		status = http.StatusBadRequest
+		errorAttrs.Code = "InvalidPrompt"
+	default:
+		errorAttrs.Code = "InternalError"
+		rest.logger.Error(errorAttrs.Message, slog.String("error", errorAttrs.DevMessage))
+	}
+	sendJSONResponse(w, status, ErrorResponse{errorAttrs})
+	rest.incrRequestMetric(METRIC_PROMPTS, true)
+}
@@ -3,6 +3,7 @@ package rest
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	"html/template"
@@ -14,6 +15,7 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 )
@@ -24,6 +26,8 @@ const (
 	METRIC_OPERATION_STATUS = "OPERATION_STATUS"
 	METRIC_NEW_PROMPT       = "NEW_PROMPT"
 	METRIC_IMAGE_GET        = "IMAGE_GET"
+
+	HEADER_DEVICE_ID = "X-Device-Id"
 )
 
 // Шаблон для веб-страницы
@@ -53,6 +57,27 @@ var indexTemplate = `
                 console.error('Error:', error);
             });
         }
+
+        function uploadImages(event) {
+            event.preventDefault();
+            const form = event.target;
+            fetch('/images', {
+                method: 'POST',
+                body: new FormData(form)
+            })
+            .then(response => response.json().then(data => ({ok: response.ok, data: data})))
+            .then(result => {
+                if(result.ok){
+                    alert('Uploaded images: ' + result.data.images.length);
+                    form.reset();
+                } else {
+                    alert('Error: ' + result.data.error.message);
+                }
+            })
+            .catch((error) => {
+                console.error('Error:', error);
+            });
+        }
     </script>
 </head>
 <body>
@@ -75,7 +100,31 @@ var indexTemplate = `
     <p>Yandex art error: {{.YandexError}}</p>
     <p>Yandex art success rate (req per hour): {{.YandexSuccessRate}}</p>
     <p>Yandex art error rate (req per hour): {{.YandexErrorRate}}</p>
-
+    </br>
+    <p>Prefetched images ready: {{.PrefetchQueue}}</p>
+    <h2>Providers</h2>
+    <table border="1" cellpadding="4">
+        <tr><th>Provider</th><th>State</th><th>Failures in a row</th><th>Trips</th><th>Open until</th><th>Last success</th><th>Last error</th></tr>
+        {{range .Providers}}
+        <tr>
+            <td>{{.Code}}</td>
+            <td>{{.State}}</td>
+            <td>{{.ConsecutiveFailures}}</td>
+            <td>{{.Trips}}</td>
+            <td>{{if not .OpenUntil.IsZero}}{{.OpenUntil.Format "2006-01-02 15:04:05"}}{{end}}</td>
+            <td>{{if not .LastSuccessTime.IsZero}}{{.LastSuccessTime.Format "2006-01-02 15:04:05"}}{{end}}</td>
+            <td>{{if not .LastErrorTime.IsZero}}{{.LastErrorTime.Format "2006-01-02 15:04:05"}} {{.LastError}}{{end}}</td>
+        </tr>
+        {{end}}
+    </table>
+
+
+    <h2>Upload photos</h2>
+    <form onsubmit="uploadImages(event)">
+        <input type="file" name="image" accept="image/jpeg,image/png,image/webp,image/gif" multiple required>
+        <button type="submit">Upload</button>
+    </form>
+    </br>
 
     <button onclick="sendRequest()">Execute Internal Function</button>
 </body>
@@ -112,7 +161,31 @@ func NewRest(port string,
 	router.HandleFunc("/operation/start", restObj.handleStartOperation).Methods("POST")
 	router.HandleFunc("/operation/status/{operationId}", restObj.handleGetOperationStatus).Methods("GET")
 	router.HandleFunc("/operation/result/{operationId}", restObj.handleGetImage).Methods("GET")
+	router.HandleFunc("/operation/result/{operationId}/image", restObj.handleGetImageBinary).Methods("GET", "HEAD")
 	router.HandleFunc("/prompt/add", restObj.handleNewPrompt).Methods("POST")
+	router.HandleFunc("/prompts", restObj.handleListPrompts).Methods("GET")
+	router.HandleFunc("/prompts", restObj.handleCreatePrompt).Methods("POST")
+	router.HandleFunc("/prompts/preview", restObj.handlePreviewPrompt).Methods("POST")
+	router.HandleFunc("/prompts/placeholders", restObj.handleListGlobalPlaceholders).Methods("GET")
+	router.HandleFunc("/prompts/placeholders/{name}", restObj.handleSetGlobalPlaceholder).Methods("PUT")
+	router.HandleFunc("/prompts/placeholders/{name}", restObj.handleDeleteGlobalPlaceholder).Methods("DELETE")
+	router.HandleFunc("/prompts/{idx:[0-9]+}", restObj.handleGetPrompt).Methods("GET")
+	router.HandleFunc("/prompts/{idx:[0-9]+}", restObj.handleUpdatePrompt).Methods("PUT")
+	router.HandleFunc("/prompts/{idx:[0-9]+}", restObj.handleDeletePrompt).Methods("DELETE")
+	router.HandleFunc("/prompts/{idx:[0-9]+}/placeholders/{name}", restObj.handleSetPromptPlaceholder).Methods("PUT")
+	router.HandleFunc("/prompts/{idx:[0-9]+}/placeholders/{name}", restObj.handleDeletePromptPlaceholder).Methods("DELETE")
+	router.HandleFunc("/debug/providers/selection", restObj.handleGetSelectionDecision).Methods("GET")
+	router.HandleFunc("/images", restObj.handleListImages).Methods("GET")
+	router.HandleFunc("/images", restObj.handleUploadImages).Methods("POST")
+	router.HandleFunc("/images/{name}", restObj.handleGetImageOriginal).Methods("GET", "HEAD")
+	router.HandleFunc("/images/{name}", restObj.handleUpdateImage).Methods("PATCH")
+	router.HandleFunc("/images/{name}", restObj.handleDeleteImage).Methods("DELETE")
+	router.HandleFunc("/images/{name}/metadata", restObj.handleGetImageMetadata).Methods("GET")
+	router.HandleFunc("/images/{name}/frame", restObj.handleGetImageFrame).Methods("GET")
+	router.HandleFunc("/images/{name}/render", restObj.handleRenderImage).Methods("GET")
+	router.HandleFunc("/frame/next", restObj.handleEnqueueShowNext).Methods("POST")
+	router.HandleFunc("/frame/next", restObj.handleGetShowNext).Methods("GET")
+	router.HandleFunc("/frame/next", restObj.handleClearShowNext).Methods("DELETE")
 
 	logger.Error("(It is not error!!!) Run WEB-Server on https://127.0.0.1", "port", port)
 
@@ -124,7 +197,7 @@ func (rest *Rest) handleIndex(w http.ResponseWriter, r *http.Request) {
 	// Парсим шаблон
 	tmpl, err := template.New("index").Parse(indexTemplate)
 	if err != nil {
-		rest.logger.Warn("Error parsing template %v", err)
+		rest.logger.Warn("Error parsing template", "error", err)
 		http.Error(w, "Error parsing template", http.StatusInternalServerError)
 		return
 	}
@@ -150,6 +223,9 @@ func (rest *Rest) handleIndex(w http.ResponseWriter, r *http.Request) {
 
 		YandexYesterday: rest.metrics.GetDailyMetricSafe(time.Now().Add(-time.Duration(24)*time.Hour), opermanager.METRIC_TEMPLATE_OPERATION_START+ydart.ProviderCode).Counter.Count(),
 		YandexToday:     rest.metrics.GetDailyMetricSafe(time.Now(), opermanager.METRIC_TEMPLATE_OPERATION_START+ydart.ProviderCode).Counter.Count(),
+
+		Providers:     rest.operMng.GetProvidersHealth(),
+		PrefetchQueue: rest.operMng.GetPrefetchQueueLength(),
 	})
 
 	if err != nil {
@@ -285,6 +361,107 @@ func (rest *Rest) handleGetImage(w http.ResponseWriter, r *http.Request) {
 	rest.incrRequestMetric(METRIC_IMAGE_GET, false)
 }
 
+// handleGetImageBinary отдаёт изображение как есть (image/jpeg), без base64 и JSON.
+// Поддерживает Range-запросы, чтобы рамка могла докачать прерванную загрузку
+func (rest *Rest) handleGetImageBinary(w http.ResponseWriter, r *http.Request) {
+	rest.logger.Debug("Handling GET binary image")
+	vars := mux.Vars(r)
+	var errorAttrs ErrorAttributes
+
+	operationId, ok := vars["operationId"]
+	if !ok {
+		errorAttrs.Code = "BadRequest"
+		errorAttrs.Message = "operationId is missing in parameters"
+		sendJSONResponse(w, http.StatusBadRequest, ErrorResponse{errorAttrs})
+		rest.logger.Error(errorAttrs.Message)
+		rest.incrRequestMetric(METRIC_IMAGE_GET, true)
+		return
+	}
+
+	status, err := rest.operMng.GetOperationStatus(operationId)
+	if err != nil {
+		errorAttrs.Code = "InternalError"
+		errorAttrs.Message = "Can not get operation status"
+		errorAttrs.DevMessage = err.Error()
+		sendJSONResponse(w, http.StatusUnprocessableEntity, ErrorResponse{errorAttrs})
+		rest.logger.Error(errorAttrs.Message, slog.String("error", errorAttrs.DevMessage))
+		rest.incrRequestMetric(METRIC_IMAGE_GET, true)
+		return
+	}
+
+	if status.Status == opermanager.StatusError {
+		errorAttrs.Code = "operationError"
+		errorAttrs.Message = "operation have error status"
+		errorAttrs.DevMessage = status.Error
+		sendJSONResponse(w, http.StatusUnprocessableEntity, OperationStatusResponse{ID: operationId, Status: status.Status, Error: errorAttrs})
+		rest.incrRequestMetric(METRIC_IMAGE_GET, true)
+		return
+	}
+
+	if status.Status != opermanager.StatusDone {
+		// Изображение ещё не готово. Рамка должна повторить запрос позже
+		sendJSONResponse(w, http.StatusAccepted, OperationStatusResponse{ID: operationId, Status: status.Status})
+		rest.incrRequestMetric(METRIC_IMAGE_GET, false)
+		return
+	}
+
+	fileName, err := rest.operMng.GetFileName(operationId)
+	if err != nil {
+		errorAttrs.Code = "InternalError"
+		errorAttrs.Message = "Can not get filename"
+		errorAttrs.DevMessage = err.Error()
+		sendJSONResponse(w, http.StatusUnprocessableEntity, ErrorResponse{errorAttrs})
+		rest.logger.Error(errorAttrs.Message, slog.String("error", errorAttrs.DevMessage))
+		rest.incrRequestMetric(METRIC_IMAGE_GET, true)
+		return
+	}
+
+	rest.logger.Debug("Send binary file", "operatioId", operationId, "filename", fileName)
+
+	if !rest.serveJpegFile(w, r, fileName) {
+		rest.incrRequestMetric(METRIC_IMAGE_GET, true)
+		return
+	}
+	rest.incrRequestMetric(METRIC_IMAGE_GET, false)
+}
+
+// serveJpegFile отдаёт JPEG-файл с заголовками Content-Length, ETag и Last-Modified.
+// Range и условные запросы обрабатывает http.ServeContent
+func (rest *Rest) serveJpegFile(w http.ResponseWriter, r *http.Request, fileName string) bool {
+	file, err := os.Open(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "File not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Error reading file", http.StatusInternalServerError)
+		}
+		rest.logger.Error("Can not open image file", "filename", fileName, "error", err)
+		return false
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		rest.logger.Error("Can not stat image file", "filename", fileName, "error", err)
+		return false
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("ETag", fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size()))
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	http.ServeContent(w, r, filepath.Base(fileName), info.ModTime(), file)
+	return true
+}
+
+// deviceIdFromRequest идентификатор рамки из заголовка X-Device-Id или параметра device
+func deviceIdFromRequest(r *http.Request) string {
+	if id := r.Header.Get(HEADER_DEVICE_ID); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("device")
+}
+
 // Функция для обработки POST-запросов к /operation/start
 func (rest *Rest) handleStartOperation(w http.ResponseWriter, r *http.Request) {
 
@@ -314,7 +491,27 @@ func (rest *Rest) handleStartOperation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	operationId, err := rest.operMng.StartOperation(startReq.Type, startReq.Prompt)
+	operationId, err := rest.operMng.StartDeviceOperation(deviceIdFromRequest(r), startReq.Type, startReq.Prompt, startReq.Negative)
+	if errors.Is(err, opermanager.ErrUnknownDevice) {
+		errorAttrs.Code = "UnknownDevice"
+		errorAttrs.Message = "Device is not registered"
+		errorAttrs.DevMessage = err.Error()
+		startResp.Error = errorAttrs
+		sendJSONResponse(w, http.StatusBadRequest, startResp)
+		rest.logger.Warn(errorAttrs.Message, slog.String("error", errorAttrs.DevMessage))
+		rest.incrRequestMetric(METRIC_OPERATION_START, true)
+		return
+	}
+	if errors.Is(err, opermanager.ErrBudgetExhausted) {
+		errorAttrs.Code = "BudgetExhausted"
+		errorAttrs.Message = "Provider budget is exhausted"
+		errorAttrs.DevMessage = err.Error()
+		startResp.Error = errorAttrs
+		sendJSONResponse(w, http.StatusTooManyRequests, startResp)
+		rest.logger.Warn(errorAttrs.Message, slog.String("error", errorAttrs.DevMessage))
+		rest.incrRequestMetric(METRIC_OPERATION_START, true)
+		return
+	}
 	if err != nil {
 		errorAttrs.Code = "StartError"
 		errorAttrs.Message = "Can not start operation"
@@ -422,6 +619,20 @@ func (rest *Rest) handleGetOperationStatus(w http.ResponseWriter, r *http.Reques
 	sendJSONResponse(w, http.StatusOK, statusResponse)
 }
 
+// handleGetSelectionDecision объясняет последний выбор провайдера: какие провайдеры были готовы и почему выбран именно этот
+func (rest *Rest) handleGetSelectionDecision(w http.ResponseWriter, r *http.Request) {
+	decision := rest.operMng.GetLastSelectionDecision()
+	if decision == nil {
+		sendJSONResponse(w, http.StatusNotFound, ErrorResponse{Error: ErrorAttributes{
+			Code:    "NotFound",
+			Message: "provider has not been chosen yet",
+		}})
+		return
+	}
+
+	sendJSONResponse(w, http.StatusOK, decision)
+}
+
 // Универсальная функция для отправки JSON-ответов
 func sendJSONResponse(w http.ResponseWriter, statusCode int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
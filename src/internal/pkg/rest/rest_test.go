@@ -0,0 +1,213 @@
+package rest
+
+import (
+	"bytes"
+	"github.com/gorilla/mux"
+	"imgserver/internal/pkg/metrics"
+	"imgserver/internal/pkg/promptmanager"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRest() *Rest {
+	return &Rest{logger: slog.New(slog.NewTextHandler(os.Stdout, nil))}
+}
+
+func TestRest_ServeJpegFile(t *testing.T) {
+	rest := newTestRest()
+
+	fileName := filepath.Join(t.TempDir(), "f1.jpeg")
+	content := []byte("0123456789abcdef")
+	require.NoError(t, os.WriteFile(fileName, content, 0644))
+
+	// Полный файл
+	req := httptest.NewRequest(http.MethodGet, "/operation/result/i1/image", nil)
+	rec := httptest.NewRecorder()
+	assert.True(t, rest.serveJpegFile(rec, req, fileName))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/jpeg", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "16", rec.Header().Get("Content-Length"))
+	assert.NotEmpty(t, rec.Header().Get("Last-Modified"))
+	etag := rec.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	assert.Equal(t, content, rec.Body.Bytes())
+
+	// Докачка с 10 байта
+	req = httptest.NewRequest(http.MethodGet, "/operation/result/i1/image", nil)
+	req.Header.Set("Range", "bytes=10-")
+	req.Header.Set("If-Range", etag)
+	rec = httptest.NewRecorder()
+	assert.True(t, rest.serveJpegFile(rec, req, fileName))
+
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "bytes 10-15/16", rec.Header().Get("Content-Range"))
+	body, _ := io.ReadAll(rec.Body)
+	assert.Equal(t, []byte("abcdef"), body)
+
+	// Файл не изменился
+	req = httptest.NewRequest(http.MethodGet, "/operation/result/i1/image", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	assert.True(t, rest.serveJpegFile(rec, req, fileName))
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+
+	// Файла нет
+	rec = httptest.NewRecorder()
+	assert.False(t, rest.serveJpegFile(rec, req, filepath.Join(t.TempDir(), "absent.jpeg")))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestRest_DeviceIdFromRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/operation/start?device=kitchen", nil)
+	assert.Equal(t, "kitchen", deviceIdFromRequest(req))
+
+	// Заголовок важнее параметра
+	req.Header.Set(HEADER_DEVICE_ID, "hall")
+	assert.Equal(t, "hall", deviceIdFromRequest(req))
+
+	req = httptest.NewRequest(http.MethodPost, "/operation/start", nil)
+	assert.Empty(t, deviceIdFromRequest(req))
+}
+
+func TestRest_ParseImageListQuery(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/images", nil)
+	filter, offset, limit, err := parseImageListQuery(req)
+	require.NoError(t, err)
+	assert.Equal(t, 0, offset)
+	assert.Equal(t, DEFAULT_IMAGES_LIMIT, limit)
+	assert.True(t, filter.From.IsZero())
+
+	req = httptest.NewRequest(http.MethodGet, "/images?offset=20&limit=1000&provider=YDART&prompt=fox&from=2024-03-10&to=2024-03-10", nil)
+	filter, offset, limit, err = parseImageListQuery(req)
+	require.NoError(t, err)
+	assert.Equal(t, 20, offset)
+	assert.Equal(t, MAX_IMAGES_LIMIT, limit)
+	assert.Equal(t, "YDART", filter.ProviderCode)
+	assert.Equal(t, "fox", filter.Prompt)
+	// Дата конца периода включает весь день
+	assert.Equal(t, filter.From.AddDate(0, 0, 1), filter.To)
+
+	req = httptest.NewRequest(http.MethodGet, "/images?from=2024-03-10T12:00:00Z", nil)
+	filter, _, _, err = parseImageListQuery(req)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC), filter.From.UTC())
+
+	for _, query := range []string{"offset=-1", "limit=0", "limit=abc", "from=yesterday"} {
+		req = httptest.NewRequest(http.MethodGet, "/images?"+query, nil)
+		_, _, _, err = parseImageListQuery(req)
+		assert.Error(t, err, query)
+	}
+}
+
+func TestRest_UploadImagesBadRequest(t *testing.T) {
+	rest := newTestRest()
+	rest.metrics = metrics.NewAppMetrics()
+
+	// Форма без файлов
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	require.NoError(t, writer.WriteField("comment", "no files"))
+	require.NoError(t, writer.Close())
+	req := httptest.NewRequest(http.MethodPost, "/images", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rec := httptest.NewRecorder()
+	rest.handleUploadImages(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Слишком большой запрос
+	body.Reset()
+	writer = multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile(UPLOAD_FIELD, "big.jpeg")
+	require.NoError(t, err)
+	_, err = part.Write(make([]byte, MAX_UPLOAD_SIZE+1))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	req = httptest.NewRequest(http.MethodPost, "/images", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rec = httptest.NewRecorder()
+	rest.handleUploadImages(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
+
+func TestRest_EnqueueShowNextBadRequest(t *testing.T) {
+	rest := newTestRest()
+	rest.metrics = metrics.NewAppMetrics()
+
+	req := httptest.NewRequest(http.MethodPost, "/frame/next", bytes.NewBufferString("{not json"))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	rest.handleEnqueueShowNext(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "BadRequest")
+
+	// Неверный repeat отклоняется до сохранения файлов: менеджер операций не вызывается
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile(UPLOAD_FIELD, "cat.jpeg")
+	require.NoError(t, err)
+	_, err = part.Write([]byte("image"))
+	require.NoError(t, err)
+	require.NoError(t, writer.WriteField("repeat", "many"))
+	require.NoError(t, writer.Close())
+	req = httptest.NewRequest(http.MethodPost, "/frame/next", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rec = httptest.NewRecorder()
+	rest.handleEnqueueShowNext(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Invalid repeat")
+}
+
+func TestRest_PromptsErrors(t *testing.T) {
+	rest := newTestRest()
+	rest.metrics = metrics.NewAppMetrics()
+	filePath := filepath.Join(t.TempDir(), "prompts.yaml")
+	require.NoError(t, os.WriteFile(filePath, []byte("prompts:\n  - idx: 1\n    prompt: \"cat\"\n"), 0644))
+	promptManager, err := promptmanager.NewPromptManagerWithFile(filePath, 10, rest.logger)
+	require.NoError(t, err)
+	rest.promptManager = promptManager
+
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+		vars    map[string]string
+		body    string
+		status  int
+	}{
+		{"create", rest.handleCreatePrompt, nil, `{"prompt":"dog"}`, http.StatusCreated},
+		{"duplicate", rest.handleCreatePrompt, nil, `{"prompt":"cat"}`, http.StatusConflict},
+		{"missing placeholder", rest.handleCreatePrompt, nil, `{"prompt":"[[Color]] cat"}`, http.StatusBadRequest},
+		{"bad json", rest.handleCreatePrompt, nil, `{`, http.StatusBadRequest},
+		{"unknown prompt", rest.handleGetPrompt, map[string]string{"idx": "5"}, "", http.StatusNotFound},
+		{"unknown placeholder", rest.handleDeleteGlobalPlaceholder, map[string]string{"name": "Color"}, "", http.StatusNotFound},
+		{"placeholder", rest.handleSetPromptPlaceholder, map[string]string{"idx": "2", "name": "Color"}, `{"values":["red"]}`, http.StatusOK},
+		{"preview", rest.handlePreviewPrompt, nil, `{"idx":2,"count":3}`, http.StatusOK},
+		{"preview template", rest.handlePreviewPrompt, nil, `{"prompt":"[[Size]] cat","placeholders":{"Size":["big"]}}`, http.StatusOK},
+		{"preview both", rest.handlePreviewPrompt, nil, `{"idx":1,"prompt":"cat"}`, http.StatusBadRequest},
+		{"preview unknown", rest.handlePreviewPrompt, nil, `{"idx":7}`, http.StatusNotFound},
+		{"preview count", rest.handlePreviewPrompt, nil, `{"idx":1,"count":1000}`, http.StatusBadRequest},
+		{"delete", rest.handleDeletePrompt, map[string]string{"idx": "1"}, "", http.StatusNoContent},
+	}
+	for _, c := range cases {
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/prompts", strings.NewReader(c.body)), c.vars)
+		rec := httptest.NewRecorder()
+		c.handler(rec, req)
+		assert.Equal(t, c.status, rec.Code, c.name)
+	}
+
+	prompts := promptManager.GetPrompts()
+	require.Len(t, prompts, 1)
+	assert.Equal(t, 1, prompts[0].Idx)
+	assert.Equal(t, []string{"red"}, prompts[0].Placeholders["Color"])
+}
@@ -0,0 +1,529 @@
+package stablediffusion
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"imgserver/internal/pkg/actioner"
+	"imgserver/internal/pkg/asyncjob"
+	"imgserver/internal/pkg/httpclient"
+	"imgserver/internal/pkg/imageprocessor"
+	"imgserver/internal/pkg/opermanager"
+	"imgserver/internal/pkg/promptmanager"
+	"imgserver/internal/pkg/timerange"
+	"imgserver/internal/pkg/utils"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	ProviderCode = "StableDiffusion"
+
+	BackendAutomatic1111 = "automatic1111"
+	BackendComfyUI       = "comfyui"
+
+	defaultTimeout = 300
+	defaultMaxSide = 768
+	defaultSteps   = 25
+	sizeMultiple   = 8
+)
+
+var _ opermanager.ImageProvider = (*StableDiffusion)(nil)
+var _ opermanager.GenerationInfoProvider = (*StableDiffusion)(nil)
+
+type SdSleepTime struct {
+	TimeRange *timerange.TimeRange `yaml:"time_range"`
+}
+
+type SdOptions struct {
+	ImageGenerateThreshold int           `yaml:"image_generate_threshold"`
+	SleepTimes             []SdSleepTime `yaml:"sleep_time"`
+	Backend                string        `yaml:"backend"`
+	BaseURL                string        `yaml:"base_url"`
+	Steps                  int           `yaml:"steps"`
+	Sampler                string        `yaml:"sampler"`
+	Scheduler              string        `yaml:"scheduler"`
+	CfgScale               float64       `yaml:"cfg_scale"`
+	// Seed не задан или -1 - случайный
+	Seed *int64 `yaml:"seed"`
+	// MaxSide длина большей стороны изображения. Пропорции берутся из параметров рамки
+	MaxSide        int                     `yaml:"max_side"`
+	Checkpoint     string                  `yaml:"checkpoint"`
+	WorkflowFile   string                  `yaml:"workflow_file"`
+	TimeoutSeconds int                     `yaml:"timeout_seconds"`
+	Retry          httpclient.RetryOptions `yaml:"retry"`
+}
+
+// WorkflowParameters значения, доступные в шаблоне workflow ComfyUI.
+// Строки уже экранированы для вставки внутрь JSON-строки: "text": "{{.Prompt}}"
+type WorkflowParameters struct {
+	Prompt     string
+	Negative   string
+	Seed       int64
+	Steps      int
+	Sampler    string
+	Scheduler  string
+	CfgScale   float64
+	Width      int
+	Height     int
+	Checkpoint string
+}
+
+type StableDiffusion struct {
+	httpClient      *httpclient.Client
+	logger          *slog.Logger
+	options         *SdOptions
+	imageParameters *opermanager.ImageParameters
+	promptManager   *promptmanager.PromptManager
+	actioner        *actioner.Actioner
+	ipr             *imageprocessor.Ipr
+	properties      *opermanager.ProviderProperties
+	jobs            *asyncjob.Jobs
+	workflow        *template.Template
+	clientId        string
+	*opermanager.GenerationInfoCache
+}
+
+type txt2imgRequest struct {
+	Prompt         string  `json:"prompt"`
+	NegativePrompt string  `json:"negative_prompt,omitempty"`
+	Steps          int     `json:"steps"`
+	SamplerName    string  `json:"sampler_name,omitempty"`
+	Scheduler      string  `json:"scheduler,omitempty"`
+	CfgScale       float64 `json:"cfg_scale,omitempty"`
+	Seed           int64   `json:"seed"`
+	Width          int     `json:"width"`
+	Height         int     `json:"height"`
+	BatchSize      int     `json:"batch_size"`
+}
+
+type txt2imgResponse struct {
+	Images []string `json:"images"`
+	Info   string   `json:"info"`
+}
+
+type comfyPromptRequest struct {
+	Prompt   json.RawMessage `json:"prompt"`
+	ClientId string          `json:"client_id"`
+}
+
+type comfyPromptResponse struct {
+	PromptId   string          `json:"prompt_id"`
+	NodeErrors json.RawMessage `json:"node_errors"`
+}
+
+type comfyHistoryItem struct {
+	Status struct {
+		StatusStr string `json:"status_str"`
+		Completed bool   `json:"completed"`
+	} `json:"status"`
+	Outputs map[string]struct {
+		Images []comfyImage `json:"images"`
+	} `json:"outputs"`
+}
+
+type comfyImage struct {
+	Filename  string `json:"filename"`
+	Subfolder string `json:"subfolder"`
+	Type      string `json:"type"`
+}
+
+func NewStableDiffusion(imageParameters imageprocessor.ImageParameters, promptManager *promptmanager.PromptManager, logger *slog.Logger, options *SdOptions) *StableDiffusion {
+	if options.Backend == "" {
+		options.Backend = BackendAutomatic1111
+	}
+	options.BaseURL = strings.TrimRight(options.BaseURL, "/")
+	if options.Steps <= 0 {
+		options.Steps = defaultSteps
+	}
+	if options.MaxSide <= 0 {
+		options.MaxSide = defaultMaxSide
+	}
+	if options.TimeoutSeconds <= 0 {
+		options.TimeoutSeconds = defaultTimeout
+	}
+
+	return &StableDiffusion{
+		httpClient:          httpclient.NewClient(time.Duration(options.TimeoutSeconds)*time.Second, options.Retry, logger),
+		logger:              logger,
+		options:             options,
+		promptManager:       promptManager,
+		actioner:            actioner.NewActioner(options.ImageGenerateThreshold, time.Minute),
+		ipr:                 imageprocessor.NewIpr(imageParameters, logger),
+		jobs:                asyncjob.NewJobs("sd"),
+		GenerationInfoCache: opermanager.NewGenerationInfoCache(),
+		clientId:            "imgserver-" + utils.NewUlid(),
+		properties: &opermanager.ProviderProperties{
+			IsCanWorkWithPrompt:  true,
+			IsNeedSaveLocalFiles: true,
+		},
+	}
+}
+
+func (sd *StableDiffusion) Start() error {
+	if sd.options.BaseURL == "" {
+		return fmt.Errorf("stable diffusion base_url is empty")
+	}
+
+	switch sd.options.Backend {
+	case BackendAutomatic1111:
+		return nil
+	case BackendComfyUI:
+		text := defaultComfyWorkflow
+		if sd.options.WorkflowFile == "" && sd.options.Checkpoint == "" {
+			// Встроенный workflow без модели ComfyUI отклонит при каждой генерации
+			return fmt.Errorf("stable diffusion checkpoint is empty for default comfyui workflow")
+		}
+		if sd.options.WorkflowFile != "" {
+			data, err := os.ReadFile(sd.options.WorkflowFile)
+			if err != nil {
+				return fmt.Errorf("can not read workflow file '%s': %w", sd.options.WorkflowFile, err)
+			}
+			text = string(data)
+		}
+		workflow, err := template.New("workflow").Option("missingkey=error").Parse(text)
+		if err != nil {
+			return fmt.Errorf("can not parse workflow template: %w", err)
+		}
+		sd.workflow = workflow
+		return nil
+	default:
+		return fmt.Errorf("unknown stable diffusion backend: %s", sd.options.Backend)
+	}
+}
+
+func (sd *StableDiffusion) SetImageParameters(parameters *opermanager.ImageParameters) error {
+	sd.imageParameters = parameters
+	return nil
+}
+
+func (sd *StableDiffusion) GetImageProviderForImageServerName() string {
+	return "StableDiffusion"
+}
+
+func (sd *StableDiffusion) GetImageProviderCode() string {
+	return ProviderCode
+}
+
+func (sd *StableDiffusion) GetProperties() *opermanager.ProviderProperties {
+	return sd.properties
+}
+
+func (sd *StableDiffusion) Generate(isDirectCall bool) (string, error) {
+	prompt, err := sd.promptManager.GetRandomPrompt()
+	if err != nil {
+		sd.logger.Error("Error when get prompt", "error", err.Error())
+		return "", err
+	}
+
+	negative := ""
+	if prompt.Negative != nil {
+		negative = *prompt.Negative
+	}
+	id, err := sd.GenerateWithPrompt(prompt.Prompt, negative, isDirectCall)
+	if err == nil {
+		sd.SetPromptIdx(id, prompt.Idx)
+	}
+	return id, err
+}
+
+func (sd *StableDiffusion) GenerateWithPrompt(prompt string, negative string, isDirectCall bool) (string, error) {
+	if prompt == "" {
+		return "", fmt.Errorf("prompt is empty")
+	}
+
+	width, height := sd.imageSize()
+	sd.logger.Debug("generate with prompt", "backend", sd.options.Backend, "prompt", prompt, "negative", negative,
+		"width", width, "height", height, "isDirect", isDirectCall)
+
+	// Seed выбирается здесь, чтобы случайный seed попал в параметры генерации и картинку можно было повторить
+	seed := sd.seed()
+
+	var id string
+	var err error
+	if sd.options.Backend == BackendComfyUI {
+		id, err = sd.queueComfyPrompt(prompt, negative, seed, width, height)
+	} else {
+		request := txt2imgRequest{
+			Prompt:         prompt,
+			NegativePrompt: strings.TrimSpace(negative),
+			Steps:          sd.options.Steps,
+			SamplerName:    sd.options.Sampler,
+			Scheduler:      sd.options.Scheduler,
+			CfgScale:       sd.options.CfgScale,
+			Seed:           seed,
+			Width:          width,
+			Height:         height,
+			BatchSize:      1,
+		}
+		id = sd.jobs.Start(func() ([]byte, error) {
+			image, err := sd.txt2img(request)
+			if err != nil {
+				sd.logger.Error("Stable diffusion generation error", "error", err)
+			}
+			return image, err
+		})
+	}
+
+	if err != nil {
+		resultError := fmt.Errorf("error generate image: %v", err)
+		sd.logger.Error(resultError.Error())
+		return "", resultError
+	}
+
+	if !isDirectCall {
+		sd.actioner.SetLastCallTime(time.Now())
+	}
+
+	sd.PutGenerationInfo(id, &opermanager.GenerationInfo{
+		Prompt:   prompt,
+		Negative: negative,
+		Model:    sd.options.Checkpoint,
+		Seed:     &seed,
+	})
+
+	sd.logger.Debug("Stable diffusion operation id", "id", id, "seed", seed)
+	return id, nil
+}
+
+func (sd *StableDiffusion) GetImageSlice(operationId string) (bool, []byte, error) {
+	if sd.options.Backend == BackendComfyUI {
+		return sd.comfyResult(operationId)
+	}
+	return sd.jobs.Result(operationId)
+}
+
+func (sd *StableDiffusion) IsReadyForRequest() bool {
+	if !sd.actioner.ThresholdOut(time.Now()) {
+		// Провайдер вызывался недавно. Он не готов к новому вызову.
+		return false
+	}
+
+	now := time.Now()
+	for _, st := range sd.options.SleepTimes {
+		inclusive, err := st.TimeRange.IsWithinRangeInclusive(now)
+		if err != nil {
+			sd.logger.Error("Get time range error", "error", err)
+		}
+		if inclusive {
+			return false
+		}
+	}
+
+	return true
+}
+
+// imageSize размер генерации с пропорциями рамки: большая сторона равна max_side,
+// обе стороны кратны 8
+func (sd *StableDiffusion) imageSize() (int, int) {
+	maxSide := sd.options.MaxSide
+	if sd.imageParameters == nil || sd.imageParameters.Weight <= 0 || sd.imageParameters.Height <= 0 {
+		return maxSide, maxSide
+	}
+
+	w := float64(sd.imageParameters.Weight)
+	h := float64(sd.imageParameters.Height)
+	if w >= h {
+		return roundToMultiple(float64(maxSide)), roundToMultiple(float64(maxSide) * h / w)
+	}
+	return roundToMultiple(float64(maxSide) * w / h), roundToMultiple(float64(maxSide))
+}
+
+func (sd *StableDiffusion) seed() int64 {
+	if sd.options.Seed != nil && *sd.options.Seed >= 0 {
+		return *sd.options.Seed
+	}
+	return rand.Int63n(1 << 32)
+}
+
+func (sd *StableDiffusion) txt2img(request txt2imgRequest) ([]byte, error) {
+	var response txt2imgResponse
+	err := sd.innerRequest(http.MethodPost, sd.options.BaseURL+"/sdapi/v1/txt2img", request, &response)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Images) == 0 {
+		return nil, fmt.Errorf("txt2img return no images")
+	}
+
+	// Некоторые сборки возвращают data URI
+	image := response.Images[0]
+	if idx := strings.Index(image, ","); strings.HasPrefix(image, "data:") && idx > 0 {
+		image = image[idx+1:]
+	}
+	return sd.ipr.ConvertBase64ToJpg(image)
+}
+
+func (sd *StableDiffusion) queueComfyPrompt(prompt string, negative string, seed int64, width int, height int) (string, error) {
+	parameters := WorkflowParameters{
+		Prompt:     utils.EscapeJSONString(prompt),
+		Negative:   utils.EscapeJSONString(strings.TrimSpace(negative)),
+		Seed:       seed,
+		Steps:      sd.options.Steps,
+		Sampler:    utils.EscapeJSONString(sd.options.Sampler),
+		Scheduler:  utils.EscapeJSONString(sd.options.Scheduler),
+		CfgScale:   sd.options.CfgScale,
+		Width:      width,
+		Height:     height,
+		Checkpoint: utils.EscapeJSONString(sd.options.Checkpoint),
+	}
+	if parameters.Sampler == "" {
+		parameters.Sampler = "euler"
+	}
+	if parameters.Scheduler == "" {
+		parameters.Scheduler = "normal"
+	}
+	if parameters.CfgScale == 0 {
+		parameters.CfgScale = 7
+	}
+
+	buf := new(bytes.Buffer)
+	if err := sd.workflow.Execute(buf, parameters); err != nil {
+		return "", fmt.Errorf("can not execute workflow template: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return "", fmt.Errorf("workflow template produce invalid JSON")
+	}
+
+	var response comfyPromptResponse
+	err := sd.innerRequest(http.MethodPost, sd.options.BaseURL+"/prompt",
+		comfyPromptRequest{Prompt: buf.Bytes(), ClientId: sd.clientId}, &response)
+	if err != nil {
+		return "", err
+	}
+	if response.PromptId == "" {
+		return "", fmt.Errorf("ComfyUI return empty prompt_id: %s", string(response.NodeErrors))
+	}
+	return response.PromptId, nil
+}
+
+func (sd *StableDiffusion) comfyResult(promptId string) (bool, []byte, error) {
+	var history map[string]comfyHistoryItem
+	err := sd.innerRequest(http.MethodGet, sd.options.BaseURL+"/history/"+url.PathEscape(promptId), nil, &history)
+	if err != nil {
+		return false, nil, fmt.Errorf("error when get history: %v", err)
+	}
+
+	item, ok := history[promptId]
+	if !ok {
+		// Задание ещё в очереди или выполняется
+		return false, nil, nil
+	}
+
+	if item.Status.StatusStr == "error" {
+		return true, nil, fmt.Errorf("ComfyUI prompt %s finished with error", promptId)
+	}
+
+	for _, output := range item.Outputs {
+		for _, image := range output.Images {
+			if image.Type != "" && image.Type != "output" {
+				continue
+			}
+			data, err := sd.comfyView(image)
+			if err != nil {
+				return false, nil, err
+			}
+			jpg, err := sd.ipr.ConvertSliceToJpg(data)
+			if err != nil {
+				return true, nil, fmt.Errorf("error image processing: %v", err)
+			}
+			return true, jpg, nil
+		}
+	}
+
+	if item.Status.Completed {
+		return true, nil, fmt.Errorf("ComfyUI prompt %s has no output images", promptId)
+	}
+	return false, nil, nil
+}
+
+func (sd *StableDiffusion) comfyView(image comfyImage) ([]byte, error) {
+	query := url.Values{}
+	query.Set("filename", image.Filename)
+	query.Set("subfolder", image.Subfolder)
+	query.Set("type", image.Type)
+
+	resp, err := sd.httpClient.Get(sd.options.BaseURL + "/view?" + query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("error when download image: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code when download image: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (sd *StableDiffusion) innerRequest(method string, url string, requestBody interface{}, result interface{}) error {
+	sd.logger.Debug("Execute request", "method", method, "url", url)
+
+	var body io.Reader
+	if requestBody != nil {
+		jsonData, err := json.Marshal(requestBody)
+		if err != nil {
+			return fmt.Errorf("error when data marshalling: %v", err)
+		}
+		body = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return fmt.Errorf("error when create request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if requestBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	// POST генерации повторяется, только если сервер его не выполнял, иначе GPU займётся картинкой дважды
+	resp, err := sd.httpClient.Do(httpclient.WithSafeRetry(req))
+	if err != nil {
+		return fmt.Errorf("error when execute request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error when read body: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		sd.logger.Error("Get response", "status", resp.Status, "body", string(respBody))
+		return fmt.Errorf("unexpected status code: %s", resp.Status)
+	}
+
+	if err := json.Unmarshal(respBody, result); err != nil {
+		return fmt.Errorf("error when parse body: %v", err)
+	}
+	return nil
+}
+
+func roundToMultiple(value float64) int {
+	result := int(value/sizeMultiple+0.5) * sizeMultiple
+	if result < sizeMultiple {
+		return sizeMultiple
+	}
+	return result
+}
+
+// Стандартный txt2img workflow ComfyUI (формат API)
+const defaultComfyWorkflow = `{
+  "3": {"class_type": "KSampler", "inputs": {
+    "seed": {{.Seed}}, "steps": {{.Steps}}, "cfg": {{.CfgScale}},
+    "sampler_name": "{{.Sampler}}", "scheduler": "{{.Scheduler}}", "denoise": 1,
+    "model": ["4", 0], "positive": ["6", 0], "negative": ["7", 0], "latent_image": ["5", 0]}},
+  "4": {"class_type": "CheckpointLoaderSimple", "inputs": {"ckpt_name": "{{.Checkpoint}}"}},
+  "5": {"class_type": "EmptyLatentImage", "inputs": {"width": {{.Width}}, "height": {{.Height}}, "batch_size": 1}},
+  "6": {"class_type": "CLIPTextEncode", "inputs": {"text": "{{.Prompt}}", "clip": ["4", 1]}},
+  "7": {"class_type": "CLIPTextEncode", "inputs": {"text": "{{.Negative}}", "clip": ["4", 1]}},
+  "8": {"class_type": "VAEDecode", "inputs": {"samples": ["3", 0], "vae": ["4", 2]}},
+  "9": {"class_type": "SaveImage", "inputs": {"filename_prefix": "imgserver", "images": ["8", 0]}}
+}`
@@ -0,0 +1,170 @@
+package stablediffusion
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"imgserver/internal/pkg/imageprocessor"
+	"imgserver/internal/pkg/opermanager"
+	"imgserver/internal/pkg/testutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestProvider(options *SdOptions) *StableDiffusion {
+	logger := testutil.NewLogger()
+	sd := NewStableDiffusion(imageprocessor.ImageParameters{ImageWeight: 320, ImageHeight: 480}, nil, logger, options)
+	_ = sd.SetImageParameters(&opermanager.ImageParameters{Weight: 320, Height: 480})
+	return sd
+}
+
+func TestStableDiffusion_Automatic1111(t *testing.T) {
+	pngData := testutil.CreatePng(t)
+	var got txt2imgRequest
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/sdapi/v1/txt2img", r.URL.Path)
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_ = json.NewEncoder(w).Encode(txt2imgResponse{Images: []string{base64.StdEncoding.EncodeToString(pngData)}})
+	}))
+	defer server.Close()
+
+	seed := int64(42)
+	sd := newTestProvider(&SdOptions{BaseURL: server.URL, Steps: 30, Sampler: "DPM++ 2M", Seed: &seed})
+	require.NoError(t, sd.Start())
+
+	id, err := sd.GenerateWithPrompt("cat", "blurry", true)
+	require.NoError(t, err)
+
+	data, err := testutil.WaitImage(t, sd, id)
+	require.NoError(t, err)
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+
+	assert.Equal(t, "cat", got.Prompt)
+	assert.Equal(t, "blurry", got.NegativePrompt)
+	assert.Equal(t, 30, got.Steps)
+	assert.Equal(t, "DPM++ 2M", got.SamplerName)
+	assert.Equal(t, int64(42), got.Seed)
+	info := sd.GetGenerationInfo(id)
+	require.NotNil(t, info)
+	require.NotNil(t, info.Seed)
+	assert.Equal(t, int64(42), *info.Seed)
+	assert.Equal(t, "cat", info.Prompt)
+	assert.Equal(t, 512, got.Width)
+	assert.Equal(t, 768, got.Height)
+}
+
+func TestStableDiffusion_ComfyUI(t *testing.T) {
+	pngData := testutil.CreatePng(t)
+	var mu sync.Mutex
+	var workflow map[string]json.RawMessage
+	historyCalls := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/prompt", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Prompt map[string]json.RawMessage `json:"prompt"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		workflow = req.Prompt
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"prompt_id":"p-1","number":1,"node_errors":{}}`))
+	})
+	mux.HandleFunc("/history/p-1", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		historyCalls++
+		calls := historyCalls
+		mu.Unlock()
+		if calls == 1 {
+			// Ещё в очереди
+			_, _ = w.Write([]byte(`{}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"p-1":{"status":{"status_str":"success","completed":true},
+			"outputs":{"9":{"images":[{"filename":"imgserver_0001.png","subfolder":"","type":"output"}]}}}}`))
+	})
+	mux.HandleFunc("/view", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "imgserver_0001.png", r.URL.Query().Get("filename"))
+		_, _ = w.Write(pngData)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	sd := newTestProvider(&SdOptions{Backend: BackendComfyUI, BaseURL: server.URL, Checkpoint: "sd15.safetensors"})
+	require.NoError(t, sd.Start())
+
+	id, err := sd.GenerateWithPrompt(`cat "in hat"`, "blurry", true)
+	require.NoError(t, err)
+	assert.Equal(t, "p-1", id)
+
+	done, _, err := sd.GetImageSlice(id)
+	require.NoError(t, err)
+	assert.False(t, done)
+
+	data, err := testutil.WaitImage(t, sd, id)
+	require.NoError(t, err)
+	assert.NotEmpty(t, data)
+
+	mu.Lock()
+	defer mu.Unlock()
+	var positive struct {
+		Inputs struct {
+			Text string `json:"text"`
+		} `json:"inputs"`
+	}
+	require.NoError(t, json.Unmarshal(workflow["6"], &positive))
+	assert.Equal(t, `cat "in hat"`, positive.Inputs.Text)
+
+	var latent struct {
+		Inputs struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"inputs"`
+	}
+	require.NoError(t, json.Unmarshal(workflow["5"], &latent))
+	assert.Equal(t, 512, latent.Inputs.Width)
+	assert.Equal(t, 768, latent.Inputs.Height)
+
+	// Случайный seed запоминается тот же, что ушёл в workflow
+	var sampler struct {
+		Inputs struct {
+			Seed int64 `json:"seed"`
+		} `json:"inputs"`
+	}
+	require.NoError(t, json.Unmarshal(workflow["3"], &sampler))
+	info := sd.GetGenerationInfo(id)
+	require.NotNil(t, info)
+	require.NotNil(t, info.Seed)
+	assert.Equal(t, sampler.Inputs.Seed, *info.Seed)
+	assert.Equal(t, "sd15.safetensors", info.Model)
+}
+
+func TestStableDiffusion_ImageSize(t *testing.T) {
+	sd := newTestProvider(&SdOptions{BaseURL: "http://localhost", MaxSide: 1024})
+	_ = sd.SetImageParameters(&opermanager.ImageParameters{Weight: 800, Height: 480})
+	w, h := sd.imageSize()
+	assert.Equal(t, 1024, w)
+	assert.Equal(t, 616, h)
+}
+
+func TestStableDiffusion_ComfyUICheckpointRequired(t *testing.T) {
+	sd := newTestProvider(&SdOptions{Backend: BackendComfyUI, BaseURL: "http://localhost"})
+	assert.Error(t, sd.Start())
+
+	// Свой workflow может не использовать checkpoint
+	workflowFile := filepath.Join(t.TempDir(), "workflow.json")
+	require.NoError(t, os.WriteFile(workflowFile, []byte(`{"prompt": "{{.Prompt}}"}`), 0644))
+	sd = newTestProvider(&SdOptions{Backend: BackendComfyUI, BaseURL: "http://localhost", WorkflowFile: workflowFile})
+	assert.NoError(t, sd.Start())
+}
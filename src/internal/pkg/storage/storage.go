@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Storage встроенное хранилище ключ-значение на диске.
+// Значения хранятся в формате JSON, сгруппированные по бакетам.
+type Storage struct {
+	db     *bolt.DB
+	path   string
+	logger *slog.Logger
+}
+
+// NewStorage открывает (или создаёт) файл хранилища
+func NewStorage(path string, logger *slog.Logger) (*Storage, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("can not open storage '%s': %w", path, err)
+	}
+	logger.Debug("Storage opened", "path", path)
+	return &Storage{db: db, path: path, logger: logger}, nil
+}
+
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// Put сохраняет значение под ключом key в бакете bucket
+func (s *Storage) Put(bucket string, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("can not marshal value: %w", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return fmt.Errorf("can not create bucket '%s': %w", bucket, err)
+		}
+		return b.Put([]byte(key), data)
+	})
+}
+
+// Get читает значение по ключу. Возвращает false, если ключ не найден
+func (s *Storage) Get(bucket string, key string, value interface{}) (bool, error) {
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		if v := b.Get([]byte(key)); v != nil {
+			data = make([]byte, len(v))
+			copy(data, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if data == nil {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(data, value); err != nil {
+		return false, fmt.Errorf("can not unmarshal value '%s': %w", key, err)
+	}
+	return true, nil
+}
+
+// Delete удаляет ключ из бакета. Отсутствие ключа ошибкой не считается
+func (s *Storage) Delete(bucket string, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+// ForEach перебирает все записи бакета в порядке возрастания ключей.
+// Срез data действителен только внутри fn
+func (s *Storage) ForEach(bucket string, fn func(key string, data []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			return fn(string(k), v)
+		})
+	})
+}
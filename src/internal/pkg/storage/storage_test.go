@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testValue struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestStorage_PutGetDelete(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	s, err := NewStorage(path, logger)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Put("bucket", "k1", testValue{Name: "first", Count: 1}))
+	require.NoError(t, s.Put("bucket", "k2", testValue{Name: "second", Count: 2}))
+
+	var v testValue
+	found, err := s.Get("bucket", "k1", &v)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, testValue{Name: "first", Count: 1}, v)
+
+	found, err = s.Get("unknown", "k1", &v)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, s.Delete("bucket", "k1"))
+	found, err = s.Get("bucket", "k1", &v)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// Данные переживают переоткрытие файла
+	require.NoError(t, s.Close())
+	s, err = NewStorage(path, logger)
+	require.NoError(t, err)
+	defer s.Close()
+
+	keys := make([]string, 0)
+	err = s.ForEach("bucket", func(key string, data []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"k2"}, keys)
+}
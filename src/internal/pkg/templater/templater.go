@@ -67,6 +67,12 @@ func (tp *TemplateProcessor) ValidatePlaceholders(template string, values map[st
 	return result, missing
 }
 
+// IsValidPlaceholderName проверяет, что имя можно использовать в шаблоне как [[name]]
+func (tp *TemplateProcessor) IsValidPlaceholderName(name string) bool {
+	placeholder := "[[" + name + "]]"
+	return tp.placeholderPattern.FindString(placeholder) == placeholder
+}
+
 // IsContainPlaceholders определяет есть ли в строке хотя бы один плейсхолдер
 func (tp *TemplateProcessor) IsContainPlaceholders(template string) bool {
 	return tp.placeholderPattern.MatchString(template)
@@ -287,3 +287,19 @@ func TestPlaceholderPattern(t *testing.T) {
 		})
 	}
 }
+
+// TestTemplateProcessor_IsValidPlaceholderName тестирует проверку имени плейсхолдера
+func TestTemplateProcessor_IsValidPlaceholderName(t *testing.T) {
+	tp := NewTemplateProcessor()
+
+	for _, name := range []string{"Fruits", "user_name", "user-id", "A1"} {
+		if !tp.IsValidPlaceholderName(name) {
+			t.Errorf("IsValidPlaceholderName(%q) = false, want true", name)
+		}
+	}
+	for _, name := range []string{"", "user name", "user.name", "a]][[b"} {
+		if tp.IsValidPlaceholderName(name) {
+			t.Errorf("IsValidPlaceholderName(%q) = true, want false", name)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Package testutil общие помощники для тестов провайдеров
+package testutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// ImageSource провайдер, у которого можно опросить операцию
+type ImageSource interface {
+	GetImageSlice(id string) (bool, []byte, error)
+}
+
+func NewLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stdout, nil))
+}
+
+// CreatePng маленькая PNG-картинка, которую провайдер должен перекодировать в JPEG
+func CreatePng(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 4, 6))
+	img.Set(1, 1, color.RGBA{R: 255, A: 255})
+	buf := new(bytes.Buffer)
+	require.NoError(t, png.Encode(buf, img))
+	return buf.Bytes()
+}
+
+func CreatePngBase64(t *testing.T) string {
+	t.Helper()
+	return base64.StdEncoding.EncodeToString(CreatePng(t))
+}
+
+// WaitImage опрашивает операцию, пока она не завершится. Через 5 секунд тест падает
+func WaitImage(t *testing.T, source ImageSource, id string) ([]byte, error) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		done, data, err := source.GetImageSlice(id)
+		if done {
+			return data, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("operation %s not finished", id)
+	return nil, nil
+}
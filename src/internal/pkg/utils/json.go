@@ -0,0 +1,9 @@
+package utils
+
+import "encoding/json"
+
+// EscapeJSONString экранирует строку для вставки внутрь JSON-строки (без кавычек)
+func EscapeJSONString(value string) string {
+	data, _ := json.Marshal(value)
+	return string(data[1 : len(data)-1])
+}
@@ -0,0 +1,12 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscapeJSONString(t *testing.T) {
+	assert.Equal(t, `cat \"in hat\"\nline\\2`, EscapeJSONString("cat \"in hat\"\nline\\2"))
+	assert.Equal(t, "", EscapeJSONString(""))
+}
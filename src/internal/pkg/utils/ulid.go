@@ -0,0 +1,9 @@
+package utils
+
+import "github.com/oklog/ulid/v2"
+
+// NewUlid возвращает уникальный идентификатор, упорядоченный по времени создания.
+// Идентификаторы, созданные в одну миллисекунду, монотонно возрастают
+func NewUlid() string {
+	return ulid.Make().String()
+}
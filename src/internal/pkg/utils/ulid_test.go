@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewUlid_UniqueAndSorted(t *testing.T) {
+	const amount = 1000
+
+	ids := make([]string, 0, amount)
+	for i := 0; i < amount; i++ {
+		ids = append(ids, NewUlid())
+	}
+	assert.True(t, sort.StringsAreSorted(ids), "ids must be sortable by creation order")
+
+	// Параллельная генерация тоже не даёт совпадений
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	unique := make(map[string]struct{}, amount)
+	for i := 0; i < amount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := NewUlid()
+			mu.Lock()
+			unique[id] = struct{}{}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, unique, amount)
+}
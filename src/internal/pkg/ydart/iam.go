@@ -0,0 +1,228 @@
+package ydart
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"imgserver/internal/pkg/httpclient"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	IamTokenURL = "https://iam.api.cloud.yandex.net/iam/v1/tokens"
+	// Срок жизни JWT, которым подписывается запрос IAM-токена. Яндекс допускает не больше часа
+	jwtLifetime = time.Hour
+	// IAM-токен живёт до 12 часов, но Яндекс рекомендует обновлять его не реже раза в час
+	iamTokenMaxAge = time.Hour
+	// Запас до истечения токена, за который он обновляется
+	iamTokenRefreshBefore = 5 * time.Minute
+)
+
+// authorizedKey авторизованный ключ сервисного аккаунта в формате, который выдаёт Yandex Cloud
+type authorizedKey struct {
+	Id               string `json:"id"`
+	ServiceAccountId string `json:"service_account_id"`
+	PrivateKey       string `json:"private_key"`
+}
+
+type iamTokenRequest struct {
+	Jwt string `json:"jwt"`
+}
+
+type iamTokenResponse struct {
+	IamToken  string    `json:"iamToken"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// iamTokenSource получает IAM-токен по авторизованному ключу сервисного аккаунта и кэширует его
+type iamTokenSource struct {
+	key        authorizedKey
+	privateKey *rsa.PrivateKey
+	tokenURL   string
+	httpClient *httpclient.Client
+	logger     *slog.Logger
+	now        func() time.Time
+
+	token     string
+	refreshAt time.Time
+	mutex     sync.Mutex
+}
+
+func newIamTokenSource(keyFile string, tokenURL string, httpClient *httpclient.Client, logger *slog.Logger) (*iamTokenSource, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("can not read authorized key: %v", err)
+	}
+
+	var key authorizedKey
+	err = json.Unmarshal(data, &key)
+	if err != nil {
+		return nil, fmt.Errorf("can not parse authorized key: %v", err)
+	}
+	if key.Id == "" || key.ServiceAccountId == "" {
+		return nil, fmt.Errorf("authorized key must contain id and service_account_id")
+	}
+
+	privateKey, err := parsePrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if tokenURL == "" {
+		tokenURL = IamTokenURL
+	}
+
+	return &iamTokenSource{
+		key:        key,
+		privateKey: privateKey,
+		tokenURL:   tokenURL,
+		httpClient: httpClient,
+		logger:     logger,
+		now:        time.Now,
+	}, nil
+}
+
+// parsePrivateKey разбирает PEM. Yandex Cloud добавляет перед ключом строку-комментарий, pem.Decode её пропускает
+func parsePrivateKey(privateKeyPem string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(privateKeyPem))
+	if block == nil {
+		return nil, fmt.Errorf("private key is not in PEM format")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("can not parse private key: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not RSA")
+	}
+	return key, nil
+}
+
+// Token возвращает закэшированный токен или получает новый, если старый скоро истечёт
+func (ts *iamTokenSource) Token() (string, error) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	if ts.token != "" && ts.now().Before(ts.refreshAt) {
+		return ts.token, nil
+	}
+
+	token, expiresAt, err := ts.requestToken()
+	if err != nil {
+		return "", err
+	}
+
+	issuedAt := ts.now()
+	refreshAt := issuedAt.Add(iamTokenMaxAge)
+	if !expiresAt.IsZero() && expiresAt.Add(-iamTokenRefreshBefore).Before(refreshAt) {
+		refreshAt = expiresAt.Add(-iamTokenRefreshBefore)
+	}
+
+	ts.token = token
+	ts.refreshAt = refreshAt
+	ts.logger.Debug("IAM token refreshed", "expiresAt", expiresAt, "refreshAt", refreshAt)
+	return token, nil
+}
+
+// Invalidate сбрасывает токен. Следующий запрос получит новый
+func (ts *iamTokenSource) Invalidate() {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	ts.token = ""
+}
+
+func (ts *iamTokenSource) requestToken() (string, time.Time, error) {
+	jwt, err := ts.signJwt()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	jsonData, err := json.Marshal(iamTokenRequest{Jwt: jwt})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error when data marshalling: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ts.tokenURL, bytes.NewReader(jsonData))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error when create IAM token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := ts.httpClient.Do(httpclient.WithSafeRetry(req))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error when request IAM token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error when read IAM token body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		ts.logger.Error("IAM token request failed", "status", resp.Status, "body", string(body))
+		return "", time.Time{}, fmt.Errorf("unexpected IAM token status code: %s", resp.Status)
+	}
+
+	var response iamTokenResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", time.Time{}, fmt.Errorf("error when parse IAM token body: %v", err)
+	}
+	if response.IamToken == "" {
+		return "", time.Time{}, fmt.Errorf("IAM token is empty")
+	}
+
+	return response.IamToken, response.ExpiresAt, nil
+}
+
+// signJwt подписывает JWT алгоритмом PS256, как требует Yandex IAM
+func (ts *iamTokenSource) signJwt() (string, error) {
+	now := ts.now()
+
+	header := map[string]string{
+		"typ": "JWT",
+		"alg": "PS256",
+		"kid": ts.key.Id,
+	}
+	claims := map[string]interface{}{
+		"iss": ts.key.ServiceAccountId,
+		"aud": ts.tokenURL,
+		"iat": now.Unix(),
+		"exp": now.Add(jwtLifetime).Unix(),
+	}
+
+	headerJson, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJson, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJson) + "." + base64.RawURLEncoding.EncodeToString(claimsJson)
+	hash := sha256.Sum256([]byte(signingInput))
+
+	signature, err := rsa.SignPSS(rand.Reader, ts.privateKey, crypto.SHA256, hash[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	if err != nil {
+		return "", fmt.Errorf("can not sign JWT: %v", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
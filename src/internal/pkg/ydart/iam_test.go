@@ -0,0 +1,181 @@
+package ydart
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"imgserver/internal/pkg/httpclient"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeAuthorizedKey создаёт ключ в формате Yandex Cloud, включая строку-комментарий перед PEM
+func writeAuthorizedKey(t *testing.T, privateKey *rsa.PrivateKey) string {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+
+	pemKey := "PLEASE DO NOT REMOVE THIS LINE! Yandex.Cloud SA Key ID <key-id>\n" +
+		string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	data, err := json.Marshal(authorizedKey{Id: "key-id", ServiceAccountId: "sa-id", PrivateKey: pemKey})
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "key.json")
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	return path
+}
+
+func newTestHttpClient() *httpclient.Client {
+	return httpclient.NewClient(time.Second, httpclient.RetryOptions{MaxRetries: -1}, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+}
+
+func verifyJwt(t *testing.T, jwt string, publicKey *rsa.PublicKey, audience string) {
+	parts := strings.Split(jwt, ".")
+	require.Len(t, parts, 3)
+
+	headerJson, err := base64.RawURLEncoding.DecodeString(parts[0])
+	require.NoError(t, err)
+	var header map[string]string
+	require.NoError(t, json.Unmarshal(headerJson, &header))
+	assert.Equal(t, "PS256", header["alg"])
+	assert.Equal(t, "key-id", header["kid"])
+
+	claimsJson, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+	var claims map[string]interface{}
+	require.NoError(t, json.Unmarshal(claimsJson, &claims))
+	assert.Equal(t, "sa-id", claims["iss"])
+	assert.Equal(t, audience, claims["aud"])
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.NoError(t, rsa.VerifyPSS(publicKey, crypto.SHA256, hash[:], signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}))
+}
+
+func TestIamTokenSource_TokenCachedAndRefreshed(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyFile := writeAuthorizedKey(t, privateKey)
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	var calls int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		var request iamTokenRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		verifyJwt(t, request.Jwt, &privateKey.PublicKey, server.URL)
+
+		n := atomic.AddInt32(&calls, 1)
+		json.NewEncoder(w).Encode(map[string]string{
+			"iamToken":  fmt.Sprintf("token-%d", n),
+			"expiresAt": now.Add(12 * time.Hour).Format(time.RFC3339),
+		})
+	}))
+	defer server.Close()
+
+	ts, err := newIamTokenSource(keyFile, server.URL, newTestHttpClient(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	require.NoError(t, err)
+	ts.now = func() time.Time { return now }
+
+	token, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", token)
+
+	// Токен берётся из кэша
+	now = now.Add(30 * time.Minute)
+	token, err = ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", token)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// Через час токен обновляется, хотя ещё не истёк
+	now = now.Add(31 * time.Minute)
+	token, err = ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-2", token)
+
+	ts.Invalidate()
+	token, err = ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "token-3", token)
+}
+
+func TestIamTokenSource_RefreshBeforeExpiry(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyFile := writeAuthorizedKey(t, privateKey)
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		json.NewEncoder(w).Encode(map[string]string{
+			"iamToken":  "token",
+			"expiresAt": now.Add(10 * time.Minute).Format(time.RFC3339),
+		})
+	}))
+	defer server.Close()
+
+	ts, err := newIamTokenSource(keyFile, server.URL, newTestHttpClient(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	require.NoError(t, err)
+	ts.now = func() time.Time { return now }
+
+	_, err = ts.Token()
+	require.NoError(t, err)
+
+	// До истечения осталось меньше запаса - токен обновляется заранее
+	now = now.Add(6 * time.Minute)
+	_, err = ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestIamTokenSource_Errors(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	_, err := newIamTokenSource(filepath.Join(t.TempDir(), "missing.json"), "", newTestHttpClient(), logger)
+	assert.Error(t, err)
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"bad jwt"}`, http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	ts, err := newIamTokenSource(writeAuthorizedKey(t, privateKey), server.URL, newTestHttpClient(), logger)
+	require.NoError(t, err)
+	_, err = ts.Token()
+	assert.ErrorContains(t, err, "401")
+}
+
+func TestYdArt_AuthorizationHeader(t *testing.T) {
+	ydArt := &YdArt{soptions: &YdArtSecretOption{ApiKey: "secret"}}
+
+	header, err := ydArt.authorizationHeader()
+	require.NoError(t, err)
+	assert.Equal(t, "Api-Key secret", header)
+
+	ydArt.tokenSource = &iamTokenSource{token: "iam", refreshAt: time.Now().Add(time.Hour), now: time.Now}
+	header, err = ydArt.authorizationHeader()
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer iam", header)
+}
@@ -0,0 +1,134 @@
+package ydart_test
+
+import (
+	"encoding/json"
+	"imgserver/internal/pkg/dirmanager"
+	"imgserver/internal/pkg/fakeydart"
+	"imgserver/internal/pkg/httpclient"
+	"imgserver/internal/pkg/imageprocessor"
+	"imgserver/internal/pkg/metrics"
+	"imgserver/internal/pkg/opermanager"
+	"imgserver/internal/pkg/storage"
+	"imgserver/internal/pkg/ydart"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testEnv struct {
+	operMng *opermanager.OperMngr
+	storage *storage.Storage
+}
+
+// newTestEnv собирает менеджер операций с YandexArt, который ходит в fakeydart
+func newTestEnv(t *testing.T, fakeOptions fakeydart.Options) *testEnv {
+	// Менеджер операций пишет служебные файлы в текущий каталог
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	fakeOptions.ImageWidth, fakeOptions.ImageHeight = 64, 96
+	fake, err := fakeydart.NewServer(fakeOptions, logger)
+	require.NoError(t, err)
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	imgPrmt := imageprocessor.ImageParameters{ImageWeight: 32, ImageHeight: 48, FitThreshold: 0.03}
+
+	ydArt, err := ydart.NewYdArtWithSecret(imgPrmt, nil, logger,
+		&ydart.YdArtOptions{BaseURL: server.URL, Retry: httpclient.RetryOptions{BaseDelayMs: 1}},
+		ydart.YdArtSecretOption{FolderId: "folder", ApiKey: "key"})
+	require.NoError(t, err)
+	provider := (opermanager.ImageProvider)(ydArt)
+	require.NoError(t, provider.SetImageParameters(&opermanager.ImageParameters{Height: 48, Weight: 32}))
+
+	dirManager, err := dirmanager.NewDirManager(filepath.Join(dir, "images"), 10, 20, logger)
+	require.NoError(t, err)
+	require.NoError(t, dirManager.Start())
+
+	st, err := storage.NewStorage(filepath.Join(dir, "test.db"), logger)
+	require.NoError(t, err)
+	t.Cleanup(func() { st.Close() })
+
+	operMng, err := opermanager.NewOperMngr(0, imgPrmt, nil, dirManager, metrics.NewAppMetrics(), st, logger)
+	require.NoError(t, err)
+	operMng.AddImageProvider(&provider)
+	require.NoError(t, operMng.Start())
+
+	return &testEnv{operMng: operMng, storage: st}
+}
+
+func (env *testEnv) waitStatus(t *testing.T, id string) *opermanager.OperStatus {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		status, err := env.operMng.GetOperationStatus(id)
+		require.NoError(t, err)
+		if status.Status != opermanager.StatusPending {
+			return status
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("operation %s is still pending", id)
+	return nil
+}
+
+func TestIntegration_DirectOperation(t *testing.T) {
+	env := newTestEnv(t, fakeydart.Options{OperationLatency: 50 * time.Millisecond})
+
+	id, err := env.operMng.StartOperation("ydart", "cat", "dog")
+	require.NoError(t, err)
+
+	status := env.waitStatus(t, id)
+	assert.Equal(t, opermanager.StatusDone, status.Status)
+
+	fileName, err := env.operMng.GetFileName(id)
+	require.NoError(t, err)
+	assert.FileExists(t, fileName)
+
+	// Оригинал сохранён вместе с параметрами генерации
+	var records []map[string]interface{}
+	require.NoError(t, env.storage.ForEach(opermanager.IMAGES_BUCKET, func(key string, data []byte) error {
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal(data, &record))
+		records = append(records, record)
+		return nil
+	}))
+	require.Len(t, records, 1)
+	info := records[0]["info"].(map[string]interface{})
+	assert.Equal(t, "cat", info["prompt"])
+	assert.Equal(t, "dog", info["negative"])
+	assert.Equal(t, "art://folder/yandex-art/latest", info["model"])
+	assert.Contains(t, info, "seed")
+}
+
+func TestIntegration_OperationError(t *testing.T) {
+	env := newTestEnv(t, fakeydart.Options{OperationErrorRate: 1})
+
+	id, err := env.operMng.StartOperation("ydart", "cat", "")
+	require.NoError(t, err)
+
+	status := env.waitStatus(t, id)
+	assert.Equal(t, opermanager.StatusError, status.Status)
+	assert.Contains(t, status.Error, "fake operation error")
+}
+
+func TestIntegration_GenerateErrorOpensCircuit(t *testing.T) {
+	env := newTestEnv(t, fakeydart.Options{GenerateErrorRate: 1})
+
+	for i := 0; i < 3; i++ {
+		_, err := env.operMng.StartOperation("ydart", "cat", "")
+		assert.Error(t, err)
+	}
+
+	health := env.operMng.GetProvidersHealth()
+	require.Len(t, health, 1)
+	assert.Equal(t, opermanager.CircuitOpen, health[0].State)
+	assert.Equal(t, 3, health[0].ConsecutiveFailures)
+}
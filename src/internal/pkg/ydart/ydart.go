@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"imgserver/internal/pkg/actioner"
+	"imgserver/internal/pkg/httpclient"
 	"imgserver/internal/pkg/imageprocessor"
 	"imgserver/internal/pkg/opermanager"
 	"imgserver/internal/pkg/promptmanager"
 	"imgserver/internal/pkg/timerange"
+	"math/rand"
 	"strconv"
 	"strings"
 	"time"
@@ -23,26 +25,53 @@ const (
 	FILE_PATH_OPTIONS        = "/data/ydart-options.json"
 	CoreBaseURL       string = "https://llm.api.cloud.yandex.net"
 	ProviderCode             = "YandexArt"
+	DefaultModel             = "yandex-art/latest"
+	defaultTimeout           = 60
 )
 
 var _ opermanager.ImageProvider = (*YdArt)(nil)
+var _ opermanager.GenerationInfoProvider = (*YdArt)(nil)
 
 type YdArtSecretOption struct {
 	FolderId string `json:"folder_id"`
 	ApiKey   string `json:"api_key"`
+	// AuthorizedKeyFile путь к авторизованному ключу сервисного аккаунта. Если указан, вместо api_key используется IAM-токен
+	AuthorizedKeyFile string `json:"authorized_key_file"`
+	// IamTokenURL адрес получения IAM-токена. По умолчанию IamTokenURL
+	IamTokenURL string `json:"iam_token_url"`
 }
 
 type YdArtSleepTime struct {
 	TimeRange *timerange.TimeRange `yaml:"time_range"`
 }
 
+// YdArtMessage дополнительное сообщение, которое добавляется к каждому промпту
+type YdArtMessage struct {
+	Text   string  `yaml:"text"`
+	Weight float64 `yaml:"weight"`
+}
+
 type YdArtOptions struct {
 	ImageGenerateThreshold int              `yaml:"image_generate_threshold"`
 	SleepTimes             []YdArtSleepTime `yaml:"sleep_time"`
+	// BaseURL адрес API. По умолчанию CoreBaseURL. Для работы без сети можно указать fakeydart
+	BaseURL string `yaml:"base_url"`
+	// TimeoutSeconds таймаут одного HTTP-запроса. По умолчанию defaultTimeout
+	TimeoutSeconds int                     `yaml:"timeout_seconds"`
+	Retry          httpclient.RetryOptions `yaml:"retry"`
+	// Model суффикс URI модели после каталога. По умолчанию DefaultModel
+	Model string `yaml:"model"`
+	// Seed не задан или -1 - случайный
+	Seed *int64 `yaml:"seed"`
+	// PromptWeight вес основного промпта. По умолчанию 1
+	PromptWeight float64 `yaml:"prompt_weight"`
+	// NegativeWeight вес негативного промпта. По умолчанию -1
+	NegativeWeight float64        `yaml:"negative_weight"`
+	Messages       []YdArtMessage `yaml:"messages"`
 }
 
 type YdArt struct {
-	httpClient      *http.Client
+	httpClient      *httpclient.Client
 	logger          *slog.Logger
 	soptions        *YdArtSecretOption
 	options         *YdArtOptions
@@ -51,6 +80,9 @@ type YdArt struct {
 	actioner        *actioner.Actioner
 	ipr             *imageprocessor.Ipr
 	properties      *opermanager.ProviderProperties
+	tokenSource     *iamTokenSource
+	baseURL         string
+	*opermanager.GenerationInfoCache
 }
 
 type getImageResponse struct {
@@ -71,12 +103,13 @@ type generateRequest struct {
 
 type generationOptions struct {
 	MimeType    string       `json:"mime_type"`
+	Seed        int64        `json:"seed,string"`
 	AspectRatio *aspectRatio `json:"aspectRatio"`
 }
 
 type generatePrompt struct {
-	Text   string `json:"text"`
-	Weight int    `json:"weight"`
+	Text   string  `json:"text"`
+	Weight float64 `json:"weight"`
 }
 
 type aspectRatio struct {
@@ -94,19 +127,52 @@ func NewYdArt(imageParameters imageprocessor.ImageParameters, promptManager *pro
 		panic(fmt.Sprintf("Can not read Yandex art options: %s, %v", FILE_PATH_OPTIONS, err))
 	}
 	//logger.Debug("Options ", "options", options)
+
+	ydArt, err := NewYdArtWithSecret(imageParameters, promptManager, logger, options, soptions)
+	if err != nil {
+		panic(fmt.Sprintf("Can not create Yandex art: %v", err))
+	}
+	return ydArt
+}
+
+// NewYdArtWithSecret создаёт провайдера с уже прочитанными секретами. Используется в тестах с fakeydart
+func NewYdArtWithSecret(imageParameters imageprocessor.ImageParameters, promptManager *promptmanager.PromptManager, logger *slog.Logger, options *YdArtOptions, soptions YdArtSecretOption) (*YdArt, error) {
+	if options.TimeoutSeconds <= 0 {
+		options.TimeoutSeconds = defaultTimeout
+	}
+	httpClient := httpclient.NewClient(time.Duration(options.TimeoutSeconds)*time.Second, options.Retry, logger)
+
+	var tokenSource *iamTokenSource
+	var err error
+	if soptions.AuthorizedKeyFile != "" {
+		tokenSource, err = newIamTokenSource(soptions.AuthorizedKeyFile, soptions.IamTokenURL, httpClient, logger)
+		if err != nil {
+			return nil, fmt.Errorf("can not read authorized key %s: %v", soptions.AuthorizedKeyFile, err)
+		}
+		logger.Info("YandexArt uses service account IAM token")
+	}
+
+	baseURL := strings.TrimRight(options.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = CoreBaseURL
+	}
+
 	return &YdArt{
-		httpClient:    http.DefaultClient,
-		logger:        logger,
-		soptions:      &soptions,
-		options:       options,
-		promptManager: promptManager,
-		actioner:      actioner.NewActioner(options.ImageGenerateThreshold, time.Minute),
-		ipr:           imageprocessor.NewIpr(imageParameters, logger),
+		baseURL:             baseURL,
+		tokenSource:         tokenSource,
+		GenerationInfoCache: opermanager.NewGenerationInfoCache(),
+		httpClient:          httpClient,
+		logger:              logger,
+		soptions:            &soptions,
+		options:             options,
+		promptManager:       promptManager,
+		actioner:            actioner.NewActioner(options.ImageGenerateThreshold, time.Minute),
+		ipr:                 imageprocessor.NewIpr(imageParameters, logger),
 		properties: &opermanager.ProviderProperties{
 			IsCanWorkWithPrompt:  true,
 			IsNeedSaveLocalFiles: true,
 		},
-	}
+	}, nil
 }
 
 func (ydArt *YdArt) SetImageParameters(parameters *opermanager.ImageParameters) error {
@@ -121,40 +187,27 @@ func (ydArt *YdArt) GetImageProviderCode() string {
 }
 
 func (ydArt *YdArt) Generate(isDirectCall bool) (string, error) {
-	prompt, err := ydArt.getPrompt()
+	prompt, negative, idx, err := ydArt.getPrompt()
 	if err != nil {
 		return "", err
 	}
-	return ydArt.GenerateWithPrompt(prompt, isDirectCall)
+	id, err := ydArt.GenerateWithPrompt(prompt, negative, isDirectCall)
+	if err == nil {
+		ydArt.SetPromptIdx(id, idx)
+	}
+	return id, err
 }
 
-func (ydArt *YdArt) GenerateWithPrompt(prompt string, isDirectCall bool) (string, error) {
+func (ydArt *YdArt) GenerateWithPrompt(prompt string, negative string, isDirectCall bool) (string, error) {
 	if prompt == "" {
 		return "", fmt.Errorf("prompt is empty")
 	}
 
-	ydArt.logger.Debug("generate with prompt", "prompt", prompt, "isDirect", isDirectCall)
-
-	generatePromptMessage := generatePrompt{Text: prompt,
-		Weight: 1}
-
-	ratio := aspectRatio{
-		HeightRatio: strconv.Itoa(ydArt.imageParameters.Height),
-		WidthRatio:  strconv.Itoa(ydArt.imageParameters.Weight),
-	}
-
-	generationOpt := generationOptions{
-		MimeType:    "image/jpeg",
-		AspectRatio: &ratio,
-	}
+	ydArt.logger.Debug("generate with prompt", "prompt", prompt, "negative", negative, "isDirect", isDirectCall)
 
-	request := generateRequest{
-		ModelUri:          "art://" + ydArt.soptions.FolderId + "/yandex-art/latest",
-		Messages:          []*generatePrompt{&generatePromptMessage},
-		GenerationOptions: &generationOpt,
-	}
+	request := ydArt.buildRequest(prompt, negative)
 
-	url := fmt.Sprintf("%s/foundationModels/v1/imageGenerationAsync", CoreBaseURL)
+	url := fmt.Sprintf("%s/foundationModels/v1/imageGenerationAsync", ydArt.baseURL)
 	var response getImageResponse
 	err := ydArt.innerRequest("POST", url, http.StatusOK, request, &response)
 
@@ -180,13 +233,82 @@ func (ydArt *YdArt) GenerateWithPrompt(prompt string, isDirectCall bool) (string
 		return "", resultError
 	}
 
-	ydArt.logger.Debug("YandexArt operation id", "id", response.Id)
+	seed := request.GenerationOptions.Seed
+	ydArt.PutGenerationInfo(response.Id, &opermanager.GenerationInfo{
+		Prompt:   prompt,
+		Negative: negative,
+		Model:    request.ModelUri,
+		Seed:     &seed,
+	})
+
+	ydArt.logger.Debug("YandexArt operation id", "id", response.Id, "seed", seed)
 	return response.Id, nil
 }
 
+// buildRequest собирает запрос: основной промпт, дополнительные сообщения из настроек
+// и негативный промпт отдельным сообщением с отрицательным весом
+func (ydArt *YdArt) buildRequest(prompt string, negative string) *generateRequest {
+	promptWeight := ydArt.options.PromptWeight
+	if promptWeight == 0 {
+		promptWeight = 1
+	}
+	messages := []*generatePrompt{{Text: prompt, Weight: promptWeight}}
+
+	for _, message := range ydArt.options.Messages {
+		if strings.TrimSpace(message.Text) == "" {
+			continue
+		}
+		weight := message.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		messages = append(messages, &generatePrompt{Text: message.Text, Weight: weight})
+	}
+
+	if strings.TrimSpace(negative) != "" {
+		negativeWeight := ydArt.options.NegativeWeight
+		if negativeWeight == 0 {
+			negativeWeight = -1
+		}
+		messages = append(messages, &generatePrompt{Text: negative, Weight: negativeWeight})
+	}
+
+	return &generateRequest{
+		ModelUri: ydArt.modelUri(),
+		Messages: messages,
+		GenerationOptions: &generationOptions{
+			MimeType: "image/jpeg",
+			Seed:     ydArt.seed(),
+			AspectRatio: &aspectRatio{
+				HeightRatio: strconv.Itoa(ydArt.imageParameters.Height),
+				WidthRatio:  strconv.Itoa(ydArt.imageParameters.Weight),
+			},
+		},
+	}
+}
+
+func (ydArt *YdArt) modelUri() string {
+	model := ydArt.options.Model
+	if model == "" {
+		model = DefaultModel
+	}
+	if strings.HasPrefix(model, "art://") {
+		return model
+	}
+	return "art://" + ydArt.soptions.FolderId + "/" + strings.TrimPrefix(model, "/")
+}
+
+// seed случайный seed генерируется здесь, а не провайдером, чтобы его можно было сохранить
+func (ydArt *YdArt) seed() int64 {
+	if ydArt.options.Seed != nil && *ydArt.options.Seed >= 0 {
+		return *ydArt.options.Seed
+	}
+	return rand.Int63()
+}
+
 func (ydArt *YdArt) GetImageSlice(operationId string) (bool, []byte, error) {
 	ydArt.logger.Debug("Get image request")
-	url := fmt.Sprintf("%s/operations/%s", CoreBaseURL, operationId)
+	url := fmt.Sprintf("%s/operations/%s", ydArt.baseURL, operationId)
 	var response getImageResponse
 	err := ydArt.innerRequest("GET", url, http.StatusOK, nil, &response)
 	if err != nil {
@@ -250,22 +372,21 @@ func (ydArt *YdArt) GetProperties() *opermanager.ProviderProperties {
 	return ydArt.properties
 }
 
-func (ydArt *YdArt) getPrompt() (string, error) {
+func (ydArt *YdArt) getPrompt() (string, string, int, error) {
 	prompt, err := ydArt.promptManager.GetRandomPrompt()
 	if err != nil {
 		ydArt.logger.Error("Error when get prompt", "error", err.Error())
 		ydArt.logger.Debug("Return default prompt", "prompt", "test")
-		return "test", err
+		return "test", "", 0, err
 	}
 
-	result := prompt.Prompt
-
-	if prompt.Negative != nil && strings.Trim(*prompt.Negative, "") != "" {
-		result = result + ". Игнорировать следующее: " + *prompt.Negative
+	negative := ""
+	if prompt.Negative != nil {
+		negative = *prompt.Negative
 	}
 
-	ydArt.logger.Debug("result prompt", "prompt", result)
-	return result, nil
+	ydArt.logger.Debug("result prompt", "prompt", prompt.Prompt, "negative", negative)
+	return prompt.Prompt, negative, prompt.Idx, nil
 }
 
 func (ydArt *YdArt) innerRequest(method string, url string, expectedStatus int, requestBody interface{}, result interface{}) error {
@@ -282,7 +403,7 @@ func (ydArt *YdArt) innerRequest(method string, url string, expectedStatus int,
 		jsonData, err1 := json.Marshal(requestBody)
 		if err1 != nil {
 			resultError := fmt.Errorf("error when data marshalling: %v", err1)
-			ydArt.logger.Error("error when data marshaling", resultError)
+			ydArt.logger.Error("error when data marshaling", "error", resultError)
 			return resultError
 		}
 
@@ -295,13 +416,20 @@ func (ydArt *YdArt) innerRequest(method string, url string, expectedStatus int,
 	}
 
 	// Устанавливаем заголовок авторизации
-	req.Header.Set("Authorization", fmt.Sprintf("Api-Key %s", ydArt.soptions.ApiKey))
+	authorization, err := ydArt.authorizationHeader()
+	if err != nil {
+		resultError := fmt.Errorf("error when get authorization: %v", err)
+		ydArt.logger.Error(resultError.Error())
+		return resultError
+	}
+	req.Header.Set("Authorization", authorization)
 	req.Header.Set("Accept", "application/json") // Ожидание ответа в формате JSON
 
 	// ydArt.logger.Debug("request", "request", req)
 
 	// Выполняем запрос
-	resp, err := ydArt.httpClient.Do(req)
+	// POST генерации платный и повторяется, только если сервер его не выполнял
+	resp, err := ydArt.httpClient.Do(httpclient.WithSafeRetry(req))
 	if err != nil {
 		resultError := fmt.Errorf("error when execute request: %v", err)
 		ydArt.logger.Error(resultError.Error())
@@ -310,6 +438,11 @@ func (ydArt *YdArt) innerRequest(method string, url string, expectedStatus int,
 
 	defer resp.Body.Close()
 
+	// Токен мог быть отозван раньше срока. Следующий запрос получит новый
+	if resp.StatusCode == http.StatusUnauthorized && ydArt.tokenSource != nil {
+		ydArt.tokenSource.Invalidate()
+	}
+
 	// Проверяем статус код
 	if resp.StatusCode != expectedStatus {
 		resultError := fmt.Errorf("unexpected status code: %s", resp.Status)
@@ -336,6 +469,18 @@ func (ydArt *YdArt) innerRequest(method string, url string, expectedStatus int,
 	return nil
 }
 
+// authorizationHeader Bearer с IAM-токеном для сервисного аккаунта, иначе Api-Key
+func (ydArt *YdArt) authorizationHeader() (string, error) {
+	if ydArt.tokenSource != nil {
+		token, err := ydArt.tokenSource.Token()
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	}
+	return "Api-Key " + ydArt.soptions.ApiKey, nil
+}
+
 func (ydArt *YdArt) logBody(resp *http.Response) {
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -343,7 +488,7 @@ func (ydArt *YdArt) logBody(resp *http.Response) {
 		ydArt.logger.Error(resultError.Error())
 		return
 	}
-	ydArt.logger.Error("Get response: %s ", string(body))
+	ydArt.logger.Error("Get response", "body", string(body))
 
 }
 
@@ -0,0 +1,76 @@
+package ydart
+
+import (
+	"encoding/json"
+	"imgserver/internal/pkg/opermanager"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestYdArt(options *YdArtOptions) *YdArt {
+	return &YdArt{
+		soptions:            &YdArtSecretOption{FolderId: "folder"},
+		options:             options,
+		imageParameters:     &opermanager.ImageParameters{Height: 480, Weight: 320},
+		GenerationInfoCache: opermanager.NewGenerationInfoCache(),
+	}
+}
+
+func TestYdArt_BuildRequestDefaults(t *testing.T) {
+	ydArt := newTestYdArt(&YdArtOptions{})
+
+	request := ydArt.buildRequest("cat", "")
+	assert.Equal(t, "art://folder/yandex-art/latest", request.ModelUri)
+	require.Len(t, request.Messages, 1)
+	assert.Equal(t, generatePrompt{Text: "cat", Weight: 1}, *request.Messages[0])
+	assert.GreaterOrEqual(t, request.GenerationOptions.Seed, int64(0))
+	assert.Equal(t, "480", request.GenerationOptions.AspectRatio.HeightRatio)
+}
+
+func TestYdArt_BuildRequestWeightedMessages(t *testing.T) {
+	seed := int64(1863)
+	ydArt := newTestYdArt(&YdArtOptions{
+		Model:          "yandex-art/rc",
+		Seed:           &seed,
+		PromptWeight:   2,
+		NegativeWeight: -0.5,
+		Messages:       []YdArtMessage{{Text: "oil painting"}, {Text: " "}, {Text: "warm colors", Weight: 0.3}},
+	})
+
+	request := ydArt.buildRequest("cat", "dog")
+	assert.Equal(t, "art://folder/yandex-art/rc", request.ModelUri)
+	assert.Equal(t, []*generatePrompt{
+		{Text: "cat", Weight: 2},
+		{Text: "oil painting", Weight: 1},
+		{Text: "warm colors", Weight: 0.3},
+		{Text: "dog", Weight: -0.5},
+	}, request.Messages)
+
+	// API ожидает int64 строкой
+	data, err := json.Marshal(request.GenerationOptions)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"seed":"1863"`)
+}
+
+func TestYdArt_NegativeDefaultWeightAndFullModelUri(t *testing.T) {
+	ydArt := newTestYdArt(&YdArtOptions{Model: "art://other/yandex-art/latest"})
+
+	request := ydArt.buildRequest("cat", "dog")
+	assert.Equal(t, "art://other/yandex-art/latest", request.ModelUri)
+	assert.Equal(t, generatePrompt{Text: "dog", Weight: -1}, *request.Messages[1])
+}
+
+func TestYdArt_GetGenerationInfoOnce(t *testing.T) {
+	ydArt := newTestYdArt(&YdArtOptions{})
+	seed := int64(7)
+	ydArt.PutGenerationInfo("op1", &opermanager.GenerationInfo{Prompt: "cat", Seed: &seed})
+	ydArt.SetPromptIdx("op1", 3)
+
+	info := ydArt.GetGenerationInfo("op1")
+	require.NotNil(t, info)
+	assert.Equal(t, int64(7), *info.Seed)
+	assert.Equal(t, 3, info.PromptIdx)
+	assert.Nil(t, ydArt.GetGenerationInfo("op1"))
+}